@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+	"log"
+	"os"
+)
+
+var accountsFixture string
+var accountsOutput string
+
+var accountsCmd = &cobra.Command{
+	Use:   "accounts",
+	Short: "Look at the cached accounts and their cases",
+}
+
+// accountDetails is everything 'accounts show' knows about an account
+type accountDetails struct {
+	AccountNumber string
+	Account       *cache.Account `json:",omitempty" yaml:",omitempty"`
+	Cases         []cache.Case
+	// History holds the recorded changes of each case, by case id
+	History map[string][]cache.CaseHistory
+}
+
+var accountsShowCmd = &cobra.Command{
+	Use:   "show <number>",
+	Short: "Show the cached details of an account with all its cached cases and their history",
+	Long: `Shows the cached details of an account, then all its cached cases, including closed and stale ones,
+	and the changes recorded for each of them.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c := OpenCacheOrDie(accountsFixture)
+		c.SetIncludeStale(true)
+		details := accountDetails{AccountNumber: args[0], History: map[string][]cache.CaseHistory{}}
+		account, err := c.GetAccount(args[0])
+		if err == nil {
+			details.Account = &account
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Fatalf("Error:  Unable to read account '%s': %s", args[0], err)
+		}
+		details.Cases, err = c.ListCases(cache.CaseFilter{AccountNumber: args[0]})
+		if err != nil {
+			log.Fatalf("Error:  Unable to read cases of account '%s': %s", args[0], err)
+		}
+		if details.Account == nil && len(details.Cases) == 0 {
+			log.Fatalf("Error:  Unable to find account '%s'", args[0])
+		}
+		for _, myCase := range details.Cases {
+			details.History[myCase.Id], err = c.GetCaseHistory(myCase.Id)
+			if err != nil {
+				log.Fatalf("Error:  Unable to read history of case '%s': %s", myCase.Id, err)
+			}
+		}
+
+		if accountsOutput != OutputTable {
+			err = writeStructured(os.Stdout, accountsOutput, details)
+			if err != nil {
+				log.Fatalf("Error:  Unable to write account: %s", err)
+			}
+			return
+		}
+		if a := details.Account; a != nil {
+			fmt.Printf("Account %s: %s\n", a.AccountNumber, a.Name)
+			fmt.Printf("  Segment: %s, CSM: %s (%s)\n", a.GSCSMSegment, a.CSMUserName, a.CSMUserSSOName)
+			fmt.Printf("  Strategic: %t, Enhanced SLA: %t, SRM: %t, TAM: %t\n", a.Strategic, a.HasEnhancedSLA, a.HasSRM, a.HasTAM)
+		} else {
+			fmt.Printf("Account %s: no details cached\n", details.AccountNumber)
+		}
+		fmt.Printf("\n%d Cases:\n", len(details.Cases))
+		columns, err := selectCaseColumns([]string{"number", "severity", "status", "owner", "escalated", "modified", "summary"})
+		if err != nil {
+			log.Fatalf("Error:  %s", err)
+		}
+		err = writeCases(os.Stdout, OutputTable, columns, details.Cases)
+		if err != nil {
+			log.Fatalf("Error:  Unable to write cases: %s", err)
+		}
+		fmt.Printf("\nHistory:\n")
+		for _, myCase := range details.Cases {
+			for _, h := range details.History[myCase.Id] {
+				fmt.Printf("  %s  %s  %s: %q -> %q\n", myCase.CaseNumber, formatTime(h.ChangedAt), h.Field, h.OldValue, h.NewValue)
+			}
+		}
+	},
+}
+
+func init() {
+	accountsCmd.PersistentFlags().StringVar(&accountsFixture, "fixture", "", "read accounts and cases from this JSON file rather than the cache")
+	accountsCmd.PersistentFlags().StringVarP(&accountsOutput, "output", "o", OutputTable, "output format: table, json or yaml")
+	accountsCmd.AddCommand(accountsShowCmd)
+	rootCmd.AddCommand(accountsCmd)
+}
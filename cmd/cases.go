@@ -0,0 +1,264 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var triageArea string
+var grepLimit int
+var casesOutput string
+var listFilter cache.CaseFilter
+var listColumns []string
+var listModifiedSince string
+var listEscalated bool
+var listIncludeStale bool
+
+// casesCmd groups the commands which look at or act on individual cached cases
+var casesCmd = &cobra.Command{
+	Use:     "cases",
+	Aliases: []string{"case"},
+	Short:   "Inspect and triage cached cases",
+	Long:    `Inspect and triage cases stored in the local cache`,
+}
+
+var casesExplainCmd = &cobra.Command{
+	Use:   "explain <id|number>",
+	Short: "Explain which classification rule matched a case",
+	Long: `Evaluates each configured classification rule, in order, against a cached case
+	and shows which conditions matched. The first matching rule is the one applied.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := cache.Init(DatabaseDSN())
+		if err != nil {
+			log.Fatalf("Error:  Unable to initialize cache: %s", err)
+		}
+		myCase, err := c.GetCase(args[0])
+		if err != nil {
+			log.Fatalf("Error:  Unable to find case '%s': %s", args[0], err)
+		}
+
+		fmt.Printf("Case %s (%s): %s\n", myCase.CaseNumber, myCase.Id, myCase.Summary)
+		fmt.Printf("Stored classification: relevance=%q area=%q classified by=%q\n", myCase.Relevance, myCase.Area, myCase.ClassifiedBy)
+		if myCase.ClassifiedBy == cache.ClassifiedManually {
+			fmt.Println("Case was triaged manually, classification rules are not applied to it")
+		}
+
+		rules := LoadRuleSetOrDie(&c)
+		if rules == nil {
+			fmt.Println("No 'classification_rules' are configured")
+			return
+		}
+		applied := ""
+		for _, e := range rules.Explain(myCase) {
+			result := "no match"
+			if e.Matched {
+				result = "match"
+				if applied == "" {
+					applied = e.Rule
+				}
+			}
+			fmt.Printf("  [%s] %s: %s\n", result, e.Rule, strings.Join(e.Reasons, ", "))
+		}
+		switch {
+		case applied == "":
+			fmt.Println("No rule matches this case")
+		case myCase.ClassifiedBy == cache.ClassifiedManually:
+			fmt.Printf("Rule that would apply without manual triage: %s\n", applied)
+		default:
+			fmt.Printf("Rule applied: %s\n", applied)
+		}
+	},
+}
+
+var casesTriageCmd = &cobra.Command{
+	Use:   "triage <id> <relevant|ignored>",
+	Short: "Manually mark a case as relevant or ignored",
+	Long:  `Records a manual triage decision, classification rules will no longer change the case`,
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		relevance := args[1]
+		if relevance != cache.RelevanceRelevant && relevance != cache.RelevanceIgnored {
+			log.Fatalf("Error:  relevance must be '%s' or '%s', not '%s'", cache.RelevanceRelevant, cache.RelevanceIgnored, relevance)
+		}
+		c, err := cache.Init(DatabaseDSN())
+		if err != nil {
+			log.Fatalf("Error:  Unable to initialize cache: %s", err)
+		}
+		myCase, err := c.GetCase(args[0])
+		if err != nil {
+			log.Fatalf("Error:  Unable to find case '%s': %s", args[0], err)
+		}
+		err = c.SetTriage(myCase.Id, relevance, triageArea)
+		if err != nil {
+			log.Fatalf("Error:  Unable to triage case '%s': %s", args[0], err)
+		}
+	},
+}
+
+var casesGrepCmd = &cobra.Command{
+	Use:   "grep <query>",
+	Short: "Full text search over cached cases",
+	Long: `Searches the summaries of cached cases, best matches first.
+	The query uses SQLite FTS5 syntax, e.g. '"velero restore"' to match a phrase or 'velero NOT backup'.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := cache.Init(DatabaseDSN())
+		if err != nil {
+			log.Fatalf("Error:  Unable to initialize cache: %s", err)
+		}
+		matches, err := c.SearchText(strings.Join(args, " "), grepLimit)
+		if err != nil {
+			log.Fatalf("Error:  Unable to search cases: %s", err)
+		}
+		for _, m := range matches {
+			fmt.Printf("%s\t%s\t%s\n", m.CaseNumber, m.Id, m.Snippet)
+		}
+	},
+}
+
+var casesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List cached cases",
+	Long: `Lists cached cases matching the given filters.
+	Output is a table by default, or json, yaml or csv with --output.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		columns, err := selectCaseColumns(listColumns)
+		if err != nil {
+			log.Fatalf("Error:  %s", err)
+		}
+		if listModifiedSince != "" {
+			listFilter.ModifiedSince, err = parseSince(listModifiedSince)
+			if err != nil {
+				log.Fatalf("Error:  Unable to parse --modified-since: %s", err)
+			}
+		}
+		if cmd.Flags().Changed("escalated") {
+			listFilter.Escalated = &listEscalated
+		}
+		c, err := cache.Init(DatabaseDSN())
+		if err != nil {
+			log.Fatalf("Error:  Unable to initialize cache: %s", err)
+		}
+		c.SetIncludeStale(listIncludeStale)
+		cases, err := c.ListCases(listFilter)
+		if err != nil {
+			log.Fatalf("Error:  Unable to list cases: %s", err)
+		}
+		err = writeCases(os.Stdout, casesOutput, columns, cases)
+		if err != nil {
+			log.Fatalf("Error:  Unable to write cases: %s", err)
+		}
+	},
+}
+
+// caseDetails is everything 'cases show' knows about a case
+type caseDetails struct {
+	Case    cache.Case
+	Account *cache.Account `json:",omitempty" yaml:",omitempty"`
+	History []cache.CaseHistory
+}
+
+var casesShowCmd = &cobra.Command{
+	Use:   "show <id|number>",
+	Short: "Show all cached details of a case",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := cache.Init(DatabaseDSN())
+		if err != nil {
+			log.Fatalf("Error:  Unable to initialize cache: %s", err)
+		}
+		details := caseDetails{}
+		details.Case, err = c.GetCase(args[0])
+		if err != nil {
+			log.Fatalf("Error:  Unable to find case '%s': %s", args[0], err)
+		}
+		if details.Case.AccountNumber != "" {
+			account, err := c.GetAccount(details.Case.AccountNumber)
+			if err == nil {
+				details.Account = &account
+			}
+		}
+		details.History, err = c.GetCaseHistory(details.Case.Id)
+		if err != nil {
+			log.Fatalf("Error:  Unable to read history of case '%s': %s", args[0], err)
+		}
+
+		if casesOutput != OutputTable {
+			err = writeStructured(os.Stdout, casesOutput, details)
+			if err != nil {
+				log.Fatalf("Error:  Unable to write case: %s", err)
+			}
+			return
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, col := range caseColumns {
+			fmt.Fprintf(tw, "%s:\t%v\n", col.Name, col.Value(details.Case))
+		}
+		tw.Flush()
+		if a := details.Account; a != nil {
+			fmt.Printf("\nAccount %s: %s\n", a.AccountNumber, a.Name)
+			fmt.Printf("  Segment: %s, CSM: %s (%s)\n", a.GSCSMSegment, a.CSMUserName, a.CSMUserSSOName)
+			fmt.Printf("  Strategic: %t, Enhanced SLA: %t, SRM: %t, TAM: %t\n", a.Strategic, a.HasEnhancedSLA, a.HasSRM, a.HasTAM)
+		} else if details.Case.AccountNumber != "" {
+			fmt.Printf("\nAccount %s: no details cached\n", details.Case.AccountNumber)
+		}
+		fmt.Printf("\nHistory:\n")
+		if len(details.History) == 0 {
+			fmt.Println("  no changes recorded")
+		}
+		for _, h := range details.History {
+			fmt.Printf("  %s  %s: %q -> %q\n", formatTime(h.ChangedAt), h.Field, h.OldValue, h.NewValue)
+		}
+	},
+}
+
+// parseSince accepts a date, an RFC3339 timestamp or a duration such as '72h' or '14d' before now
+func parseSince(value string) (time.Time, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err == nil {
+			return time.Now().AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func init() {
+	casesCmd.PersistentFlags().StringVarP(&casesOutput, "output", "o", OutputTable, "output format: table, json, yaml or csv")
+
+	casesListCmd.Flags().StringSliceVar(&listFilter.Statuses, "status", nil, "only cases with one of these statuses")
+	casesListCmd.Flags().StringSliceVar(&listFilter.Severities, "severity", nil, "only cases with one of these severities")
+	casesListCmd.Flags().StringVar(&listFilter.Product, "product", "", "only cases for this product")
+	casesListCmd.Flags().StringVar(&listFilter.Owner, "owner", "", "only cases with this owner")
+	casesListCmd.Flags().StringVar(&listFilter.AccountNumber, "account", "", "only cases for this account number")
+	casesListCmd.Flags().StringVar(&listModifiedSince, "modified-since", "", "only cases modified since a date (2006-01-02), timestamp or age such as '14d'")
+	casesListCmd.Flags().StringVar(&listFilter.Triage, "triage", "", "only cases with triage state: relevant, ignored, untriaged or manual")
+	casesListCmd.Flags().BoolVar(&listEscalated, "escalated", false, "only escalated cases, or with --escalated=false only cases not escalated")
+	casesListCmd.Flags().StringVar(&listFilter.SortBy, "sort", "", "column to sort by, prefix with '-' for descending (default '-modified')")
+	casesListCmd.Flags().BoolVar(&listIncludeStale, "include-stale", false, "include cases no longer returned by the search")
+	casesListCmd.Flags().StringSliceVar(&listColumns, "columns", nil, "columns to output (default "+strings.Join(defaultCaseColumns, ",")+")")
+	casesCmd.AddCommand(casesListCmd)
+	casesCmd.AddCommand(casesShowCmd)
+
+	casesGrepCmd.Flags().IntVar(&grepLimit, "limit", 25, "maximum number of matches to show")
+	casesCmd.AddCommand(casesGrepCmd)
+	casesTriageCmd.Flags().StringVar(&triageArea, "area", "", "team area label to assign to the case")
+	casesCmd.AddCommand(casesExplainCmd)
+	casesCmd.AddCommand(casesTriageCmd)
+	rootCmd.AddCommand(casesCmd)
+}
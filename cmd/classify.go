@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/jwmatthews/case_watcher/pkg/classify"
+	"github.com/spf13/cobra"
+	"log"
+)
+
+var classifyFolds int
+var classifyIncludeRules bool
+
+var classifyCmd = &cobra.Command{
+	Use:   "classify",
+	Short: "Train and evaluate the local relevance classifier",
+	Long: `Works with a naive Bayes classifier trained from cases triaged as relevant or ignored.
+	The trained model is kept in the cache and scores each newly matched case with a relevance probability.`,
+}
+
+var classifyTrainCmd = &cobra.Command{
+	Use:   "train",
+	Short: "Train the classifier from triaged cases and rescore the cache",
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := cache.Init(DatabaseDSN())
+		if err != nil {
+			log.Fatalf("Error:  Unable to initialize cache: %s", err)
+		}
+		cases, err := c.GetAllCases()
+		if err != nil {
+			log.Fatalf("Error:  Unable to read cases: %s", err)
+		}
+		examples := classify.TrainingCases(cases, classifyIncludeRules)
+		model, err := classify.Train(examples)
+		if err != nil {
+			log.Fatalf("Error:  Unable to train classifier: %s", err)
+		}
+		data, err := model.Marshal()
+		if err != nil {
+			log.Fatalf("Error:  Unable to serialize classifier: %s", err)
+		}
+		err = c.SaveClassifierModel(classify.ModelName, data)
+		if err != nil {
+			log.Fatalf("Error:  Unable to save classifier: %s", err)
+		}
+		fmt.Printf("Trained on %d cases (%d relevant, %d ignored), vocabulary of %d tokens\n",
+			len(examples), model.Docs[cache.RelevanceRelevant], model.Docs[cache.RelevanceIgnored], model.Vocabulary)
+
+		rescored := 0
+		for _, myCase := range cases {
+			if myCase.ClassifiedBy == cache.ClassifiedManually {
+				continue
+			}
+			err = c.SetScore(myCase.Id, model.Score(myCase))
+			if err != nil {
+				log.Fatalf("Error:  Unable to save score of case '%s': %s", myCase.Id, err)
+			}
+			rescored++
+		}
+		fmt.Printf("Rescored %d cached cases\n", rescored)
+	},
+}
+
+var classifyEvaluateCmd = &cobra.Command{
+	Use:   "evaluate",
+	Short: "Cross validate the classifier against triaged cases",
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := cache.Init(DatabaseDSN())
+		if err != nil {
+			log.Fatalf("Error:  Unable to initialize cache: %s", err)
+		}
+		cases, err := c.GetAllCases()
+		if err != nil {
+			log.Fatalf("Error:  Unable to read cases: %s", err)
+		}
+		examples := classify.TrainingCases(cases, classifyIncludeRules)
+		folds, err := classify.CrossValidate(examples, classifyFolds)
+		if err != nil {
+			log.Fatalf("Error:  Unable to cross validate classifier with %d triaged cases: %s", len(examples), err)
+		}
+		fmt.Printf("%d-fold cross validation over %d triaged cases\n", classifyFolds, len(examples))
+		fmt.Printf("%-8s %8s %9s %10s %7s %7s\n", "Fold", "Examples", "Accuracy", "Precision", "Recall", "F1")
+		for i, m := range folds {
+			printMetrics(fmt.Sprintf("%d", i+1), m)
+		}
+		printMetrics("Total", classify.Sum(folds))
+	},
+}
+
+func printMetrics(label string, m classify.Metrics) {
+	fmt.Printf("%-8s %8d %9.3f %10.3f %7.3f %7.3f\n", label, m.Examples, m.Accuracy(), m.Precision(), m.Recall(), m.F1())
+}
+
+func init() {
+	classifyCmd.PersistentFlags().BoolVar(&classifyIncludeRules, "include-rules", false, "also learn from cases classified by rules, not only manual triage")
+	classifyEvaluateCmd.Flags().IntVar(&classifyFolds, "folds", 5, "number of cross validation folds")
+	classifyCmd.AddCommand(classifyTrainCmd)
+	classifyCmd.AddCommand(classifyEvaluateCmd)
+	rootCmd.AddCommand(classifyCmd)
+}
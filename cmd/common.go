@@ -1,19 +1,32 @@
 package cmd
 
 import (
+	"errors"
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/jwmatthews/case_watcher/pkg/classify"
+	"github.com/jwmatthews/case_watcher/pkg/rollup"
+	"github.com/jwmatthews/case_watcher/pkg/sla"
+	"github.com/jwmatthews/case_watcher/pkg/trends"
 	"github.com/spf13/viper"
+	"gorm.io/gorm"
 	"log"
+	"time"
 )
 
+// DatabaseDSN returns the 'database' setting, a PostgreSQL DSN or SQLite file path, defaulting to DBName
+func DatabaseDSN() string {
+	if dsn := viper.GetString("database"); dsn != "" {
+		return dsn
+	}
+	return DBName
+}
+
 func VerifyParamsOrDie() {
 	var url = viper.GetString("url")
 	var username = viper.GetString("username")
 	var password = viper.GetString("password")
 	var searchQuery = viper.GetString("query")
 	var spreadsheetId = viper.GetString("spreadsheet")
-	var email = viper.GetString("client_email")
-	var privkey = viper.GetString("private_key")
-	var privkeyId = viper.GetString("private_key_id")
 
 	if url == "" {
 		log.Fatalln("Unable to find 'url'")
@@ -30,13 +43,128 @@ func VerifyParamsOrDie() {
 	if spreadsheetId == "" {
 		log.Fatalln("Unable to find 'spreadsheetId'")
 	}
-	if email == "" {
-		log.Fatalln("Unable to find 'email'")
+	// Google credentials may come from 'google_credentials_file', the key fields or the environment, see sheetsSinkOrDie
+}
+
+// SpreadsheetIdOrDie returns the 'spreadsheet' setting, for commands that work from the cache without the case API.
+// Google credentials are checked by sheetsSinkOrDie.
+func SpreadsheetIdOrDie() string {
+	spreadsheetId := viper.GetString("spreadsheet")
+	if spreadsheetId == "" {
+		log.Fatalln("Unable to find 'spreadsheetId'")
+	}
+	return spreadsheetId
+}
+
+// LoadRuleSetOrDie reads 'classification_rules' from configuration, returns nil when none are configured.
+// SLA conditions are measured against the 'sla' policy, with the accounts of cases looked up in the cache.
+func LoadRuleSetOrDie(c cache.Store) *classify.RuleSet {
+	rules := make([]classify.Rule, 0)
+	err := viper.UnmarshalKey("classification_rules", &rules)
+	if err != nil {
+		log.Fatalf("Error:  Unable to parse 'classification_rules': %s", err)
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	rs, err := classify.NewRuleSet(rules)
+	if err != nil {
+		log.Fatalf("Error:  Invalid 'classification_rules': %s", err)
+	}
+	if !rs.UsesSLA() {
+		return rs
+	}
+	policy := SLAPolicyOrDie()
+	if !policy.Enabled() {
+		log.Fatalf("Error:  Invalid 'classification_rules': SLA conditions need targets under 'sla'")
+	}
+	accounts := func(accountNumber string) cache.Account {
+		// A case whose account is not cached is measured against the standard targets
+		account, _ := c.GetAccount(accountNumber)
+		return account
+	}
+	return rs.WithSLA(policy, accounts, time.Time{})
+}
+
+// SLAPolicyOrDie reads the response and update targets by severity from 'sla', a policy without targets when none are configured
+func SLAPolicyOrDie() sla.Policy {
+	policy := sla.Policy{}
+	err := viper.UnmarshalKey("sla", &policy)
+	if err == nil {
+		err = policy.Validate()
+	}
+	if err != nil {
+		log.Fatalf("Error:  Unable to parse 'sla': %s", err)
+	}
+	return policy
+}
+
+// TrendOptionsOrDie reads the periods the email report charts trends over from 'report_trends', none when not configured
+func TrendOptionsOrDie() trends.Options {
+	options := trends.Options{}
+	err := viper.UnmarshalKey("report_trends", &options)
+	if err == nil && options.Periods > 0 {
+		err = options.Validate()
+	}
+	if err != nil {
+		log.Fatalf("Error:  Unable to parse 'report_trends': %s", err)
+	}
+	return options
+}
+
+// OwnerOptionsOrDie reads the aliases of team members and after how many days cases are idle from 'owners'
+func OwnerOptionsOrDie() rollup.OwnerOptions {
+	options := rollup.OwnerOptions{}
+	err := viper.UnmarshalKey("owners", &options)
+	if err == nil {
+		err = options.Validate()
+	}
+	if err != nil {
+		log.Fatalf("Error:  Unable to parse 'owners': %s", err)
+	}
+	return options
+}
+
+// LoadClassifierOrDie combines the configured classification rules with the trained relevance model,
+// returns nil when neither is available
+func LoadClassifierOrDie(c cache.Store) cache.Classifier {
+	chain := classify.Chain{}
+	if rules := LoadRuleSetOrDie(c); rules != nil {
+		chain = append(chain, rules)
+	}
+	data, err := c.LoadClassifierModel(classify.ModelName)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Fatalf("Error:  Unable to load trained classifier: %s", err)
+	}
+	if err == nil {
+		model, err := classify.UnmarshalModel(data)
+		if err != nil {
+			log.Fatalf("Error:  Unable to parse trained classifier: %s", err)
+		}
+		chain = append(chain, model)
+	}
+	if len(chain) == 0 {
+		return nil
+	}
+	return chain
+}
+
+// OpenCacheOrDie opens the configured cache, or when fixture is set an ephemeral in-memory cache populated from that file
+func OpenCacheOrDie(fixture string) cache.Cache {
+	if fixture == "" {
+		c, err := cache.Init(DatabaseDSN())
+		if err != nil {
+			log.Fatalf("Error:  Unable to initialize cache: %s", err)
+		}
+		return c
 	}
-	if privkey == "" {
-		log.Fatalln("Unable to find 'privkey'")
+	c, err := cache.Init(cache.MemoryDSN)
+	if err != nil {
+		log.Fatalf("Error:  Unable to initialize in-memory cache: %s", err)
 	}
-	if privkeyId == "" {
-		log.Fatalln("Unable to find 'privkeyId'")
+	err = cache.LoadFixture(&c, fixture)
+	if err != nil {
+		log.Fatalf("Error:  Unable to load fixture '%s': %s", fixture, err)
 	}
+	return c
 }
@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+var migrateNoBackup bool
+var pruneDryRun bool
+var exportOutput string
+var exportFormat string
+
+// Formats accepted by 'db export --format'
+const (
+	ExportJSONL  = "jsonl"
+	ExportSQLite = "sqlite"
+)
+
+// dbCmd groups the commands maintaining the cache database itself
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Maintain the cache database",
+	Long:  `Maintain the cache database, e.g. inspect and apply schema migrations or prune old data`,
+}
+
+var dbStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the schema version and any pending migrations",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := cache.Open(DatabaseDSN(), cache.Options{SkipMigrations: true})
+		if err != nil {
+			log.Fatalf("Error:  Unable to open cache: %s", err)
+		}
+		applied, err := c.AppliedMigrations()
+		if err != nil {
+			log.Fatalf("Error:  Unable to read applied migrations: %s", err)
+		}
+		pending, err := c.PendingMigrations()
+		if err != nil {
+			log.Fatalf("Error:  Unable to determine pending migrations: %s", err)
+		}
+		version := 0
+		if len(applied) > 0 {
+			version = applied[len(applied)-1].Version
+		}
+		fmt.Printf("Schema version: %d (latest %d)\n", version, len(cache.Migrations()))
+		for _, a := range applied {
+			fmt.Printf("  applied  %3d  %s  %s\n", a.Version, a.AppliedAt.Format("2006-01-02 15:04:05"), a.Name)
+		}
+		for _, p := range pending {
+			fmt.Printf("  pending  %3d  %19s  %s\n", p.Version, "", p.Name)
+		}
+	},
+}
+
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply pending schema migrations",
+	Long: `Applies pending schema migrations, each in its own transaction.
+	A SQLite database file is backed up next to itself before any migration is applied.
+	Migrations are also applied automatically whenever the cache is opened by other commands.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := cache.Open(DatabaseDSN(), cache.Options{SkipMigrations: true})
+		if err != nil {
+			log.Fatalf("Error:  Unable to open cache: %s", err)
+		}
+		applied, backupPath, err := c.Migrate(!migrateNoBackup)
+		if backupPath != "" {
+			fmt.Printf("Backed up database to %s\n", backupPath)
+		}
+		for _, m := range applied {
+			fmt.Printf("Applied migration %d: %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("Error:  %s", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+	},
+}
+
+var dbPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove old data as configured under 'retention'",
+	Long: `Removes closed cases not modified for 'retention.closed_case_months' months and merges
+	case history and runs older than 'retention.compact_after_days' days into one row per day,
+	then reclaims the freed space with VACUUM. With --dry-run nothing is changed.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		policy := cache.RetentionPolicy{
+			ClosedCaseMonths: viper.GetInt("retention.closed_case_months"),
+			CompactAfterDays: viper.GetInt("retention.compact_after_days"),
+		}
+		if policy.ClosedCaseMonths <= 0 && policy.CompactAfterDays <= 0 {
+			fmt.Println("No retention configured, nothing to prune")
+			return
+		}
+		c, err := cache.Init(DatabaseDSN())
+		if err != nil {
+			log.Fatalf("Error:  Unable to initialize cache: %s", err)
+		}
+		result, err := c.Prune(policy, time.Now(), pruneDryRun)
+		if err != nil {
+			log.Fatalf("Error:  Unable to prune cache: %s", err)
+		}
+		verb := "Removed"
+		if pruneDryRun {
+			verb = "Would remove"
+		}
+		fmt.Printf("%s %d closed cases\n", verb, len(result.ClosedCases))
+		for _, myCase := range result.ClosedCases {
+			fmt.Printf("  %s  %s  %s\n", myCase.CaseNumber, myCase.LastModifiedDate.Format("2006-01-02"), myCase.Summary)
+		}
+		fmt.Printf("%s %d history rows and %d runs by merging them into daily summaries\n", verb, result.HistoryRows, result.Runs)
+		if result.Vacuumed {
+			fmt.Println("Reclaimed free space")
+		}
+	},
+}
+
+var dbExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the cache to move it to another host or seed a test environment",
+	Long: `Exports cases, products, accounts, history, triage and runs as JSON Lines, one record per line
+	after a header giving the schema version, to stdout or the --output file. Load it with 'db import'.
+	With --format sqlite a consistent copy of a SQLite cache is written to the --output file while it stays in use.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := cache.Init(DatabaseDSN())
+		if err != nil {
+			log.Fatalf("Error:  Unable to initialize cache: %s", err)
+		}
+		switch exportFormat {
+		case ExportSQLite:
+			if exportOutput == "" || exportOutput == "-" {
+				log.Fatalf("Error:  --output is required with --format %s", ExportSQLite)
+			}
+			err = c.Backup(exportOutput)
+			if err != nil {
+				log.Fatalf("Error:  Unable to back up cache: %s", err)
+			}
+			fmt.Fprintf(os.Stderr, "Backed up cache to %s\n", exportOutput)
+		case ExportJSONL:
+			var w io.Writer = os.Stdout
+			if exportOutput != "" && exportOutput != "-" {
+				f, err := os.OpenFile(exportOutput, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+				if err != nil {
+					log.Fatalf("Error:  Unable to create export file: %s", err)
+				}
+				defer f.Close()
+				w = f
+			}
+			counts, err := c.Export(w)
+			if err != nil {
+				log.Fatalf("Error:  Unable to export cache: %s", err)
+			}
+			printRecordCounts("Exported", counts)
+		default:
+			log.Fatalf("Error:  Unknown export format '%s', expected %s or %s", exportFormat, ExportJSONL, ExportSQLite)
+		}
+	},
+}
+
+var dbImportCmd = &cobra.Command{
+	Use:   "import <file|->",
+	Short: "Import an export written by 'db export'",
+	Long: `Imports a JSON Lines export written by 'db export', from a file or '-' for stdin, in a single transaction.
+	Records replace those with the same key already in the cache, other cached data is kept. Runs are matched
+	by start time and query, as their ids differ between caches.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var r io.Reader = os.Stdin
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				log.Fatalf("Error:  Unable to open export: %s", err)
+			}
+			defer f.Close()
+			r = f
+		}
+		c, err := cache.Init(DatabaseDSN())
+		if err != nil {
+			log.Fatalf("Error:  Unable to initialize cache: %s", err)
+		}
+		counts, err := c.Import(r)
+		if err != nil {
+			log.Fatalf("Error:  Unable to import: %s", err)
+		}
+		printRecordCounts("Imported", counts)
+	},
+}
+
+// printRecordCounts writes the number of records of each type to stderr, keeping stdout for the export itself
+func printRecordCounts(verb string, counts cache.RecordCounts) {
+	types := make([]string, 0, len(counts))
+	for t := range counts {
+		if t != cache.RecordHeader {
+			types = append(types, t)
+		}
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		fmt.Fprintf(os.Stderr, "%s %d %s records\n", verb, counts[t], t)
+	}
+}
+
+func init() {
+	dbMigrateCmd.Flags().BoolVar(&migrateNoBackup, "no-backup", false, "do not back up a SQLite database file before migrating")
+	dbCmd.AddCommand(dbStatusCmd)
+	dbPruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "show what would be removed without changing anything")
+	dbCmd.AddCommand(dbMigrateCmd)
+	dbCmd.AddCommand(dbPruneCmd)
+	dbExportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "file to write, stdout by default")
+	dbExportCmd.Flags().StringVar(&exportFormat, "format", ExportJSONL, "export format: jsonl, or sqlite for a copy of a SQLite cache")
+	dbCmd.AddCommand(dbExportCmd)
+	dbCmd.AddCommand(dbImportCmd)
+	rootCmd.AddCommand(dbCmd)
+}
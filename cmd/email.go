@@ -1,39 +1,90 @@
 package cmd
 
 import (
-	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"fmt"
 	"github.com/jwmatthews/case_watcher/pkg/email"
 	"github.com/jwmatthews/case_watcher/pkg/report"
+	"github.com/jwmatthews/case_watcher/pkg/workbook"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"log"
+	"strings"
 )
 
+var emailFixture string
+var emailDryRun bool
+var emailIncludeStale bool
+var emailAttach string
+var emailWindow windowFlags
+
 var emailCmd = &cobra.Command{
 	Use:   "email",
 	Short: "Will email a summary report of relevant cases",
-	Long:  `Will look at cached data and email a list of relevant cases.`,
+	Long: `Will look at cached data and email a list of relevant cases.
+	With --dry-run the email is written to stdout rather than sent, and with --fixture it is
+	built from an ephemeral cache populated from a JSON file.
+	With --attach, or 'email_attachment', the spreadsheet tabs are attached as an xlsx or ods workbook.
+	The report covers the window chosen by --since, --until and --period, a sent report records its end
+	in the cache to start the next window with --period last-sent.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		VerifyParamsOrDie()
+		if !emailDryRun {
+			VerifyParamsOrDie()
+		}
 		// Parse configuration options
 		var spreadsheetId = viper.GetString("spreadsheet")
 		var sesRegion = viper.GetString("ses_region")
 		var sesSender = viper.GetString("ses_sender")
 		var reportEmailRecipients = viper.GetStringSlice("report_email_recipients")
 
-		c, err := cache.Init(DBName)
-		if err != nil {
-			log.Fatalf("Error:  Unable to initialize cache: %s", err)
-		}
+		c := OpenCacheOrDie(emailFixture)
+		c.SetIncludeStale(emailIncludeStale)
 		report := report.GetReport(&c, spreadsheetId)
-		err = email.Send(report, sesSender, sesRegion, reportEmailRecipients)
+		report.SLA = SLAPolicyOrDie()
+		report.Trends = TrendOptionsOrDie()
+		report.TopAccounts = viper.GetInt("report_top_accounts")
+		if viper.GetBool("report_owners") {
+			owners := OwnerOptionsOrDie()
+			report.Owners = &owners
+		}
+		report.Window = reportWindowOrDie(report, emailWindow)
+		attachments := make([]email.Attachment, 0)
+		if format := viper.GetString("email_attachment"); format != "" {
+			attachments = append(attachments, email.Attachment{
+				Filename:    workbookFilename(format),
+				ContentType: workbook.ContentTypes[format],
+				Data:        workbookOrDie(&c, format),
+			})
+		}
+		if emailDryRun {
+			fmt.Printf("From: %s\nTo: %s\nSubject: %s\n", sesSender, strings.Join(reportEmailRecipients, ", "),
+				report.GetSubjectLine())
+			for _, a := range attachments {
+				fmt.Printf("Attachment: %s (%d bytes)\n", a.Filename, len(a.Data))
+			}
+			fmt.Printf("\n%s\n", report.ToHTML())
+			return
+		}
+		err := email.Send(report, sesSender, sesRegion, reportEmailRecipients, attachments...)
 		if err != nil {
 			log.Fatalf("Error:  Unable to send report via email: %s", err)
 		}
+		// Reports of past windows are resent by hand and do not move the start of the next window
+		if emailWindow.endsNow() {
+			err = report.MarkSent()
+			if err != nil {
+				log.Fatalf("Error:  Unable to record the report was sent: %s", err)
+			}
+		}
 
 	},
 }
 
 func init() {
+	emailCmd.Flags().StringVar(&emailFixture, "fixture", "", "build the email from cases in this JSON file rather than the cache")
+	emailCmd.Flags().BoolVar(&emailDryRun, "dry-run", false, "write the email to stdout instead of sending it")
+	emailCmd.Flags().BoolVar(&emailIncludeStale, "include-stale", false, "include cases no longer returned by the search")
+	emailWindow.add(emailCmd)
+	emailCmd.Flags().StringVar(&emailAttach, "attach", "", "attach the spreadsheet tabs as a workbook: xlsx or ods")
+	viper.BindPFlag("email_attachment", emailCmd.Flags().Lookup("attach"))
 	rootCmd.AddCommand(emailCmd)
 }
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/jwmatthews/case_watcher/pkg/workbook"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+var workbookOutput string
+var workbookFixture string
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Write the spreadsheet tabs to a local workbook file",
+	Long: `Writes the tabs 'spreadsheet' would write to Google Sheets to a local workbook instead, with the same
+	'spreadsheet_layout', 'spreadsheet_tabs' and 'spreadsheet_filter', for teams without a Google service account.`,
+}
+
+// newExportWorkbookCmd returns the export subcommand writing workbooks in format
+func newExportWorkbookCmd(format string) *cobra.Command {
+	return &cobra.Command{
+		Use:   format,
+		Short: fmt.Sprintf("Write the open and closed cases, and the configured tabs, to an %s file", format),
+		Long: fmt.Sprintf(`Writes the open and closed cases, and the tabs selected under 'spreadsheet_tabs', to the --output file,
+	'case_watcher-<date>.%s' by default or '-' for stdout.`, format),
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			c := OpenCacheOrDie(workbookFixture)
+			data := workbookOrDie(&c, format)
+			output := workbookOutput
+			if output == "" {
+				output = workbookFilename(format)
+			}
+			if output == "-" {
+				_, err := os.Stdout.Write(data)
+				if err != nil {
+					log.Fatalf("Error:  Unable to write workbook: %s", err)
+				}
+				return
+			}
+			err := ioutil.WriteFile(output, data, 0644)
+			if err != nil {
+				log.Fatalf("Error:  Unable to write workbook: %s", err)
+			}
+			fmt.Fprintf(os.Stderr, "Wrote %s\n", output)
+		},
+	}
+}
+
+// workbookFilename is the default name of a workbook written today
+func workbookFilename(format string) string {
+	return fmt.Sprintf("case_watcher-%s.%s", time.Now().Format("2006-01-02"), format)
+}
+
+// workbookOrDie returns the spreadsheet tabs of the cached cases as a workbook in format
+func workbookOrDie(c cache.Store, format string) []byte {
+	layout := spreadsheetLayoutOrDie()
+	sheetReport := spreadsheetReportOrDie(c, layout)
+	buf := bytes.Buffer{}
+	err := workbook.Write(&buf, format, sheetReport.Workbook(layout))
+	if err != nil {
+		log.Fatalf("Error:  Unable to write workbook: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func init() {
+	exportCmd.PersistentFlags().StringVarP(&workbookOutput, "output", "o", "", "file to write, '-' for stdout")
+	exportCmd.PersistentFlags().StringVar(&workbookFixture, "fixture", "", "export cases from this JSON file rather than the cache")
+	exportCmd.AddCommand(newExportWorkbookCmd(workbook.FormatXLSX))
+	exportCmd.AddCommand(newExportWorkbookCmd(workbook.FormatODS))
+	rootCmd.AddCommand(exportCmd)
+}
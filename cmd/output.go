@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"gopkg.in/yaml.v2"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats accepted by the --output flag
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+	OutputCSV   = "csv"
+)
+
+// caseColumn is a named column which can be selected for output of a case
+type caseColumn struct {
+	Name  string
+	Value func(c cache.Case) interface{}
+}
+
+var caseColumns = []caseColumn{
+	{"id", func(c cache.Case) interface{} { return c.Id }},
+	{"number", func(c cache.Case) interface{} { return c.CaseNumber }},
+	{"severity", func(c cache.Case) interface{} { return c.Severity }},
+	{"status", func(c cache.Case) interface{} { return c.Status }},
+	{"owner", func(c cache.Case) interface{} { return c.Owner }},
+	{"account", func(c cache.Case) interface{} { return c.AccountNumber }},
+	{"contact", func(c cache.Case) interface{} { return c.ContactName }},
+	{"type", func(c cache.Case) interface{} { return c.Type }},
+	{"created", func(c cache.Case) interface{} { return formatTime(c.CreatedDate) }},
+	{"modified", func(c cache.Case) interface{} { return formatTime(c.LastModifiedDate) }},
+	{"last_public_update", func(c cache.Case) interface{} { return formatTime(c.LastPublicUpdateDate) }},
+	{"escalated", func(c cache.Case) interface{} { return c.CustomerEscalation }},
+	{"products", func(c cache.Case) interface{} { return productNames(c) }},
+	{"version", func(c cache.Case) interface{} { return c.Version }},
+	{"relevance", func(c cache.Case) interface{} { return c.Relevance }},
+	{"area", func(c cache.Case) interface{} { return c.Area }},
+	{"classified_by", func(c cache.Case) interface{} { return c.ClassifiedBy }},
+	{"score", func(c cache.Case) interface{} {
+		if c.Score == nil {
+			return ""
+		}
+		return fmt.Sprintf("%.2f", *c.Score)
+	}},
+	{"assignee", func(c cache.Case) interface{} { return c.Assignee }},
+	{"notes", func(c cache.Case) interface{} { return c.Notes }},
+	{"last_seen", func(c cache.Case) interface{} { return formatTime(c.LastSeenAt) }},
+	{"stale", func(c cache.Case) interface{} { return c.Stale }},
+	{"summary", func(c cache.Case) interface{} { return c.Summary }},
+	{"uri", func(c cache.Case) interface{} { return c.Uri }},
+}
+
+var defaultCaseColumns = []string{"number", "severity", "status", "owner", "modified", "summary"}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func productNames(c cache.Case) string {
+	names := make([]string, 0, len(c.Products))
+	for _, p := range c.Products {
+		names = append(names, p.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// selectCaseColumns looks up columns by name, an empty list selects the defaults
+func selectCaseColumns(names []string) ([]caseColumn, error) {
+	if len(names) == 0 {
+		names = defaultCaseColumns
+	}
+	selected := make([]caseColumn, 0, len(names))
+	for _, name := range names {
+		found := false
+		for _, col := range caseColumns {
+			if col.Name == name {
+				selected = append(selected, col)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown column '%s'", name)
+		}
+	}
+	return selected, nil
+}
+
+// writeCases renders the cases in the requested format with the selected columns
+func writeCases(w io.Writer, format string, columns []caseColumn, cases []cache.Case) error {
+	names := make([]string, 0, len(columns))
+	for _, col := range columns {
+		names = append(names, col.Name)
+	}
+	rows := make([][]interface{}, 0, len(cases))
+	for _, c := range cases {
+		row := make([]interface{}, 0, len(columns))
+		for _, col := range columns {
+			row = append(row, col.Value(c))
+		}
+		rows = append(rows, row)
+	}
+	return writeRows(w, format, names, rows)
+}
+
+// writeRows renders rows of values in the requested format, names being the names of the columns. Tables and CSV
+// have a header of the upper case names, JSON and YAML list an object per row keyed by the names.
+func writeRows(w io.Writer, format string, names []string, rows [][]interface{}) error {
+	switch format {
+	case OutputTable, OutputCSV:
+		lines := make([][]string, 0, len(rows)+1)
+		header := make([]string, 0, len(names))
+		for _, name := range names {
+			header = append(header, strings.ToUpper(name))
+		}
+		lines = append(lines, header)
+		for _, row := range rows {
+			line := make([]string, 0, len(row))
+			for _, value := range row {
+				line = append(line, fmt.Sprint(value))
+			}
+			lines = append(lines, line)
+		}
+		if format == OutputCSV {
+			return csv.NewWriter(w).WriteAll(lines)
+		}
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for _, line := range lines {
+			fmt.Fprintln(tw, strings.Join(line, "\t"))
+		}
+		return tw.Flush()
+	case OutputJSON, OutputYAML:
+		records := make([]yaml.MapSlice, 0, len(rows))
+		for _, row := range rows {
+			record := yaml.MapSlice{}
+			for i, name := range names {
+				record = append(record, yaml.MapItem{Key: name, Value: row[i]})
+			}
+			records = append(records, record)
+		}
+		if format == OutputYAML {
+			return yaml.NewEncoder(w).Encode(records)
+		}
+		objects := make([]map[string]interface{}, 0, len(records))
+		for _, record := range records {
+			object := map[string]interface{}{}
+			for _, item := range record {
+				object[item.Key.(string)] = item.Value
+			}
+			objects = append(objects, object)
+		}
+		return writeJSON(w, objects)
+	default:
+		return fmt.Errorf("unknown output format '%s'", format)
+	}
+}
+
+// writeStructured renders a value as JSON or YAML
+func writeStructured(w io.Writer, format string, v interface{}) error {
+	switch format {
+	case OutputJSON:
+		return writeJSON(w, v)
+	case OutputYAML:
+		return yaml.NewEncoder(w).Encode(v)
+	default:
+		return fmt.Errorf("unknown output format '%s'", format)
+	}
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/jwmatthews/case_watcher/pkg/rollup"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var ownersFixture string
+var ownersIdleDays int
+var ownersOutput string
+
+var reportOwnersCmd = &cobra.Command{
+	Use:   "owners",
+	Short: "Show the open cases of each owner by severity, their oldest case and their idle cases",
+	Long: `Shows for each owner the open cases by severity level, the age in days of the oldest one and how many
+	went without a change for more than --idle-days, 'owners.idle_days' by default, followed by those idle cases.
+	Owners are mapped to team members with the aliases under 'owners'.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		options := OwnerOptionsOrDie()
+		if cmd.Flags().Changed("idle-days") {
+			options.IdleDays = ownersIdleDays
+		}
+		if options.IdleDays == 0 {
+			options.IdleDays = rollup.DefaultIdleDays
+		}
+		c := OpenCacheOrDie(ownersFixture)
+		cases, err := c.GetOpenCases()
+		if err != nil {
+			log.Fatalf("Error:  Unable to read open cases: %s", err)
+		}
+		owners := rollup.ByOwner(cases, options, time.Now())
+
+		levels := rollup.Levels(owners)
+		names := []string{"owner", "open"}
+		for _, level := range levels {
+			names = append(names, "sev_"+level)
+		}
+		names = append(names, "oldest_days", "idle")
+		rows := make([][]interface{}, 0, len(owners))
+		for _, o := range owners {
+			row := []interface{}{o.Name, o.Open}
+			for _, level := range levels {
+				row = append(row, o.Severities[level])
+			}
+			rows = append(rows, append(row, int(o.Oldest.Hours()/24), len(o.Idle)))
+		}
+		err = writeRows(os.Stdout, ownersOutput, names, rows)
+		if err != nil {
+			log.Fatalf("Error:  %s", err)
+		}
+		if ownersOutput != OutputTable {
+			return
+		}
+		fmt.Printf("\nCases idle for more than %d days:\n", options.IdleDays)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, o := range owners {
+			for _, myCase := range o.Idle {
+				fmt.Fprintf(tw, "  %s\t%s\t%s\tlast changed %s by %s\t%s\n", o.Name, myCase.CaseNumber, myCase.Severity,
+					formatTime(myCase.LastModifiedDate), myCase.LastModifiedByName, myCase.Summary)
+			}
+		}
+		tw.Flush()
+	},
+}
+
+func init() {
+	reportOwnersCmd.Flags().StringVar(&ownersFixture, "fixture", "", "read cases from this JSON file rather than the cache")
+	reportOwnersCmd.Flags().IntVar(&ownersIdleDays, "idle-days", 0, "cases without a change for more than this many days are idle")
+	reportOwnersCmd.Flags().StringVarP(&ownersOutput, "output", "o", OutputTable, "output format: table, json, yaml or csv")
+	reportCmd.AddCommand(reportOwnersCmd)
+}
@@ -2,8 +2,8 @@ package cmd
 
 import (
 	"fmt"
-	"github.com/jwmatthews/case_watcher/pkg/cache"
 	"github.com/jwmatthews/case_watcher/pkg/report"
+	"github.com/jwmatthews/case_watcher/pkg/sla"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"log"
@@ -11,24 +11,94 @@ import (
 	"time"
 )
 
+var reportFixture string
+var reportIncludeStale bool
+var reportWindow windowFlags
+
+// windowFlags choose the time a report covers, see reportWindowOrDie
+type windowFlags struct {
+	since  string
+	until  string
+	period string
+}
+
+// add adds the --since, --until and --period flags to a command
+func (f *windowFlags) add(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.since, "since", "", "start of the report window: a date (2006-01-02), timestamp or age such as '14d'")
+	cmd.Flags().StringVar(&f.until, "until", "", "end of the report window, in the same formats, now by default")
+	cmd.Flags().StringVar(&f.period, "period", "",
+		"window ending at --until without --since: daily, weekly, monthly or last-sent, 'report_period' by default")
+}
+
+// endsNow returns true when the flags choose a window ending now rather than at a given --until
+func (f windowFlags) endsNow() bool {
+	return f.until == ""
+}
+
+// reportWindowOrDie returns the window chosen by the flags, --since taking precedence over --period, where
+// last-sent starts the window when the cache records the last report was sent
+func reportWindowOrDie(r report.Report, f windowFlags) report.Window {
+	w := report.Window{Until: time.Now()}
+	var err error
+	if f.until != "" {
+		w.Until, err = parseSince(f.until)
+		if err != nil {
+			log.Fatalf("Error:  Unable to parse --until '%s': %s", f.until, err)
+		}
+	}
+	if f.since != "" {
+		w.Since, err = parseSince(f.since)
+		if err != nil {
+			log.Fatalf("Error:  Unable to parse --since '%s': %s", f.since, err)
+		}
+		err = w.Validate()
+	} else {
+		period := f.period
+		if period == "" {
+			period = viper.GetString("report_period")
+		}
+		var lastSent time.Time
+		if period == report.PeriodLastSent {
+			lastSent, err = r.LastSent()
+			if err != nil {
+				log.Fatalf("Error:  Unable to read when the last report was sent: %s", err)
+			}
+		}
+		w, err = report.PeriodWindow(period, w.Until, lastSent)
+	}
+	if err != nil {
+		log.Fatalf("Error:  Invalid report window: %s", err)
+	}
+	return w
+}
+
 var reportCmd = &cobra.Command{
 	Use:   "report",
 	Short: "Will display a summary report of cached data to stdout",
-	Long:  `Intended to help debug reports by looking at cached data and displaying summary data to stdout`,
+	Long: `Intended to help debug reports by looking at cached data and displaying summary data to stdout.
+	With --fixture the report is built from an ephemeral cache populated from a JSON file instead.
+	The report covers the week up to now, or the window chosen by --since, --until and --period.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		VerifyParamsOrDie()
+		if reportFixture == "" {
+			VerifyParamsOrDie()
+		}
 		// Parse configuration options
 		var spreadsheetId = viper.GetString("spreadsheet")
 
-		c, err := cache.Init(DBName)
-		if err != nil {
-			log.Fatalf("Error:  Unable to initialize cache: %s", err)
-		}
+		c := OpenCacheOrDie(reportFixture)
+		c.SetIncludeStale(reportIncludeStale)
 		report := report.GetReport(&c, spreadsheetId)
-		sinceLastWeek := time.Now().AddDate(0, 0, -7)
-		activeCases, err := report.GetActiveCasesFrom(sinceLastWeek)
+		report.SLA = SLAPolicyOrDie()
+		report.Trends = TrendOptionsOrDie()
+		report.TopAccounts = viper.GetInt("report_top_accounts")
+		if viper.GetBool("report_owners") {
+			owners := OwnerOptionsOrDie()
+			report.Owners = &owners
+		}
+		report.Window = reportWindowOrDie(report, reportWindow)
+		activeCases, err := report.GetActiveCasesIn(report.Window)
 		if err != nil {
-			fmt.Printf("Error from GetActiveCasesSince(): %s\n", err)
+			fmt.Printf("Error from GetActiveCasesIn(): %s\n", err)
 			os.Exit(1)
 		}
 		openCases, err := report.GetOpenCases()
@@ -36,9 +106,9 @@ var reportCmd = &cobra.Command{
 			fmt.Printf("Error from GetOpenCases(): %s\n", err)
 			os.Exit(1)
 		}
-		closedCases, err := report.GetClosedCases()
+		closedCases, err := report.GetCasesClosedIn(report.Window)
 		if err != nil {
-			fmt.Printf("Error from GetClosedCases(): %s\n", err)
+			fmt.Printf("Error from GetCasesClosedIn(): %s\n", err)
 			os.Exit(1)
 		}
 		uniqStatusValues, err := report.Cache.GetUniqueCaseStatusValues()
@@ -49,9 +119,19 @@ var reportCmd = &cobra.Command{
 
 		fmt.Printf("Spreadsheet URL: %s\n", report.GetSpreadsheetURL())
 		fmt.Printf("Subject line: %s\n", report.GetSubjectLine())
-		fmt.Printf("%d:  actives cases since last week (%s)\n", len(activeCases), sinceLastWeek.String())
+		fmt.Printf("Window: %s to %s\n", report.Window.Since.Format(time.RFC3339), report.Window.Until.Format(time.RFC3339))
+		fmt.Printf("%d:  actives cases in the window\n", len(activeCases))
 		fmt.Printf("%d:  open cases\n", len(openCases))
-		fmt.Printf("%d:  closed cases\n", len(closedCases))
+		fmt.Printf("%d:  cases closed in the window\n", len(closedCases))
+		if report.SLA.Enabled() {
+			now := report.GetWindow().Until
+			accounts := report.GetAccounts(openCases)
+			fmt.Printf("%d:  open cases breaching or at risk of breaching their SLA\n", len(report.SLA.Overdue(openCases, accounts, now)))
+			for _, a := range report.SLA.Aging(openCases, accounts, now) {
+				fmt.Printf("\t severity %s: %d waiting on us, %d breached, %d at risk, longest %.1fh since an update, oldest %.1fh\n",
+					a.Level, a.Waiting, a.Breached, a.AtRisk, sla.Hours(a.Longest), sla.Hours(a.Oldest))
+			}
+		}
 		fmt.Printf("\n\n")
 		fmt.Println("HTML Report")
 		fmt.Println(report.ToHTML())
@@ -61,5 +141,11 @@ var reportCmd = &cobra.Command{
 }
 
 func init() {
+	reportCmd.Flags().StringVar(&reportFixture, "fixture", "", "build the report from cases in this JSON file rather than the cache")
+	reportCmd.Flags().BoolVar(&reportIncludeStale, "include-stale", false, "include cases no longer returned by the search")
+	reportWindow.add(reportCmd)
+	viper.SetDefault("report_top_accounts", 10)
+	viper.SetDefault("report_owners", true)
+	viper.SetDefault("report_period", report.PeriodWeekly)
 	rootCmd.AddCommand(reportCmd)
 }
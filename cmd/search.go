@@ -1,9 +1,9 @@
 package cmd
 
 import (
+	"github.com/jwmatthews/case_watcher/pkg/api"
 	"github.com/jwmatthews/case_watcher/pkg/cache"
 	"github.com/jwmatthews/case_watcher/pkg/search"
-	"github.com/jwmatthews/case_watcher/pkg/spreadsheet"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"log"
@@ -26,35 +26,68 @@ var searchCmd = &cobra.Command{
 		var searchQuery = viper.GetString("query")
 		var expression = viper.GetString("expression")
 		var spreadsheetId = viper.GetString("spreadsheet")
-		var email = viper.GetString("client_email")
-		var privkey = viper.GetString("private_key")
-		var privkeyId = viper.GetString("private_key_id")
 
 		data, err := search.Search(url, username, password, searchQuery, expression)
 		if err != nil {
 			log.Fatalf("Error:  Failed to search for cases, error: %v\n", err)
 		}
-		c, err := cache.Init(DBName)
+		c, err := cache.Init(DatabaseDSN())
 		if err != nil {
 			log.Fatalf("Error:  Unable to initialize cache: %s", err)
 		}
-		err = c.StoreCases(data.Cases)
+		c.SetClassifier(LoadClassifierOrDie(&c))
+		_, err = c.StoreCases(data.Cases)
 		if err != nil {
 			log.Fatalf("Error updating cases in cache: %s\n", err)
 		}
+		recordRunOrDie(&c, data, searchQuery, func(caseNumbers []string) []api.Case {
+			return search.GetCases(url, username, password, caseNumbers, expression)
+		})
 		accountIDs := c.GetMissingAccountIDs()
-		if err != nil {
-			log.Fatalf("Error processing updates to cache: %s\n", err)
+		if len(accountIDs) > 0 {
+			log.Printf("Fetching missing account IDs: %s", accountIDs)
+			err = c.StoreAccounts(search.GetAccounts(url, username, password, accountIDs))
+			if err != nil {
+				log.Fatalf("Error:  Unable to store accounts: %s", err)
+			}
 		}
-		log.Printf("Found missing account IDs: %s", accountIDs)
-		cr := data.ToCaseReport()
-		err = spreadsheet.Update(spreadsheetId, email, privkey, privkeyId, &cr)
+		err = updateSpreadsheet(&c, sheetsSinkOrDie(), spreadsheetId)
 		if err != nil {
 			log.Fatalf("Error:  Unable to update spreadsheet, error: %v\n", err)
 		}
 	},
 }
 
+// recordRunOrDie notes which cases the search returned, marking those missing from too many runs as stale.
+// With 'refetch_stale_cases' set newly stale cases are fetched individually to record their final status.
+func recordRunOrDie(c cache.Store, data *api.ResponseCasesQueryBody, searchQuery string, fetch func([]string) []api.Case) {
+	if data.NumFound > len(data.Cases) {
+		log.Printf("Search returned %d of %d matching cases, not tracking which cases were seen\n", len(data.Cases), data.NumFound)
+		return
+	}
+	seen := make([]string, 0, len(data.Cases))
+	for _, theCase := range data.Cases {
+		seen = append(seen, theCase.Id)
+	}
+	run, stale, err := c.RecordRun(searchQuery, seen, viper.GetInt("stale_after_runs"))
+	if err != nil {
+		log.Fatalf("Error:  Unable to record run: %s", err)
+	}
+	log.Printf("Run %d saw %d cases, %d are newly stale\n", run.ID, run.CasesSeen, run.StaleCases)
+	if len(stale) == 0 || !viper.GetBool("refetch_stale_cases") {
+		return
+	}
+	caseNumbers := make([]string, 0, len(stale))
+	for _, theCase := range stale {
+		caseNumbers = append(caseNumbers, theCase.CaseNumber)
+	}
+	_, err = c.StoreCases(fetch(caseNumbers))
+	if err != nil {
+		log.Fatalf("Error:  Unable to update stale cases: %s", err)
+	}
+}
+
 func init() {
+	viper.SetDefault("stale_after_runs", 3)
 	rootCmd.AddCommand(searchCmd)
 }
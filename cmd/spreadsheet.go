@@ -1,37 +1,229 @@
 package cmd
 
 import (
-	"github.com/jwmatthews/case_watcher/pkg/api"
+	"fmt"
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/jwmatthews/case_watcher/pkg/report"
 	"github.com/jwmatthews/case_watcher/pkg/spreadsheet"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 )
 
+var spreadsheetDryRun bool
+var spreadsheetFixture string
+
 // spreadsheetCmd represents the spreadsheet command
 var spreadsheetCmd = &cobra.Command{
 	Use:   "spreadsheet",
 	Short: "Will update a google spreadsheet",
-	Long:  `Updates a google spreadsheet with cached data`,
+	Long: `Updates a google spreadsheet with cached data, without contacting the case API.
+	Writes the open and closed cases as selected by 'spreadsheet_mode', then the tabs selected under 'spreadsheet_tabs':
+	cases active in the past days, the open cases of each product and a summary of counts.
+	Cases are limited by 'spreadsheet_filter', or the flags given here.`,
+	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		VerifyParamsOrDie()
-		// Parse configuration options
-		var spreadsheetId = viper.GetString("spreadsheet")
-		var email = viper.GetString("client_email")
-		var privkey = viper.GetString("private_key")
-		var privkeyId = viper.GetString("private_key_id")
-
-		// At the moment this command is to help test the spreadsheet integration
-		// We create an empty report for now.
-		// In future I expect this will read cached data and update the spreadsheet from it
-		data := api.CaseReport{}
-		err := spreadsheet.Update(spreadsheetId, email, privkey, privkeyId, &data)
+		c := OpenCacheOrDie(spreadsheetFixture)
+		if spreadsheetDryRun {
+			layout := spreadsheetLayoutOrDie()
+			report := spreadsheetReportOrDie(&c, layout)
+			fmt.Printf("%d open cases\n%d closed cases\n", len(report.OpenCases), len(report.ClosedCases))
+			for _, tab := range report.Tabs(layout) {
+				fmt.Printf("%s: %d rows\n", tab.Name, len(tab.Values)-1)
+			}
+			return
+		}
+		spreadsheetId := SpreadsheetIdOrDie()
+		err := updateSpreadsheet(&c, sheetsSinkOrDie(), spreadsheetId)
 		if err != nil {
 			log.Fatalf("Error:  Unable to update spreadsheet, error: %v\n", err)
 		}
 	},
 }
 
+var spreadsheetPullCmd = &cobra.Command{
+	Use:   "pull",
+	Short: "Read the team's triage annotations from the spreadsheet into the cache",
+	Long: `Reads the columns named under 'spreadsheet_annotations' from the spreadsheet tabs, matching rows
+	to cached cases by Id. 'Ours' or 'not ours' is recorded as manual triage and notes and assignee are stored
+	with the case, so triage can be done in the spreadsheet as well as with 'cases triage'.
+	Needs 'spreadsheet_mode: upsert', rewrite mode replaces the tabs each day without the team's columns.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		spreadsheetId := SpreadsheetIdOrDie()
+		if mode := viper.GetString("spreadsheet_mode"); mode != SpreadsheetUpsert {
+			log.Fatalf("Error:  Pulling annotations needs spreadsheet_mode '%s', '%s' mode does not keep the team's columns",
+				SpreadsheetUpsert, mode)
+		}
+
+		cols := spreadsheet.AnnotationColumns{}
+		err := viper.UnmarshalKey("spreadsheet_annotations", &cols)
+		if err != nil {
+			log.Fatalf("Error:  Unable to parse 'spreadsheet_annotations': %s", err)
+		}
+		if cols.Relevance == "" && cols.Area == "" && cols.Notes == "" && cols.Assignee == "" {
+			log.Fatalf("Error:  No annotation columns configured under 'spreadsheet_annotations'")
+		}
+		if cols.Id == "" {
+			cols.Id, err = spreadsheetLayoutOrDie().IdHeader()
+			if err != nil {
+				log.Fatalf("Error:  Unable to match rows to cases: %s", err)
+			}
+		}
+		c, err := cache.Init(DatabaseDSN())
+		if err != nil {
+			log.Fatalf("Error:  Unable to initialize cache: %s", err)
+		}
+		summary, err := spreadsheet.Pull(sheetsSinkOrDie(), spreadsheetId,
+			viper.GetStringSlice("spreadsheet_annotations.sheets"), cols, &c)
+		if err != nil {
+			log.Fatalf("Error:  Unable to pull annotations from spreadsheet: %s", err)
+		}
+		fmt.Printf("Triaged %d cases, updated notes or assignee of %d cases\n", len(summary.Triaged), len(summary.Annotated))
+		if len(summary.Unknown) > 0 {
+			fmt.Printf("Skipped %d cases not in the cache: %s\n", len(summary.Unknown), strings.Join(summary.Unknown, ", "))
+		}
+	},
+}
+
+// Values of the 'spreadsheet_mode' setting
+const (
+	// SpreadsheetRewrite writes open cases to a new dated tab each day and rewrites the closed cases tab
+	SpreadsheetRewrite = "rewrite"
+	// SpreadsheetUpsert updates fixed open and closed tabs in place, keeping the team's own columns
+	SpreadsheetUpsert = "upsert"
+)
+
+// spreadsheetLayoutOrDie reads the columns and formatting of the spreadsheet from 'spreadsheet_layout'
+func spreadsheetLayoutOrDie() spreadsheet.Layout {
+	layout := spreadsheet.Layout{}
+	err := viper.UnmarshalKey("spreadsheet_layout", &layout)
+	if err == nil {
+		// UnmarshalKey only sees the default when the whole key is missing from the configuration
+		layout.Formatting = viper.GetBool("spreadsheet_layout.formatting")
+		err = layout.Validate()
+	}
+	if err != nil {
+		log.Fatalf("Error:  Unable to parse 'spreadsheet_layout': %s", err)
+	}
+	return layout.WithSLA(SLAPolicyOrDie(), time.Now())
+}
+
+// spreadsheetReportOrDie reads the cases selected by 'spreadsheet_filter' from the cache, with the tabs
+// selected by 'spreadsheet_tabs', looking up their accounts when the layout shows account fields
+func spreadsheetReportOrDie(c cache.Store, layout spreadsheet.Layout) spreadsheet.Report {
+	f := cache.CaseFilter{
+		Triage:        viper.GetString("spreadsheet_filter.triage"),
+		Severities:    viper.GetStringSlice("spreadsheet_filter.severities"),
+		Product:       viper.GetString("spreadsheet_filter.product"),
+		Owner:         viper.GetString("spreadsheet_filter.owner"),
+		AccountNumber: viper.GetString("spreadsheet_filter.account"),
+	}
+	opts := spreadsheet.TabOptions{
+		ActiveDays: viper.GetInt("spreadsheet_tabs.active_days"),
+		Products:   viper.GetBool("spreadsheet_tabs.products"),
+		Summary:    viper.GetBool("spreadsheet_tabs.summary"),
+	}
+	c.SetIncludeStale(viper.GetBool("spreadsheet_filter.include_stale"))
+	report, err := spreadsheet.BuildReport(c, f, time.Now(), opts)
+	if err != nil {
+		log.Fatalf("Error:  Unable to read cases for the spreadsheet: %s", err)
+	}
+	if layout.NeedsAccounts() {
+		report.Accounts = map[string]cache.Account{}
+		for _, cases := range [][]cache.Case{report.OpenCases, report.ClosedCases} {
+			for _, myCase := range cases {
+				if _, ok := report.Accounts[myCase.AccountNumber]; ok || myCase.AccountNumber == "" {
+					continue
+				}
+				account, err := c.GetAccount(myCase.AccountNumber)
+				if err == nil {
+					report.Accounts[myCase.AccountNumber] = account
+				}
+			}
+		}
+	}
+	return report
+}
+
+// rotationPolicyOrDie reads how many daily tabs to keep, and what to do with older ones, from 'spreadsheet_rotation'
+func rotationPolicyOrDie() spreadsheet.RotationPolicy {
+	policy := spreadsheet.RotationPolicy{}
+	err := viper.UnmarshalKey("spreadsheet_rotation", &policy)
+	if err == nil {
+		err = policy.Validate()
+	}
+	if err != nil {
+		log.Fatalf("Error:  Unable to parse 'spreadsheet_rotation': %s", err)
+	}
+	return policy
+}
+
+// sheetsSinkOrDie connects to the Sheets API with the key in 'google_credentials_file', else the key fields
+// 'client_email', 'private_key' and 'private_key_id', else Application Default Credentials. 'sheets_endpoint'
+// and 'sheets_token_url' replace Google's when set.
+func sheetsSinkOrDie() spreadsheet.Sink {
+	creds := spreadsheet.Credentials{
+		File:         viper.GetString("google_credentials_file"),
+		Email:        viper.GetString("client_email"),
+		PrivateKey:   viper.GetString("private_key"),
+		PrivateKeyId: viper.GetString("private_key_id"),
+	}
+	sink, err := spreadsheet.NewSink(creds, spreadsheet.Connection{
+		Endpoint: viper.GetString("sheets_endpoint"),
+		TokenURL: viper.GetString("sheets_token_url"),
+	})
+	if err != nil {
+		log.Fatalf("Error:  Unable to retrieve Sheets client: %s", err)
+	}
+	return sink
+}
+
+// updateSpreadsheet writes the cached cases to the spreadsheet as selected by 'spreadsheet_mode',
+// remembering the tab of the latest open cases for the email to link to
+func updateSpreadsheet(c cache.Store, sink spreadsheet.Sink, spreadsheetId string) error {
+	layout := spreadsheetLayoutOrDie()
+	sheetReport := spreadsheetReportOrDie(c, layout)
+	var result spreadsheet.Result
+	var err error
+	switch mode := viper.GetString("spreadsheet_mode"); mode {
+	case SpreadsheetRewrite:
+		result, err = spreadsheet.Update(sink, spreadsheetId, sheetReport, layout, rotationPolicyOrDie())
+	case SpreadsheetUpsert:
+		result, err = spreadsheet.Upsert(sink, spreadsheetId, sheetReport, layout)
+	default:
+		return fmt.Errorf("unknown spreadsheet_mode '%s', expected %s or %s", mode, SpreadsheetRewrite, SpreadsheetUpsert)
+	}
+	if err != nil {
+		return err
+	}
+	return c.SetSetting(report.LatestTabSetting(spreadsheetId), strconv.FormatInt(result.LatestSheetId, 10))
+}
+
 func init() {
+	viper.SetDefault("spreadsheet_mode", SpreadsheetRewrite)
+	viper.SetDefault("spreadsheet_layout.formatting", true)
+	viper.SetDefault("spreadsheet_tabs.active_days", 7)
+	viper.SetDefault("spreadsheet_tabs.products", true)
+	viper.SetDefault("spreadsheet_tabs.summary", true)
+	spreadsheetCmd.Flags().String("triage", "", "only cases with triage state: relevant, ignored, untriaged or manual")
+	spreadsheetCmd.Flags().StringSlice("severity", nil, "only cases with one of these severities")
+	spreadsheetCmd.Flags().String("product", "", "only cases for this product")
+	spreadsheetCmd.Flags().String("owner", "", "only cases with this owner")
+	spreadsheetCmd.Flags().String("account", "", "only cases for this account number")
+	spreadsheetCmd.Flags().Bool("include-stale", false, "include cases no longer returned by the search")
+	viper.BindPFlag("spreadsheet_filter.triage", spreadsheetCmd.Flags().Lookup("triage"))
+	viper.BindPFlag("spreadsheet_filter.severities", spreadsheetCmd.Flags().Lookup("severity"))
+	viper.BindPFlag("spreadsheet_filter.product", spreadsheetCmd.Flags().Lookup("product"))
+	viper.BindPFlag("spreadsheet_filter.owner", spreadsheetCmd.Flags().Lookup("owner"))
+	viper.BindPFlag("spreadsheet_filter.account", spreadsheetCmd.Flags().Lookup("account"))
+	viper.BindPFlag("spreadsheet_filter.include_stale", spreadsheetCmd.Flags().Lookup("include-stale"))
+	spreadsheetCmd.Flags().StringVar(&spreadsheetFixture, "fixture", "", "write cases from this JSON file rather than the cache")
+	spreadsheetCmd.Flags().BoolVar(&spreadsheetDryRun, "dry-run", false, "show the tabs and the number of cases that would be written")
+	viper.SetDefault("spreadsheet_annotations.sheets", []string{spreadsheet.OpenCasesSheet, spreadsheet.ClosedCasesSheet})
+	spreadsheetCmd.AddCommand(spreadsheetPullCmd)
 	rootCmd.AddCommand(spreadsheetCmd)
 }
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/jwmatthews/case_watcher/pkg/report"
+	"github.com/jwmatthews/case_watcher/pkg/trends"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"time"
+)
+
+var trendsFixture string
+var trendsOptions trends.Options
+var trendsBy string
+var trendsOutput string
+
+var reportTrendsCmd = &cobra.Command{
+	Use:   "trends",
+	Short: "Show the cases opened and closed, the open backlog and the median time to close per week or month",
+	Long: `Computes from the cache, and the status history of the cases, the cases opened and closed in each period,
+	the cases open at its end and the median days to close of the cases closed in it. With --by severity or
+	--by product there is a row for each severity or product in each period.
+	The email report charts the same over the periods set under 'report_trends'.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := OpenCacheOrDie(trendsFixture)
+		r := report.GetReport(&c, "")
+		r.Trends = trendsOptions
+		result, err := r.GetTrends(time.Now())
+		if err != nil {
+			log.Fatalf("Error:  Unable to compute trends: %s", err)
+		}
+		var series []trends.Series
+		switch trendsBy {
+		case "total":
+			series = []trends.Series{result.Total}
+		case "severity":
+			series = result.BySeverity
+		case "product":
+			series = result.ByProduct
+		default:
+			log.Fatalf("Error:  Unknown breakdown '%s', expected total, severity or product", trendsBy)
+		}
+
+		names := []string{"period", "opened", "closed", "open", "median_days_to_close"}
+		if trendsBy != "total" {
+			names = append([]string{trendsBy}, names...)
+		}
+		rows := make([][]interface{}, 0)
+		for _, s := range series {
+			for _, p := range s.Periods {
+				row := []interface{}{result.Label(p), p.Opened, p.Closed, p.Backlog, trends.Days(p.MedianTimeToClose)}
+				if trendsBy != "total" {
+					row = append([]interface{}{s.Name}, row...)
+				}
+				rows = append(rows, row)
+			}
+		}
+		err = writeRows(os.Stdout, trendsOutput, names, rows)
+		if err != nil {
+			log.Fatalf("Error:  %s", err)
+		}
+	},
+}
+
+func init() {
+	reportTrendsCmd.Flags().StringVar(&trendsFixture, "fixture", "", "compute trends from cases in this JSON file rather than the cache")
+	reportTrendsCmd.Flags().StringVar(&trendsOptions.Interval, "interval", trends.IntervalWeek,
+		fmt.Sprintf("length of a period: %s or %s", trends.IntervalWeek, trends.IntervalMonth))
+	reportTrendsCmd.Flags().IntVar(&trendsOptions.Periods, "periods", 12, "number of periods, the current one last")
+	reportTrendsCmd.Flags().StringVar(&trendsBy, "by", "total", "rows for the total, each severity or each product")
+	reportTrendsCmd.Flags().StringVarP(&trendsOutput, "output", "o", OutputTable, "output format: table, json, yaml or csv")
+	reportCmd.AddCommand(reportTrendsCmd)
+}
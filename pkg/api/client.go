@@ -76,7 +76,7 @@ func (c *Client) sendRequest(req *http.Request) ([]byte, error) {
 func (c *Client) GetAccount(ctx context.Context, accountId string) (Account, error) {
 	var account = Account{}
 	var url = fmt.Sprintf("%s/accounts/%s", c.BaseURL, accountId)
-	req, err := http.NewRequest("Get", url, nil)
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		log.Printf("Failed to form http.NewRequest. err = %v\n", err)
 		return account, err
@@ -96,6 +96,21 @@ func (c *Client) GetAccount(ctx context.Context, accountId string) (Account, err
 	return account, nil
 }
 
+// GetCase fetches a single case by its case number through the search endpoint, regardless of
+// whether it still matches the usual query, expression selects the returned fields as for GetCases
+func (c *Client) GetCase(ctx context.Context, caseNumber string, expression string) (Case, error) {
+	resp, err := c.GetCases(ctx, fmt.Sprintf("case_number:%s", caseNumber), expression)
+	if err != nil {
+		return Case{}, err
+	}
+	for _, theCase := range resp.Cases {
+		if theCase.CaseNumber == caseNumber {
+			return theCase, nil
+		}
+	}
+	return Case{}, fmt.Errorf("case %s not found", caseNumber)
+}
+
 func (c *Client) GetCases(ctx context.Context, searchQuery string, expression string) (*ResponseCasesQueryBody, error) {
 	log.Printf("GetCases(ctx, '%v') invoked\n", searchQuery)
 	var url = fmt.Sprintf("%s/search/v2/cases", c.BaseURL)
@@ -0,0 +1,322 @@
+package cache
+
+import (
+	"github.com/jwmatthews/case_watcher/pkg/api"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// storeBatchSize bounds the rows written, and the ids looked up, by a single statement
+const storeBatchSize = 500
+
+// StoreSummary lists the ids of the cases given to StoreCases by what happened to them
+type StoreSummary struct {
+	Inserted  []string
+	Updated   []string
+	Unchanged []string
+}
+
+// caseProduct is a row of the case_products table linking a Case to a Product
+type caseProduct struct {
+	CaseId    string
+	ProductID uint
+}
+
+func (caseProduct) TableName() string {
+	return "case_products"
+}
+
+// StoreCases saves cases as returned by the API in a single transaction, either every case is saved or none is.
+// Unlike StoreCase every field is written, so values cleared upstream are cleared in the cache too.
+// When a Classifier is set each Case is classified before it is saved, unless it was triaged manually.
+func (c Cache) StoreCases(cases []api.Case) (StoreSummary, error) {
+	summary := StoreSummary{}
+	myCases := latestCases(c.ConvertToDBCases(cases))
+	if len(myCases) == 0 {
+		return summary, nil
+	}
+	err := c.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		summary, err = c.withDB(tx).storeCases(myCases)
+		return err
+	})
+	if err != nil {
+		log.Printf("Error storing cases:  %s", err)
+		return StoreSummary{}, err
+	}
+	log.Printf("Stored %d cases: %d new, %d updated, %d unchanged\n",
+		len(myCases), len(summary.Inserted), len(summary.Updated), len(summary.Unchanged))
+	return summary, nil
+}
+
+// latestCases drops all but the last occurrence of a Case listed more than once,
+// an upsert may not touch the same row twice
+func latestCases(myCases []Case) []Case {
+	last := map[string]int{}
+	for i, myCase := range myCases {
+		last[myCase.Id] = i
+	}
+	result := make([]Case, 0, len(last))
+	for i, myCase := range myCases {
+		if last[myCase.Id] == i {
+			result = append(result, myCase)
+		}
+	}
+	return result
+}
+
+func (c Cache) storeCases(myCases []Case) (StoreSummary, error) {
+	summary := StoreSummary{}
+	ids := make([]string, 0, len(myCases))
+	for _, myCase := range myCases {
+		ids = append(ids, myCase.Id)
+	}
+	existing, err := c.casesByID(ids)
+	if err != nil {
+		return summary, err
+	}
+
+	changed := make([]Case, 0, len(myCases))
+	history := make([]CaseHistory, 0)
+	for i := range myCases {
+		myCase := &myCases[i]
+		old, found := existing[myCase.Id]
+		switch {
+		case c.Classifier != nil:
+			c.classify(myCase, old)
+		case found:
+			copyClassification(myCase, old)
+		}
+		copyRunTracking(myCase, old)
+		copyAnnotations(myCase, old)
+		switch {
+		case !found:
+			summary.Inserted = append(summary.Inserted, myCase.Id)
+		case sameCase(old, *myCase):
+			summary.Unchanged = append(summary.Unchanged, myCase.Id)
+			continue
+		default:
+			summary.Updated = append(summary.Updated, myCase.Id)
+		}
+		history = append(history, historyEntries(old, *myCase)...)
+		changed = append(changed, *myCase)
+	}
+	if len(changed) == 0 {
+		return summary, nil
+	}
+
+	err = c.DB.Omit("Products").Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		UpdateAll: true,
+	}).CreateInBatches(&changed, storeBatchSize).Error
+	if err != nil {
+		return summary, err
+	}
+	if len(history) > 0 {
+		err = c.DB.CreateInBatches(&history, storeBatchSize).Error
+		if err != nil {
+			return summary, err
+		}
+	}
+	err = c.linkProducts(changed, existing)
+	if err != nil {
+		return summary, err
+	}
+	changedIds := make([]string, 0, len(changed))
+	for _, myCase := range changed {
+		changedIds = append(changedIds, myCase.Id)
+	}
+	return summary, c.indexCases(changedIds)
+}
+
+// casesByID returns the cached cases with the given ids, with Products loaded
+func (c Cache) casesByID(ids []string) (map[string]Case, error) {
+	result := make(map[string]Case, len(ids))
+	for _, chunk := range chunks(ids) {
+		cases := make([]Case, 0, len(chunk))
+		err := c.DB.Preload("Products").Where("id IN ?", chunk).Find(&cases).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, myCase := range cases {
+			result[myCase.Id] = myCase
+		}
+	}
+	return result, nil
+}
+
+// chunks splits ids into slices of at most storeBatchSize, keeping statements under the bind variable limits
+func chunks(ids []string) [][]string {
+	result := make([][]string, 0, len(ids)/storeBatchSize+1)
+	for len(ids) > storeBatchSize {
+		result = append(result, ids[:storeBatchSize])
+		ids = ids[storeBatchSize:]
+	}
+	if len(ids) > 0 {
+		result = append(result, ids)
+	}
+	return result
+}
+
+// classify runs the Classifier against a Case unless the cached version was triaged manually,
+// in which case the manual decision is carried over
+func (c Cache) classify(myCase *Case, existing Case) {
+	if existing.ClassifiedBy == ClassifiedManually {
+		copyClassification(myCase, existing)
+		return
+	}
+	myCase.Relevance = RelevanceUnknown
+	myCase.Area = ""
+	myCase.ClassifiedBy = ""
+	myCase.Score = nil
+	c.Classifier.Classify(myCase)
+}
+
+// copyClassification keeps the cached classification of a Case, which the API knows nothing about
+func copyClassification(myCase *Case, existing Case) {
+	myCase.Relevance = existing.Relevance
+	myCase.Area = existing.Area
+	myCase.ClassifiedBy = existing.ClassifiedBy
+	myCase.Score = existing.Score
+}
+
+// copyRunTracking keeps what RecordRun noted about a Case, which the API knows nothing about
+func copyRunTracking(myCase *Case, existing Case) {
+	myCase.LastSeenRun = existing.LastSeenRun
+	myCase.LastSeenAt = existing.LastSeenAt
+	myCase.MissedRuns = existing.MissedRuns
+	myCase.Stale = existing.Stale
+}
+
+// copyAnnotations keeps the notes and assignee of a Case, which the API knows nothing about
+func copyAnnotations(myCase *Case, existing Case) {
+	myCase.Notes = existing.Notes
+	myCase.Assignee = existing.Assignee
+}
+
+// sameCase returns true when saving myCase would not change the cached version of it
+func sameCase(existing, myCase Case) bool {
+	if !sameProducts(existing.Products, myCase.Products) {
+		return false
+	}
+	return reflect.DeepEqual(comparableCase(existing), comparableCase(myCase))
+}
+
+// comparableCase drops what reflect.DeepEqual should not compare, the location and
+// precision of timestamps differ between what is read from the database and what is stored
+func comparableCase(myCase Case) Case {
+	myCase.Products = nil
+	for _, t := range []*time.Time{&myCase.CreatedDate, &myCase.LastModifiedDate, &myCase.LastPublicUpdateDate, &myCase.LastSeenAt} {
+		*t = t.UTC().Truncate(time.Microsecond)
+	}
+	return myCase
+}
+
+func productKey(p Product) string {
+	return p.Name + "\x00" + p.Version
+}
+
+func sameProducts(a, b []Product) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	keys := func(products []Product) []string {
+		result := make([]string, 0, len(products))
+		for _, p := range products {
+			result = append(result, productKey(p))
+		}
+		sort.Strings(result)
+		return result
+	}
+	return reflect.DeepEqual(keys(a), keys(b))
+}
+
+// linkProducts links each changed Case to exactly its Products, creating any product not seen before.
+// The links of cases whose products did not change are left alone.
+func (c Cache) linkProducts(changed []Case, existing map[string]Case) error {
+	productIDs, err := c.productIDs(changed)
+	if err != nil {
+		return err
+	}
+	relink := make([]string, 0)
+	links := make([]caseProduct, 0)
+	for _, myCase := range changed {
+		old, found := existing[myCase.Id]
+		if found {
+			if sameProducts(old.Products, myCase.Products) {
+				continue
+			}
+			relink = append(relink, myCase.Id)
+		}
+		linked := map[uint]bool{}
+		for _, p := range myCase.Products {
+			id := productIDs[productKey(p)]
+			if linked[id] {
+				continue
+			}
+			linked[id] = true
+			links = append(links, caseProduct{CaseId: myCase.Id, ProductID: id})
+		}
+	}
+	for _, chunk := range chunks(relink) {
+		err = c.DB.Where("case_id IN ?", chunk).Delete(&caseProduct{}).Error
+		if err != nil {
+			return err
+		}
+	}
+	if len(links) == 0 {
+		return nil
+	}
+	return c.DB.CreateInBatches(&links, storeBatchSize).Error
+}
+
+// productIDs returns the id of every product of the cases by productKey, creating the missing products
+func (c Cache) productIDs(myCases []Case) (map[string]uint, error) {
+	missing := map[string]Product{}
+	names := map[string]bool{}
+	for _, myCase := range myCases {
+		for _, p := range myCase.Products {
+			missing[productKey(p)] = Product{Name: p.Name, Version: p.Version}
+			names[p.Name] = true
+		}
+	}
+	nameList := make([]string, 0, len(names))
+	for name := range names {
+		nameList = append(nameList, name)
+	}
+	sort.Strings(nameList)
+
+	ids := map[string]uint{}
+	find := func() error {
+		for _, chunk := range chunks(nameList) {
+			products := make([]Product, 0)
+			err := c.DB.Where("name IN ?", chunk).Find(&products).Error
+			if err != nil {
+				return err
+			}
+			for _, p := range products {
+				ids[productKey(p)] = p.ID
+				delete(missing, productKey(p))
+			}
+		}
+		return nil
+	}
+	err := find()
+	if err != nil || len(missing) == 0 {
+		return ids, err
+	}
+	create := make([]Product, 0, len(missing))
+	for _, p := range missing {
+		create = append(create, p)
+	}
+	err = c.DB.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&create, storeBatchSize).Error
+	if err != nil {
+		return nil, err
+	}
+	// Look the new products up rather than trusting the ids returned by a batch insert
+	return ids, find()
+}
@@ -1,22 +1,60 @@
 package cache
 
 import (
+	"fmt"
 	"github.com/jwmatthews/case_watcher/pkg/api"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite" // Sqlite driver based on GGO
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 	"log"
 	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
 type Cache struct {
 	DB *gorm.DB
+	// Classifier is optional, when set it is given each Case from StoreCases before it is saved
+	Classifier Classifier
+	// IncludeStale keeps cases no longer returned by the search in reports and listings
+	IncludeStale bool
+	// textIndex is true when the FTS5 full text index is available
+	textIndex bool
+	dsn       string
 }
 
-func Init(dbName string) (Cache, error) {
+// Options adjust how Open prepares the database
+type Options struct {
+	// SkipMigrations opens the database as it is, for commands inspecting or applying migrations themselves
+	SkipMigrations bool
+	// NoBackup skips copying a SQLite database file aside before applying pending migrations
+	NoBackup bool
+}
+
+// Classifier sets Relevance, Area and ClassifiedBy on a Case
+type Classifier interface {
+	Classify(c *Case)
+}
+
+// MemoryDSN opens an ephemeral in-memory SQLite cache, private to the returned Cache and gone once it is closed
+const MemoryDSN = ":memory:"
+
+// memoryDBs counts in-memory databases so each gets a unique name
+var memoryDBs uint64
+
+// Init opens the cache described by dsn, creating and migrating the schema as needed.
+// A 'postgres://' or 'postgresql://' URL, or a 'host=...' keyword string, selects PostgreSQL,
+// MemoryDSN selects an in-memory SQLite database and anything else is treated as the path of a SQLite database file.
+func Init(dsn string) (Cache, error) {
+	return Open(dsn, Options{})
+}
+
+// Open is Init with Options
+func Open(dsn string, opts Options) (Cache, error) {
 	var err error
-	c := Cache{}
+	c := Cache{dsn: dsn}
 	newLogger := logger.New(
 		log.New(os.Stdout, "\r\n", log.LstdFlags), // io writer
 		logger.Config{
@@ -28,7 +66,7 @@ func Init(dbName string) (Cache, error) {
 		},
 	)
 
-	c.DB, err = gorm.Open(sqlite.Open(dbName),
+	c.DB, err = gorm.Open(dialector(dsn),
 		&gorm.Config{
 			Logger: newLogger,
 		})
@@ -36,37 +74,302 @@ func Init(dbName string) (Cache, error) {
 		log.Printf("Error opening db:  %s\n", err)
 		return c, err
 	}
-	err = c.DB.AutoMigrate(&Case{}, &Product{}, &Account{})
+	if dsn == MemoryDSN {
+		// Use a single connection, the in-memory database is dropped when its last connection closes
+		// and concurrent connections to a shared cache fail with 'table is locked'.
+		db, err := c.DB.DB()
+		if err != nil {
+			return c, err
+		}
+		db.SetMaxOpenConns(1)
+	}
+	if opts.SkipMigrations {
+		return c, nil
+	}
+	_, _, err = c.Migrate(!opts.NoBackup)
 	if err != nil {
 		log.Printf("Error migrating schema: %s\n", err)
 		return c, err
 	}
+	err = c.initTextIndex()
+	if err != nil {
+		log.Printf("Error building full text index: %s\n", err)
+		return c, err
+	}
 	return c, nil
 }
 
-func (c Cache) StoreCases(cases []api.Case) error {
-	// Convert from the API representation of cases to our DB model
-	myCases := c.ConvertToDBCases(cases)
+// postgresKeywords are the connection settings a PostgreSQL key=value DSN may start with
+var postgresKeywords = map[string]bool{
+	"host": true, "hostaddr": true, "port": true, "dbname": true, "user": true, "password": true, "passfile": true,
+	"sslmode": true, "sslcert": true, "sslkey": true, "sslrootcert": true, "connect_timeout": true,
+	"application_name": true, "options": true, "target_session_attrs": true, "service": true,
+}
 
-	for _, tmpCase := range myCases {
-		err := c.StoreCase(tmpCase)
-		if err != nil {
-			log.Printf("Error storing case '%s':  %s", tmpCase.Id, err)
-			return err
-		}
+// IsPostgres returns true when the DSN given to Init selects PostgreSQL: a postgres:// or postgresql:// URL,
+// or key=value settings starting with a connection setting, e.g. 'host=db dbname=cases'.
+// Anything else, such as 'cache.db' or 'file:cache.db?_busy_timeout=5000', is a SQLite database.
+func IsPostgres(dsn string) bool {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return true
+	}
+	fields := strings.Fields(dsn)
+	if len(fields) == 0 {
+		return false
+	}
+	key := strings.SplitN(fields[0], "=", 2)
+	return len(key) == 2 && postgresKeywords[key[0]]
+}
+
+func dialector(dsn string) gorm.Dialector {
+	if IsPostgres(dsn) {
+		return postgres.Open(dsn)
+	}
+	if dsn == MemoryDSN {
+		n := atomic.AddUint64(&memoryDBs, 1)
+		return sqlite.Open(fmt.Sprintf("file:case_watcher_memory_%d?mode=memory&cache=shared", n))
+	}
+	return sqlite.Open(dsn)
+}
+
+// isPostgres returns true when the cache is backed by PostgreSQL
+func (c Cache) isPostgres() bool {
+	return c.DB.Dialector.Name() == "postgres"
+}
+
+// SetClassifier sets the Classifier used by StoreCases
+func (c *Cache) SetClassifier(classifier Classifier) {
+	c.Classifier = classifier
+}
+
+// Close releases the underlying database connections
+func (c Cache) Close() error {
+	db, err := c.DB.DB()
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// SetScore records the relevance probability predicted for a Case
+func (c Cache) SetScore(id string, score float64) error {
+	return c.DB.Model(&Case{}).Where("id = ?", id).Update("score", score).Error
+}
+
+// SaveClassifierModel stores a serialized classifier under name, replacing any previous version
+func (c Cache) SaveClassifierModel(name string, data []byte) error {
+	return c.DB.Save(&ClassifierModel{Name: name, Data: data, TrainedAt: time.Now()}).Error
+}
+
+// LoadClassifierModel returns a serialized classifier, gorm.ErrRecordNotFound if none has been trained
+func (c Cache) LoadClassifierModel(name string) ([]byte, error) {
+	m := ClassifierModel{}
+	err := c.DB.Where("name = ?", name).First(&m).Error
+	if err != nil {
+		return nil, err
+	}
+	return m.Data, nil
+}
+
+// SetTriage records a manual triage decision for a Case, it takes precedence over classification rules
+func (c Cache) SetTriage(id, relevance, area string) error {
+	result := c.DB.Model(&Case{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"relevance":     relevance,
+		"area":          area,
+		"classified_by": ClassifiedManually,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("no cached case with id '%s'", id)
 	}
 	return nil
 }
 
+// SetAnnotations records the notes and assignee the team keeps for a Case
+func (c Cache) SetAnnotations(id, notes, assignee string) error {
+	result := c.DB.Model(&Case{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"notes":    notes,
+		"assignee": assignee,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("no cached case with id '%s'", id)
+	}
+	return nil
+}
+
+// StoreCase saves a Case, its history and its products in a single transaction.
+// Empty fields leave the cached value alone, as do nil Products, while a non-nil
+// Products slice replaces the products linked to the Case.
 func (c Cache) StoreCase(myCase Case) error {
 	log.Printf("Attempting to save: %v\n", myCase)
-	if c.DB.Model(&myCase).Where("id = ?", myCase.Id).Updates(&myCase).RowsAffected == 0 {
-		c.DB.Create(&myCase)
+	return c.DB.Transaction(func(tx *gorm.DB) error {
+		return c.withDB(tx).storeCase(myCase)
+	})
+}
+
+// withDB returns a copy of the Cache issuing its queries through db, e.g. a transaction
+func (c Cache) withDB(db *gorm.DB) Cache {
+	c.DB = db
+	return c
+}
+
+func (c Cache) storeCase(myCase Case) error {
+	err := c.recordHistory(myCase)
+	if err != nil {
+		return err
+	}
+	result := c.DB.Model(&myCase).Omit("Products").Where("id = ?", myCase.Id).Updates(&myCase)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		err = c.DB.Omit("Products").Create(&myCase).Error
+		if err != nil {
+			return err
+		}
 		log.Printf("Saved new case: %s\n", myCase.Id)
 	}
+	if myCase.Products != nil {
+		err = c.syncProducts(myCase)
+		if err != nil {
+			return err
+		}
+	}
+	return c.indexCase(myCase.Id)
+}
+
+// syncProducts links the Case to exactly its Products, creating any product not seen before
+func (c Cache) syncProducts(myCase Case) error {
+	products := make([]Product, 0, len(myCase.Products))
+	for _, p := range myCase.Products {
+		prod := Product{}
+		err := c.DB.Where(Product{Name: p.Name, Version: p.Version}).FirstOrCreate(&prod).Error
+		if err != nil {
+			return err
+		}
+		products = append(products, prod)
+	}
+	return c.DB.Model(&Case{Id: myCase.Id}).Association("Products").Replace(products)
+}
+
+// DeleteCase removes a Case along with its product links, history and full text index entry.
+// Products themselves are kept as other cases may refer to them.
+func (c Cache) DeleteCase(id string) error {
+	return c.DB.Transaction(func(tx *gorm.DB) error {
+		txCache := c.withDB(tx)
+		err := tx.Model(&Case{Id: id}).Association("Products").Clear()
+		if err != nil {
+			return err
+		}
+		err = tx.Where("case_id = ?", id).Delete(&CaseHistory{}).Error
+		if err != nil {
+			return err
+		}
+		result := tx.Where("id = ?", id).Delete(&Case{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("no cached case with id '%s'", id)
+		}
+		return txCache.unindexCase(id)
+	})
+}
+
+// recordHistory saves a CaseHistory entry for each tracked field that differs from what is cached
+func (c Cache) recordHistory(myCase Case) error {
+	existing := Case{}
+	c.DB.Where("id = ?", myCase.Id).Limit(1).Find(&existing)
+	history := historyEntries(existing, myCase)
+	if len(history) == 0 {
+		return nil
+	}
+	return c.DB.Create(&history).Error
+}
+
+// historyEntries returns the changes to tracked fields between the cached and the new version of a Case.
+// Like StoreCase, empty values are treated as unknown rather than a change.
+func historyEntries(existing, myCase Case) []CaseHistory {
+	changedAt := myCase.LastModifiedDate
+	if changedAt.IsZero() {
+		changedAt = time.Now()
+	}
+	tracked := []struct {
+		field    string
+		old, new string
+	}{
+		{"Status", existing.Status, myCase.Status},
+		{"Severity", existing.Severity, myCase.Severity},
+		{"Owner", existing.Owner, myCase.Owner},
+	}
+	history := make([]CaseHistory, 0)
+	for _, t := range tracked {
+		if t.new == "" || t.new == t.old {
+			continue
+		}
+		history = append(history, CaseHistory{CaseId: myCase.Id, Field: t.field, OldValue: t.old, NewValue: t.new, ChangedAt: changedAt})
+	}
+	return history
+}
+
+// GetCaseHistory returns the recorded changes of a Case, oldest first
+func (c Cache) GetCaseHistory(id string) ([]CaseHistory, error) {
+	history := make([]CaseHistory, 0)
+	err := c.DB.Where("case_id = ?", id).Order("changed_at asc, id asc").Find(&history).Error
+	if err != nil {
+		return []CaseHistory{}, err
+	}
+	return history, nil
+}
+
+// GetFieldHistory returns the recorded changes of a field, e.g. "Status", across all cases, oldest first
+func (c Cache) GetFieldHistory(field string) ([]CaseHistory, error) {
+	history := make([]CaseHistory, 0)
+	err := c.DB.Where("field = ?", field).Order("changed_at asc, id asc").Find(&history).Error
+	if err != nil {
+		return []CaseHistory{}, err
+	}
+	return history, nil
+}
+
+// StoreAccounts saves account details, replacing any previously cached for the same account number
+func (c Cache) StoreAccounts(accounts []api.Account) error {
+	for _, a := range accounts {
+		account := Account{
+			AccountNumber:  a.AccountNumber,
+			GSCSMSegment:   a.GSCSMSegment,
+			Name:           a.Name,
+			CSMUserID:      a.CSMUserID,
+			CSMUserName:    a.CSMUserName,
+			CSMUserSSOName: a.CSMUserSSOName,
+			Strategic:      a.Strategic,
+			HasEnhancedSLA: a.HasEnhancedSLA,
+			HasSRM:         a.HasSRM,
+			HasTAM:         a.HasTAM,
+		}
+		err := c.DB.Save(&account).Error
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// GetAccount returns the cached details of an account
+func (c Cache) GetAccount(accountNumber string) (Account, error) {
+	account := Account{}
+	err := c.DB.Where("account_number = ?", accountNumber).First(&account).Error
+	if err != nil {
+		return Account{}, err
+	}
+	return account, nil
+}
+
 // ConvertToDBCases converts from the format returned from remote API
 // to the format we will save to the database
 func (c Cache) ConvertToDBCases(cases []api.Case) []Case {
@@ -82,7 +385,6 @@ func (c Cache) ConvertToDBCases(cases []api.Case) []Case {
 // I *think* I need the 2 different formats to handle some subtle
 // differences with JSON vs Gorm modeling of Products, but I am not 100% certain
 // TODO: See if there is a cleaner way to refactor to a single reused structure definition between incoming API data and DB
-//
 func (c Cache) ConvertToDBCase(ac api.Case) Case {
 	myCase := Case{}
 	myCase.AccountNumber = ac.AccountNumber
@@ -97,13 +399,10 @@ func (c Cache) ConvertToDBCase(ac api.Case) Case {
 	myCase.LastPublicUpdateDate = ac.LastPublicUpdateDate
 	myCase.LastPublicUpdateBy = ac.LastPublicUpdateBy
 	myCase.Owner = ac.Owner
-	// Products
+	// Products, the case's version applies to each of them
+	myCase.Products = make([]Product, 0, len(ac.Products))
 	for _, p := range ac.Products {
-		prod := Product{}
-		if c.DB.Where(&Product{Name: p, CaseId: ac.Id}).First(&prod).RowsAffected == 0 {
-			prod.Name = p
-		}
-		myCase.Products = append(myCase.Products, prod)
+		myCase.Products = append(myCase.Products, Product{Name: p, Version: ac.Version})
 	}
 	myCase.Severity = ac.Severity
 	myCase.Summary = ac.Summary
@@ -116,23 +415,27 @@ func (c Cache) ConvertToDBCase(ac api.Case) Case {
 
 // GetMissingAccountIDs will return a slice of account ids we lack details on
 func (c Cache) GetMissingAccountIDs() []string {
-	// Find all Account IDs from all Cases saved.
-	accountNums := make([]string, 0)
+	// Find the distinct Account IDs of the cases saved which do not have an entry in the Database
 	result := make([]string, 0)
-	c.DB.Raw("SELECT account_number FROM cases WHERE 'account_number' is NOT NULL").Scan(&accountNums)
-	for i := range accountNums {
-		if accountNums[i] != "" {
-			result = append(result, accountNums[i])
-		}
-	}
+	c.DB.Model(&Case{}).Distinct("account_number").Where("account_number <> ''").
+		Where("account_number NOT IN (?)", c.DB.Model(&Account{}).Select("account_number")).
+		Order("account_number").Find(&result)
 	return result
-	//c.DB.Table("cases").Select("account_number").Scan(&accountNums)
-	// Find all Account IDs which do not have an entry in the Database
+}
+
+// GetCase looks up a single Case by its Id or CaseNumber
+func (c Cache) GetCase(idOrNumber string) (Case, error) {
+	myCase := Case{}
+	err := c.DB.Preload("Products").Where("id = ? OR case_number = ?", idOrNumber, idOrNumber).First(&myCase).Error
+	if err != nil {
+		return Case{}, err
+	}
+	return myCase, nil
 }
 
 func (c Cache) GetAllCases() ([]Case, error) {
 	cases := make([]Case, 0)
-	err := c.DB.Where(&Case{}).Find(&cases).Error
+	err := c.DB.Preload("Products").Where(&Case{}).Find(&cases).Error
 	if err != nil {
 		return []Case{}, err
 	}
@@ -141,7 +444,7 @@ func (c Cache) GetAllCases() ([]Case, error) {
 
 func (c Cache) GetOpenCases() ([]Case, error) {
 	cases := make([]Case, 0)
-	err := c.DB.Where("status != 'Closed'").Find(&cases).Error
+	err := c.current().Where("status != 'Closed'").Find(&cases).Error
 	if err != nil {
 		return []Case{}, err
 	}
@@ -150,7 +453,7 @@ func (c Cache) GetOpenCases() ([]Case, error) {
 
 func (c Cache) GetClosedCases() ([]Case, error) {
 	cases := make([]Case, 0)
-	err := c.DB.Where("status == 'Closed'").Find(&cases).Error
+	err := c.current().Where("status = 'Closed'").Find(&cases).Error
 	if err != nil {
 		return []Case{}, err
 	}
@@ -159,7 +462,17 @@ func (c Cache) GetClosedCases() ([]Case, error) {
 
 func (c Cache) GetCasesActiveFrom(since time.Time) ([]Case, error) {
 	cases := make([]Case, 0)
-	err := c.DB.Where("last_modified_date >= ?", since).Find(&cases).Error
+	err := c.current().Where("last_modified_date >= ?", since).Find(&cases).Error
+	if err != nil {
+		return []Case{}, err
+	}
+	return cases, nil
+}
+
+// GetCasesCreatedFrom returns cases opened on or after 'since'
+func (c Cache) GetCasesCreatedFrom(since time.Time) ([]Case, error) {
+	cases := make([]Case, 0)
+	err := c.current().Where("created_date >= ?", since).Find(&cases).Error
 	if err != nil {
 		return []Case{}, err
 	}
@@ -0,0 +1,435 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
+	"time"
+)
+
+// ExportFormat identifies the JSON Lines files written by Export
+const ExportFormat = "case_watcher"
+
+// Types of the records in an export, in the order Export writes them
+const (
+	RecordHeader          = "header"
+	RecordProduct         = "product"
+	RecordRun             = "run"
+	RecordCase            = "case"
+	RecordHistory         = "history"
+	RecordAccount         = "account"
+	RecordClassifierModel = "classifier_model"
+	RecordSetting         = "setting"
+)
+
+// ExportHeader is the first record of an export
+type ExportHeader struct {
+	Format        string
+	SchemaVersion int
+	ExportedAt    time.Time
+}
+
+// exportRecord is a line of an export, Record holds the JSON of the model named by Type
+type exportRecord struct {
+	Type   string          `json:"type"`
+	Record json.RawMessage `json:"record"`
+}
+
+// RecordCounts is the number of records exported or imported by type
+type RecordCounts map[string]int
+
+// Export writes the whole cache as JSON Lines, one record per line after an ExportHeader.
+// Cases carry their products, triage and run tracking, see Import to load an export.
+func (c Cache) Export(w io.Writer) (RecordCounts, error) {
+	counts := RecordCounts{}
+	version, err := c.SchemaVersion()
+	if err != nil {
+		return counts, err
+	}
+	enc := json.NewEncoder(w)
+	write := func(recordType string, record interface{}) error {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		counts[recordType]++
+		return enc.Encode(exportRecord{Type: recordType, Record: data})
+	}
+	err = write(RecordHeader, ExportHeader{Format: ExportFormat, SchemaVersion: version, ExportedAt: time.Now().UTC()})
+	if err != nil {
+		return counts, err
+	}
+
+	// Read everything in one transaction for a consistent snapshot, in an order making exports of the same data
+	// identical. Product and history ids are left out, they are not kept by Import.
+	err = c.DB.Transaction(func(tx *gorm.DB) error {
+		err := paginate(func(offset int) (int, error) {
+			rows := make([]Product, 0)
+			err := tx.Order("name, version").Offset(offset).Limit(storeBatchSize).Find(&rows).Error
+			for _, row := range rows {
+				if err == nil {
+					row.ID = 0
+					err = write(RecordProduct, row)
+				}
+			}
+			return len(rows), err
+		})
+		if err != nil {
+			return err
+		}
+		err = paginate(func(offset int) (int, error) {
+			rows := make([]Run, 0)
+			err := tx.Order("id").Offset(offset).Limit(storeBatchSize).Find(&rows).Error
+			for _, row := range rows {
+				if err == nil {
+					err = write(RecordRun, row)
+				}
+			}
+			return len(rows), err
+		})
+		if err != nil {
+			return err
+		}
+		err = paginate(func(offset int) (int, error) {
+			rows := make([]Case, 0)
+			err := tx.Preload("Products", func(db *gorm.DB) *gorm.DB { return db.Order("name, version") }).
+				Order("id").Offset(offset).Limit(storeBatchSize).Find(&rows).Error
+			for _, row := range rows {
+				if err == nil {
+					for i := range row.Products {
+						row.Products[i].ID = 0
+					}
+					err = write(RecordCase, row)
+				}
+			}
+			return len(rows), err
+		})
+		if err != nil {
+			return err
+		}
+		err = paginate(func(offset int) (int, error) {
+			rows := make([]CaseHistory, 0)
+			err := tx.Order("case_id, changed_at, id").Offset(offset).Limit(storeBatchSize).Find(&rows).Error
+			for _, row := range rows {
+				if err == nil {
+					row.ID = 0
+					err = write(RecordHistory, row)
+				}
+			}
+			return len(rows), err
+		})
+		if err != nil {
+			return err
+		}
+		err = paginate(func(offset int) (int, error) {
+			rows := make([]Account, 0)
+			err := tx.Order("account_number").Offset(offset).Limit(storeBatchSize).Find(&rows).Error
+			for _, row := range rows {
+				if err == nil {
+					err = write(RecordAccount, row)
+				}
+			}
+			return len(rows), err
+		})
+		if err != nil {
+			return err
+		}
+		err = paginate(func(offset int) (int, error) {
+			rows := make([]ClassifierModel, 0)
+			err := tx.Order("name").Offset(offset).Limit(storeBatchSize).Find(&rows).Error
+			for _, row := range rows {
+				if err == nil {
+					err = write(RecordClassifierModel, row)
+				}
+			}
+			return len(rows), err
+		})
+		if err != nil {
+			return err
+		}
+		return paginate(func(offset int) (int, error) {
+			rows := make([]Setting, 0)
+			err := tx.Order("name").Offset(offset).Limit(storeBatchSize).Find(&rows).Error
+			for _, row := range rows {
+				if err == nil {
+					err = write(RecordSetting, row)
+				}
+			}
+			return len(rows), err
+		})
+	})
+	return counts, err
+}
+
+// paginate calls page with increasing offsets, storeBatchSize apart, until it returns fewer rows than that
+func paginate(page func(offset int) (int, error)) error {
+	for offset := 0; ; offset += storeBatchSize {
+		n, err := page(offset)
+		if err != nil || n < storeBatchSize {
+			return err
+		}
+	}
+}
+
+// Import loads an export written by Export in a single transaction, upserting every record: cases, accounts,
+// classifier models and settings replace those with the same key, products and history rows already present are skipped.
+// Runs are matched by start time and query rather than id, which differs between caches, and the last run
+// of imported cases is renumbered to match. Cases and accounts are written as exported, not through StoreCases
+// and StoreAccounts which convert and classify API records. An export from a newer schema version than the cache is refused.
+func (c Cache) Import(r io.Reader) (RecordCounts, error) {
+	counts := RecordCounts{}
+	version, err := c.SchemaVersion()
+	if err != nil {
+		return counts, err
+	}
+	dec := json.NewDecoder(r)
+	header := ExportHeader{}
+	first := exportRecord{}
+	err = dec.Decode(&first)
+	if err == nil && first.Type != RecordHeader {
+		err = fmt.Errorf("expected a %s record first, found '%s'", RecordHeader, first.Type)
+	}
+	if err == nil {
+		err = json.Unmarshal(first.Record, &header)
+	}
+	if err != nil {
+		return counts, fmt.Errorf("not a %s export: %w", ExportFormat, err)
+	}
+	if header.Format != ExportFormat {
+		return counts, fmt.Errorf("not a %s export: format is '%s'", ExportFormat, header.Format)
+	}
+	if header.SchemaVersion > version {
+		return counts, fmt.Errorf("export is of schema version %d, newer than the cache at %d, upgrade case_watcher first",
+			header.SchemaVersion, version)
+	}
+
+	err = c.DB.Transaction(func(tx *gorm.DB) error {
+		txCache := c.withDB(tx)
+		b := importBatch{}
+		runIDs := map[uint]uint{}
+		for {
+			record := exportRecord{}
+			err := dec.Decode(&record)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("record %d: %w", counts.total()+1, err)
+			}
+			err = b.add(record)
+			if err != nil {
+				return fmt.Errorf("record %d: %w", counts.total()+1, err)
+			}
+			counts[record.Type]++
+			if b.size() >= storeBatchSize {
+				err = txCache.importBatch(b, runIDs)
+				if err != nil {
+					return err
+				}
+				b = importBatch{}
+			}
+		}
+		return txCache.importBatch(b, runIDs)
+	})
+	if err != nil {
+		return RecordCounts{}, err
+	}
+	counts[RecordHeader] = 1
+	return counts, nil
+}
+
+func (counts RecordCounts) total() int {
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	return total
+}
+
+// importBatch collects imported records by type
+type importBatch struct {
+	products []Product
+	runs     []Run
+	cases    []Case
+	history  []CaseHistory
+	accounts []Account
+	models   []ClassifierModel
+	settings []Setting
+}
+
+func (b *importBatch) size() int {
+	return len(b.products) + len(b.runs) + len(b.cases) + len(b.history) + len(b.accounts) + len(b.models) + len(b.settings)
+}
+
+func (b *importBatch) add(record exportRecord) error {
+	var err error
+	switch record.Type {
+	case RecordProduct:
+		p := Product{}
+		err = json.Unmarshal(record.Record, &p)
+		p.ID = 0
+		b.products = append(b.products, p)
+	case RecordRun:
+		run := Run{}
+		err = json.Unmarshal(record.Record, &run)
+		b.runs = append(b.runs, run)
+	case RecordCase:
+		myCase := Case{}
+		err = json.Unmarshal(record.Record, &myCase)
+		if myCase.Products == nil {
+			myCase.Products = []Product{}
+		}
+		b.cases = append(b.cases, myCase)
+	case RecordHistory:
+		h := CaseHistory{}
+		err = json.Unmarshal(record.Record, &h)
+		h.ID = 0
+		b.history = append(b.history, h)
+	case RecordAccount:
+		a := Account{}
+		err = json.Unmarshal(record.Record, &a)
+		b.accounts = append(b.accounts, a)
+	case RecordClassifierModel:
+		m := ClassifierModel{}
+		err = json.Unmarshal(record.Record, &m)
+		b.models = append(b.models, m)
+	case RecordSetting:
+		s := Setting{}
+		err = json.Unmarshal(record.Record, &s)
+		b.settings = append(b.settings, s)
+	default:
+		err = fmt.Errorf("unknown record type '%s'", record.Type)
+	}
+	return err
+}
+
+// importBatch writes a batch of imported records, runIDs maps the run ids of the export to those of the cache
+func (c Cache) importBatch(b importBatch, runIDs map[uint]uint) error {
+	if len(b.products) > 0 {
+		_, err := c.productIDs([]Case{{Products: b.products}})
+		if err != nil {
+			return err
+		}
+	}
+	if len(b.runs) > 0 {
+		err := c.restoreRuns(b.runs, runIDs)
+		if err != nil {
+			return err
+		}
+	}
+	if len(b.cases) > 0 {
+		for i := range b.cases {
+			// A run left out of the export, or of an older one, is not known here
+			b.cases[i].LastSeenRun = runIDs[b.cases[i].LastSeenRun]
+		}
+		err := c.restoreCases(latestCases(b.cases))
+		if err != nil {
+			return err
+		}
+	}
+	if len(b.history) > 0 {
+		err := c.restoreHistory(b.history)
+		if err != nil {
+			return err
+		}
+	}
+	if len(b.accounts) > 0 {
+		err := c.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&b.accounts).Error
+		if err != nil {
+			return err
+		}
+	}
+	if len(b.models) > 0 {
+		err := c.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&b.models).Error
+		if err != nil {
+			return err
+		}
+	}
+	if len(b.settings) > 0 {
+		return c.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&b.settings).Error
+	}
+	return nil
+}
+
+// restoreCases upserts cases exactly as given, unlike StoreCases nothing is classified or recorded as history
+func (c Cache) restoreCases(myCases []Case) error {
+	ids := make([]string, 0, len(myCases))
+	for _, myCase := range myCases {
+		ids = append(ids, myCase.Id)
+	}
+	existing, err := c.casesByID(ids)
+	if err != nil {
+		return err
+	}
+	err = c.DB.Omit("Products").Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		UpdateAll: true,
+	}).Create(&myCases).Error
+	if err != nil {
+		return err
+	}
+	err = c.linkProducts(myCases, existing)
+	if err != nil {
+		return err
+	}
+	return c.indexCases(ids)
+}
+
+// restoreHistory adds the history rows not already recorded for their case
+func (c Cache) restoreHistory(history []CaseHistory) error {
+	key := func(h CaseHistory) string {
+		return fmt.Sprintf("%s\x00%s\x00%s\x00%s\x00%d", h.CaseId, h.Field, h.OldValue, h.NewValue, h.ChangedAt.UnixNano()/1000)
+	}
+	caseIds := map[string]bool{}
+	for _, h := range history {
+		caseIds[h.CaseId] = true
+	}
+	ids := make([]string, 0, len(caseIds))
+	for id := range caseIds {
+		ids = append(ids, id)
+	}
+	recorded := map[string]bool{}
+	for _, chunk := range chunks(ids) {
+		existing := make([]CaseHistory, 0)
+		err := c.DB.Where("case_id IN ?", chunk).Find(&existing).Error
+		if err != nil {
+			return err
+		}
+		for _, h := range existing {
+			recorded[key(h)] = true
+		}
+	}
+	missing := make([]CaseHistory, 0, len(history))
+	for _, h := range history {
+		if !recorded[key(h)] {
+			recorded[key(h)] = true
+			missing = append(missing, h)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return c.DB.Create(&missing).Error
+}
+
+// restoreRuns adds the runs not already recorded with the same start time and query, updating those that are,
+// and records the id each run of the export has in the cache
+func (c Cache) restoreRuns(runs []Run, runIDs map[uint]uint) error {
+	for _, run := range runs {
+		exportedID := run.ID
+		existing := Run{}
+		err := c.DB.Where("started_at = ? AND query = ?", run.StartedAt, run.Query).Order("id").Limit(1).Find(&existing).Error
+		if err != nil {
+			return err
+		}
+		run.ID = existing.ID
+		err = c.DB.Save(&run).Error
+		if err != nil {
+			return err
+		}
+		runIDs[exportedID] = run.ID
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// populateForExport fills a cache with a bit of everything an export holds
+func populateForExport(t *testing.T, myCache *Cache) {
+	require.NoError(t, LoadFixture(myCache, "testdata/fixture.json"))
+	all, err := myCache.GetAllCases()
+	require.NoError(t, err)
+	require.NoError(t, myCache.SetTriage(all[0].Id, RelevanceRelevant, "backup"))
+	require.NoError(t, myCache.SetScore(all[1].Id, 0.25))
+	_, _, err = myCache.RecordRun("query", []string{all[0].Id}, 1)
+	require.NoError(t, err)
+	require.NoError(t, myCache.SaveClassifierModel("model", []byte{0, 1, 2}))
+	require.NoError(t, myCache.SetSetting("setting", "value"))
+	require.NoError(t, myCache.DB.Create(&Product{Name: "Unused", Version: "1.0"}).Error)
+}
+
+// body drops the header, which differs between exports by its timestamp
+func body(t *testing.T, export string) string {
+	lines := strings.SplitN(export, "\n", 2)
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"type":"header"`)
+	return lines[1]
+}
+
+func TestCache_ExportImportRoundTrip(t *testing.T) {
+	t.Parallel()
+	source := InitCache(t)
+	populateForExport(t, source)
+
+	exported := bytes.Buffer{}
+	counts, err := source.Export(&exported)
+	require.NoError(t, err)
+	assert.Equal(t, 3, counts[RecordCase])
+	assert.Equal(t, 1, counts[RecordAccount])
+	assert.Equal(t, 1, counts[RecordRun])
+	assert.Equal(t, 1, counts[RecordClassifierModel])
+	assert.Equal(t, 1, counts[RecordSetting])
+	assert.Greater(t, counts[RecordProduct], 1)
+	assert.Greater(t, counts[RecordHistory], 0)
+
+	target := InitCache(t)
+	imported, err := target.Import(bytes.NewReader(exported.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, counts, imported)
+
+	reexported := bytes.Buffer{}
+	_, err = target.Export(&reexported)
+	require.NoError(t, err)
+	assert.Equal(t, body(t, exported.String()), body(t, reexported.String()), "nothing should be lost in a round trip")
+
+	// Importing again changes nothing
+	_, err = target.Import(bytes.NewReader(exported.Bytes()))
+	require.NoError(t, err)
+	reexported.Reset()
+	_, err = target.Export(&reexported)
+	require.NoError(t, err)
+	assert.Equal(t, body(t, exported.String()), body(t, reexported.String()))
+
+	matches, err := target.SearchText("velero", 10)
+	require.NoError(t, err)
+	assert.NotEmpty(t, matches, "imported cases should be searchable")
+}
+
+func TestCache_ImportKeepsRunsOfTheCache(t *testing.T) {
+	t.Parallel()
+	source := InitCache(t)
+	populateForExport(t, source)
+	exported := bytes.Buffer{}
+	_, err := source.Export(&exported)
+	require.NoError(t, err)
+
+	target := InitCache(t)
+	own, _, err := target.RecordRun("other query", nil, 0)
+	require.NoError(t, err)
+	_, err = target.Import(bytes.NewReader(exported.Bytes()))
+	require.NoError(t, err)
+	_, err = target.Import(bytes.NewReader(exported.Bytes()))
+	require.NoError(t, err)
+
+	runs, err := target.GetRuns()
+	require.NoError(t, err)
+	require.Len(t, runs, 2, "imported runs are added once, not over the runs of the cache")
+	queries := map[uint]string{}
+	for _, run := range runs {
+		queries[run.ID] = run.Query
+	}
+	assert.Equal(t, "other query", queries[own.ID])
+	cases, err := target.GetAllCases()
+	require.NoError(t, err)
+	for _, myCase := range cases {
+		if myCase.LastSeenRun != 0 {
+			assert.Equal(t, "query", queries[myCase.LastSeenRun], "the last run of a case follows its run")
+		}
+	}
+}
+
+func TestCache_ImportRejectsBadExports(t *testing.T) {
+	t.Parallel()
+	myCache := InitCache(t)
+	version, err := myCache.SchemaVersion()
+	require.NoError(t, err)
+	header := func(v int) string {
+		return fmt.Sprintf(`{"type":"header","record":{"Format":"%s","SchemaVersion":%d}}`+"\n", ExportFormat, v)
+	}
+
+	tests := []struct {
+		name   string
+		export string
+	}{
+		{"no header", `{"type":"case","record":{"Id":"case1"}}` + "\n"},
+		{"newer schema", header(version + 1)},
+		{"unknown record", header(version) + `{"type":"case","record":{"Id":"case1"}}` + "\n" + `{"type":"bogus","record":{}}` + "\n"},
+		{"truncated", header(version) + `{"type":"case","record":{"Id":"ca`},
+	}
+	for _, tt := range tests {
+		_, err := myCache.Import(strings.NewReader(tt.export))
+		assert.Error(t, err, tt.name)
+	}
+	// Nothing from a failed import is kept
+	assert.Equal(t, int64(0), countRows(t, myCache, "cases"))
+}
+
+func TestCache_Backup(t *testing.T) {
+	t.Parallel()
+	myCache := InitCache(t)
+	populateForExport(t, myCache)
+
+	path := filepath.Join(t.TempDir(), "backup.db")
+	require.NoError(t, myCache.Backup(path))
+	assert.Error(t, myCache.Backup(path), "an existing file should not be overwritten")
+
+	backup := initCacheAt(t, path)
+	cases, err := backup.GetAllCases()
+	require.NoError(t, err)
+	assert.Len(t, cases, 3)
+	data, err := backup.LoadClassifierModel("model")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 1, 2}, data)
+}
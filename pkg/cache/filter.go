@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Triage states accepted by CaseFilter.Triage, in addition to the Relevance values
+const (
+	TriageUntriaged = "untriaged"
+	TriageManual    = "manual"
+)
+
+// SortColumns maps the names accepted by CaseFilter.SortBy to columns of the cases table
+var SortColumns = map[string]string{
+	"id":       "id",
+	"number":   "case_number",
+	"severity": "severity",
+	"status":   "status",
+	"owner":    "owner",
+	"account":  "account_number",
+	"created":  "created_date",
+	"modified": "last_modified_date",
+	"score":    "score",
+}
+
+// CaseFilter narrows down the cases returned by ListCases, empty fields are not filtered on
+type CaseFilter struct {
+	Statuses      []string
+	Severities    []string
+	Product       string
+	Owner         string
+	AccountNumber string
+	ModifiedSince time.Time
+	// Triage is one of RelevanceRelevant, RelevanceIgnored, TriageUntriaged or TriageManual
+	Triage    string
+	Escalated *bool
+	// SortBy is a key of SortColumns, prefix with '-' to sort descending
+	SortBy string
+}
+
+// ListCases returns the cached cases matching the filter, with Products loaded
+func (c Cache) ListCases(f CaseFilter) ([]Case, error) {
+	db := c.current().Preload("Products")
+	if len(f.Statuses) > 0 {
+		db = db.Where("status IN ?", f.Statuses)
+	}
+	if len(f.Severities) > 0 {
+		db = db.Where("severity IN ?", f.Severities)
+	}
+	if f.Product != "" {
+		db = db.Where("id IN (?)", c.DB.Table("case_products").Select("case_products.case_id").
+			Joins("JOIN products ON products.id = case_products.product_id").Where("products.name = ?", f.Product))
+	}
+	if f.Owner != "" {
+		db = db.Where("owner = ?", f.Owner)
+	}
+	if f.AccountNumber != "" {
+		db = db.Where("account_number = ?", f.AccountNumber)
+	}
+	if !f.ModifiedSince.IsZero() {
+		db = db.Where("last_modified_date >= ?", f.ModifiedSince)
+	}
+	switch f.Triage {
+	case "":
+	case RelevanceRelevant, RelevanceIgnored:
+		db = db.Where("relevance = ?", f.Triage)
+	case TriageUntriaged:
+		db = db.Where("relevance = '' OR relevance IS NULL")
+	case TriageManual:
+		db = db.Where("classified_by = ?", ClassifiedManually)
+	default:
+		return nil, fmt.Errorf("unknown triage state '%s'", f.Triage)
+	}
+	if f.Escalated != nil {
+		db = db.Where("customer_escalation = ?", *f.Escalated)
+	}
+
+	order := "last_modified_date desc"
+	if f.SortBy != "" {
+		key := strings.TrimPrefix(f.SortBy, "-")
+		column, ok := SortColumns[key]
+		if !ok {
+			return nil, fmt.Errorf("unable to sort by '%s'", key)
+		}
+		order = column + " asc"
+		if strings.HasPrefix(f.SortBy, "-") {
+			order = column + " desc"
+		}
+	}
+
+	cases := make([]Case, 0)
+	err := db.Order(order).Find(&cases).Error
+	if err != nil {
+		return []Case{}, err
+	}
+	return cases, nil
+}
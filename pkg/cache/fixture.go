@@ -0,0 +1,32 @@
+package cache
+
+import (
+	"encoding/json"
+	"github.com/jwmatthews/case_watcher/pkg/api"
+	"io/ioutil"
+)
+
+// Fixture is a set of cases and accounts, in the format returned by the case API, used to populate a cache
+// for tests and dry runs without contacting the API
+type Fixture struct {
+	Cases    []api.Case    `json:"cases"`
+	Accounts []api.Account `json:"accounts"`
+}
+
+// LoadFixture reads a Fixture from a JSON file and stores its contents
+func LoadFixture(s Store, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	f := Fixture{}
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return err
+	}
+	_, err = s.StoreCases(f.Cases)
+	if err != nil {
+		return err
+	}
+	return s.StoreAccounts(f.Accounts)
+}
@@ -0,0 +1,357 @@
+package cache
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// Migration is a numbered, append-only change to the cache schema.
+// Each migration is applied in its own transaction and recorded in the schema_migrations table.
+// Migrations must not reference the live models, which keep changing, but their own copies
+// of the structures as they were when the migration was written.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+}
+
+// SchemaMigration records an applied Migration
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// The schema as it was when versioned migrations were introduced
+type productV1 struct {
+	gorm.Model
+	Name   string
+	CaseId string
+}
+
+func (productV1) TableName() string { return "products" }
+
+type caseV1 struct {
+	AccountNumber        string
+	CaseNumber           string
+	ContactName          string
+	CreatedByName        string
+	CreatedDate          time.Time
+	CustomerEscalation   bool
+	Id                   string      `gorm:"primary_key"`
+	Products             []productV1 `gorm:"foreignKey:CaseId"`
+	LastModifiedByName   string
+	LastModifiedDate     time.Time
+	LastPublicUpdateBy   string
+	LastPublicUpdateDate time.Time
+	Owner                string
+	Severity             string
+	Summary              string
+	Status               string
+	Type                 string
+	Uri                  string
+	Version              string
+	Relevance            string
+	Area                 string
+	ClassifiedBy         string
+	Score                *float64
+}
+
+func (caseV1) TableName() string { return "cases" }
+
+type accountV1 struct {
+	AccountNumber  string `gorm:"primaryKey"`
+	GSCSMSegment   string
+	Name           string
+	CSMUserID      string
+	CSMUserName    string
+	CSMUserSSOName string
+	Strategic      bool
+	HasEnhancedSLA bool
+	HasSRM         bool
+	HasTAM         bool
+}
+
+func (accountV1) TableName() string { return "accounts" }
+
+type classifierModelV1 struct {
+	Name      string `gorm:"primaryKey"`
+	Data      []byte
+	TrainedAt time.Time
+}
+
+func (classifierModelV1) TableName() string { return "classifier_models" }
+
+type caseHistoryV1 struct {
+	ID        uint   `gorm:"primaryKey"`
+	CaseId    string `gorm:"index"`
+	Field     string
+	OldValue  string
+	NewValue  string
+	ChangedAt time.Time
+}
+
+func (caseHistoryV1) TableName() string { return "case_histories" }
+
+// Products normalized into one row per name and version, linked to cases through case_products
+type productV2 struct {
+	ID      uint   `gorm:"primaryKey"`
+	Name    string `gorm:"uniqueIndex:idx_products_name_version"`
+	Version string `gorm:"uniqueIndex:idx_products_name_version"`
+}
+
+func (productV2) TableName() string { return "products" }
+
+type caseV2 struct {
+	Id       string      `gorm:"primary_key"`
+	Products []productV2 `gorm:"many2many:case_products;joinForeignKey:CaseId;joinReferences:ProductID;constraint:OnDelete:CASCADE"`
+}
+
+func (caseV2) TableName() string { return "cases" }
+
+// Tracking which cases each search returned
+type runV3 struct {
+	ID         uint `gorm:"primaryKey"`
+	StartedAt  time.Time
+	Query      string
+	CasesSeen  int
+	StaleCases int
+}
+
+func (runV3) TableName() string { return "runs" }
+
+type caseV3 struct {
+	Id          string `gorm:"primary_key"`
+	LastSeenRun uint
+	LastSeenAt  time.Time
+	MissedRuns  int
+	Stale       bool `gorm:"index"`
+}
+
+func (caseV3) TableName() string { return "cases" }
+
+// Runs merged into daily summaries by retention
+type runV4 struct {
+	ID            uint `gorm:"primaryKey"`
+	CompactedRuns int
+}
+
+func (runV4) TableName() string { return "runs" }
+
+// Team annotations read back from the spreadsheet
+type caseV5 struct {
+	Id       string `gorm:"primary_key"`
+	Notes    string
+	Assignee string
+}
+
+func (caseV5) TableName() string { return "cases" }
+
+// Values remembered between runs
+type settingV6 struct {
+	Name  string `gorm:"primaryKey"`
+	Value string
+	SetAt time.Time
+}
+
+func (settingV6) TableName() string { return "settings" }
+
+// normalizeProducts replaces the per-case product rows with shared products, keeping every existing link
+func normalizeProducts(tx *gorm.DB) error {
+	if err := tx.Exec("ALTER TABLE products RENAME TO legacy_products").Error; err != nil {
+		return err
+	}
+	if err := tx.AutoMigrate(&productV2{}, &caseV2{}); err != nil {
+		return err
+	}
+	statements := []string{
+		"INSERT INTO products (name, version) " +
+			"SELECT DISTINCT lp.name, COALESCE(c.version, '') FROM legacy_products lp JOIN cases c ON c.id = lp.case_id " +
+			"WHERE lp.deleted_at IS NULL",
+		"INSERT INTO case_products (case_id, product_id) " +
+			"SELECT DISTINCT lp.case_id, p.id FROM legacy_products lp JOIN cases c ON c.id = lp.case_id " +
+			"JOIN products p ON p.name = lp.name AND p.version = COALESCE(c.version, '') " +
+			"WHERE lp.deleted_at IS NULL",
+		"DROP TABLE legacy_products",
+	}
+	for _, stmt := range statements {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrations is the ordered list of every schema change, append new migrations to the end
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "baseline schema",
+		// AutoMigrate only adds, so this also brings databases created before versioned migrations up to date
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&caseV1{}, &productV1{}, &accountV1{}, &classifierModelV1{}, &caseHistoryV1{})
+		},
+	},
+	{
+		Version: 2,
+		Name:    "normalize products and versions",
+		Up:      normalizeProducts,
+	},
+	{
+		Version: 3,
+		Name:    "track runs and stale cases",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&runV3{}, &caseV3{})
+		},
+	},
+	{
+		Version: 4,
+		Name:    "count runs compacted by retention",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&runV4{})
+		},
+	},
+	{
+		Version: 5,
+		Name:    "case notes and assignee",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&caseV5{})
+		},
+	},
+	{
+		Version: 6,
+		Name:    "settings",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&settingV6{})
+		},
+	},
+}
+
+// Migrations returns every known migration in order
+func Migrations() []Migration {
+	return migrations
+}
+
+// AppliedMigrations returns the migrations recorded in the database, oldest first.
+// Nothing is written, a database without the schema_migrations table has none applied.
+func (c Cache) AppliedMigrations() ([]SchemaMigration, error) {
+	applied := make([]SchemaMigration, 0)
+	if !c.DB.Migrator().HasTable(&SchemaMigration{}) {
+		return applied, nil
+	}
+	err := c.DB.Order("version asc").Find(&applied).Error
+	if err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// SchemaVersion returns the version of the newest applied migration, 0 for a new or pre-versioning database
+func (c Cache) SchemaVersion() (int, error) {
+	applied, err := c.AppliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+	if len(applied) == 0 {
+		return 0, nil
+	}
+	return applied[len(applied)-1].Version, nil
+}
+
+// PendingMigrations returns the migrations not yet applied to the database
+func (c Cache) PendingMigrations() ([]Migration, error) {
+	applied, err := c.AppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	done := map[int]bool{}
+	for _, a := range applied {
+		done[a.Version] = true
+	}
+	pending := make([]Migration, 0)
+	for _, m := range migrations {
+		if !done[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Version < pending[j].Version })
+	return pending, nil
+}
+
+// Migrate applies any pending migrations. Unless backup is false a SQLite database file holding data
+// is first copied aside, the path of the copy is returned or "" when no backup was taken.
+func (c Cache) Migrate(backup bool) ([]Migration, string, error) {
+	pending, err := c.PendingMigrations()
+	if err != nil || len(pending) == 0 {
+		return nil, "", err
+	}
+	backupPath := ""
+	if backup {
+		backupPath, err = c.backupBeforeMigrating()
+		if err != nil {
+			return nil, "", fmt.Errorf("unable to back up database before migrating: %w", err)
+		}
+	}
+	err = c.DB.AutoMigrate(&SchemaMigration{})
+	if err != nil {
+		return nil, backupPath, err
+	}
+	applied := make([]Migration, 0, len(pending))
+	for _, m := range pending {
+		log.Printf("Applying schema migration %d: %s\n", m.Version, m.Name)
+		err = c.DB.Transaction(func(tx *gorm.DB) error {
+			err := m.Up(tx)
+			if err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return applied, backupPath, fmt.Errorf("schema migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, backupPath, nil
+}
+
+// backupBeforeMigrating writes a consistent copy of a SQLite database file next to it.
+// Nothing is done for PostgreSQL, in memory databases or databases without any cases yet.
+func (c Cache) backupBeforeMigrating() (string, error) {
+	if c.isPostgres() || c.dsn == MemoryDSN {
+		return "", nil
+	}
+	if !c.DB.Migrator().HasTable("cases") {
+		return "", nil
+	}
+	version, err := c.SchemaVersion()
+	if err != nil {
+		return "", err
+	}
+	path := fmt.Sprintf("%s.v%d-%s.bak", c.dsn, version, time.Now().Format("20060102-150405"))
+	err = c.Backup(path)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Backed up %s to %s before migrating\n", c.dsn, path)
+	return path, nil
+}
+
+// Backup writes a consistent copy of a SQLite cache to a new database file at path while it stays in use.
+// PostgreSQL caches are backed up with pg_dump instead.
+func (c Cache) Backup(path string) error {
+	if c.isPostgres() {
+		return fmt.Errorf("backups of a PostgreSQL cache are made with pg_dump")
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("backup file %s already exists", path)
+	}
+	return c.DB.Exec("VACUUM INTO ?", path).Error
+}
@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMigrationsAreOrdered(t *testing.T) {
+	t.Parallel()
+	for i, m := range Migrations() {
+		assert.Equal(t, i+1, m.Version, "migration versions should be sequential")
+		assert.NotEmpty(t, m.Name)
+	}
+}
+
+func TestInitAppliesAllMigrations(t *testing.T) {
+	t.Parallel()
+	myCache := InitCache(t)
+
+	version, err := myCache.SchemaVersion()
+	require.NoError(t, err)
+	assert.Equal(t, len(Migrations()), version)
+	pending, err := myCache.PendingMigrations()
+	require.NoError(t, err)
+	assert.Len(t, pending, 0)
+
+	applied, backup, err := myCache.Migrate(true)
+	require.NoError(t, err)
+	assert.Len(t, applied, 0)
+	assert.Equal(t, "", backup)
+}
+
+func TestAppliedMigrationsDoesNotWrite(t *testing.T) {
+	t.Parallel()
+	myCache, err := Open(MemoryDSN, Options{SkipMigrations: true})
+	require.NoError(t, err)
+	t.Cleanup(func() { myCache.Close() })
+
+	applied, err := myCache.AppliedMigrations()
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+	pending, err := myCache.PendingMigrations()
+	require.NoError(t, err)
+	assert.Len(t, pending, len(Migrations()))
+	assert.False(t, myCache.DB.Migrator().HasTable(&SchemaMigration{}), "reading the status creates nothing")
+}
+
+// TestMigrationsMatchModels guards against a model gaining a field without a migration adding its column
+func TestMigrationsMatchModels(t *testing.T) {
+	t.Parallel()
+	myCache := InitCache(t)
+
+	for _, model := range []interface{}{&Case{}, &Product{}, &Account{}, &ClassifierModel{}, &CaseHistory{}, &Run{}, &Setting{}} {
+		stmt := &gorm.Statement{DB: myCache.DB}
+		require.NoError(t, stmt.Parse(model))
+		require.True(t, myCache.DB.Migrator().HasTable(model), "missing table %s", stmt.Schema.Table)
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" {
+				continue
+			}
+			assert.True(t, myCache.DB.Migrator().HasColumn(model, field.DBName),
+				"no migration adds column %s.%s", stmt.Schema.Table, field.DBName)
+		}
+	}
+	for _, column := range []string{"case_id", "product_id"} {
+		assert.True(t, myCache.DB.Migrator().HasColumn("case_products", column), "no migration adds column case_products.%s", column)
+	}
+}
+
+// legacyCase is the Case model from before versioned migrations and classification
+type legacyCase struct {
+	Id               string `gorm:"primary_key"`
+	CaseNumber       string
+	Summary          string
+	Status           string
+	LastModifiedDate time.Time
+}
+
+func (legacyCase) TableName() string { return "cases" }
+
+func TestMigrateUpgradesLegacyDatabaseWithBackup(t *testing.T) {
+	t.Parallel()
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+	legacy, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, legacy.AutoMigrate(&legacyCase{}))
+	require.NoError(t, legacy.Create(&legacyCase{Id: "case1", CaseNumber: "001", Summary: "Old case", Status: "Closed"}).Error)
+	sqlDB, err := legacy.DB()
+	require.NoError(t, err)
+	require.NoError(t, sqlDB.Close())
+
+	myCache, err := Open(dbPath, Options{SkipMigrations: true})
+	require.NoError(t, err)
+	version, err := myCache.SchemaVersion()
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+	pending, err := myCache.PendingMigrations()
+	require.NoError(t, err)
+	assert.Len(t, pending, len(Migrations()))
+
+	applied, backupPath, err := myCache.Migrate(true)
+	require.NoError(t, err)
+	assert.Len(t, applied, len(Migrations()))
+	require.NotEmpty(t, backupPath)
+	require.NoError(t, myCache.Close())
+
+	upgraded := initCacheAt(t, dbPath)
+	c, err := upgraded.GetCase("001")
+	require.NoError(t, err)
+	assert.Equal(t, "Old case", c.Summary)
+	assert.Equal(t, RelevanceUnknown, c.Relevance)
+
+	// The backup is the database as it was before migrating
+	backup, err := Open(backupPath, Options{SkipMigrations: true})
+	require.NoError(t, err)
+	defer backup.Close()
+	assert.False(t, backup.DB.Migrator().HasColumn("cases", "relevance"))
+	var count int64
+	require.NoError(t, backup.DB.Table("cases").Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestMigrateNormalizesLegacyProducts(t *testing.T) {
+	t.Parallel()
+	myCache, err := Open(MemoryDSN, Options{SkipMigrations: true})
+	require.NoError(t, err)
+	t.Cleanup(func() { myCache.Close() })
+
+	// A database at schema version 1
+	require.NoError(t, myCache.DB.AutoMigrate(&SchemaMigration{}))
+	baseline := Migrations()[0]
+	require.NoError(t, baseline.Up(myCache.DB))
+	require.NoError(t, myCache.DB.Create(&SchemaMigration{Version: baseline.Version, Name: baseline.Name}).Error)
+	require.NoError(t, myCache.DB.Create(&caseV1{Id: "case1", Version: "4.2"}).Error)
+	require.NoError(t, myCache.DB.Create(&caseV1{Id: "case2", Version: "4.2"}).Error)
+	require.NoError(t, myCache.DB.Create(&caseV1{Id: "case3"}).Error)
+	// Rows left behind by the product churn of earlier versions, including a duplicate and a soft deleted one
+	legacy := []productV1{
+		{Name: "MTC", CaseId: "case1"},
+		{Name: "MTC", CaseId: "case1"},
+		{Name: "MTV", CaseId: "case1"},
+		{Name: "MTC", CaseId: "case2"},
+		{Name: "MTC", CaseId: "case3"},
+		{Name: "Old", CaseId: "case3", Model: gorm.Model{DeletedAt: gorm.DeletedAt{Time: time.Now(), Valid: true}}},
+	}
+	require.NoError(t, myCache.DB.Create(&legacy).Error)
+
+	_, _, err = myCache.Migrate(false)
+	require.NoError(t, err)
+
+	products := make([]Product, 0)
+	require.NoError(t, myCache.DB.Order("name, version").Find(&products).Error)
+	require.Len(t, products, 3)
+	assert.Equal(t, "MTC", products[0].Name)
+	assert.Equal(t, "", products[0].Version)
+	assert.Equal(t, "4.2", products[1].Version)
+	assert.Equal(t, "MTV", products[2].Name)
+
+	c, err := myCache.GetCase("case1")
+	require.NoError(t, err)
+	assert.Len(t, c.Products, 2)
+	c, err = myCache.GetCase("case3")
+	require.NoError(t, err)
+	require.Len(t, c.Products, 1)
+	assert.Equal(t, "MTC", c.Products[0].Name)
+}
@@ -1,14 +1,26 @@
 package cache
 
 import (
-	"gorm.io/gorm"
 	"time"
 )
 
+// Values stored in Case.Relevance
+const (
+	RelevanceUnknown  = ""
+	RelevanceRelevant = "relevant"
+	RelevanceIgnored  = "ignored"
+)
+
+// ClassifiedManually is stored in Case.ClassifiedBy when a person triaged the case,
+// classification rules will not override a manual decision
+const ClassifiedManually = "manual"
+
+// Product is a product and version cases are filed against, each is stored once
+// and linked to its cases through the case_products table
 type Product struct {
-	gorm.Model
-	Name   string
-	CaseId string
+	ID      uint   `gorm:"primaryKey"`
+	Name    string `gorm:"uniqueIndex:idx_products_name_version"`
+	Version string `gorm:"uniqueIndex:idx_products_name_version"`
 }
 
 type Case struct {
@@ -24,13 +36,28 @@ type Case struct {
 	LastPublicUpdateBy   string
 	LastPublicUpdateDate time.Time
 	Owner                string
-	Products             []Product
+	Products             []Product `gorm:"many2many:case_products;constraint:OnDelete:CASCADE"`
 	Severity             string
 	Summary              string
 	Status               string
 	Type                 string
 	Uri                  string
 	Version              string
+	// Relevance, Area and ClassifiedBy are set by classification rules or manual triage
+	Relevance    string
+	Area         string
+	ClassifiedBy string
+	// Score is the probability the Case is relevant as predicted by the trained classifier, nil when unscored
+	Score *float64
+	// LastSeenRun and LastSeenAt record the latest Run whose search returned the Case and MissedRuns
+	// counts the runs since. A Case missed by too many runs is Stale, it no longer matches the search.
+	LastSeenRun uint
+	LastSeenAt  time.Time
+	MissedRuns  int
+	Stale       bool `gorm:"index"`
+	// Notes and Assignee are kept by the team, e.g. in the spreadsheet, see SetAnnotations
+	Notes    string
+	Assignee string
 }
 
 type Account struct {
@@ -45,3 +72,39 @@ type Account struct {
 	HasSRM         bool
 	HasTAM         bool
 }
+
+// ClassifierModel stores a serialized trained classifier
+type ClassifierModel struct {
+	Name      string `gorm:"primaryKey"`
+	Data      []byte
+	TrainedAt time.Time
+}
+
+// CaseHistory records a change to one of the tracked fields of a Case, as observed by StoreCase
+type CaseHistory struct {
+	ID        uint   `gorm:"primaryKey"`
+	CaseId    string `gorm:"index"`
+	Field     string
+	OldValue  string
+	NewValue  string
+	ChangedAt time.Time
+}
+
+// Setting is a value case_watcher remembers between runs, e.g. the tab the email links to, see Cache.SetSetting
+type Setting struct {
+	Name  string `gorm:"primaryKey"`
+	Value string
+	SetAt time.Time
+}
+
+// Run records a search of the case API, see Cache.RecordRun
+type Run struct {
+	ID        uint `gorm:"primaryKey"`
+	StartedAt time.Time
+	Query     string
+	// CasesSeen is the number of cases the search returned, StaleCases the number it marked Stale
+	CasesSeen  int
+	StaleCases int
+	// CompactedRuns is the number of earlier runs of the same day merged into this one by Prune
+	CompactedRuns int
+}
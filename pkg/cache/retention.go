@@ -0,0 +1,209 @@
+package cache
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"log"
+	"time"
+)
+
+// RetentionPolicy limits how long Prune keeps data, a zero value keeps that data forever
+type RetentionPolicy struct {
+	// ClosedCaseMonths removes closed cases not modified for this many months
+	ClosedCaseMonths int
+	// CompactAfterDays merges the case history and runs older than this many days into one row per day
+	CompactAfterDays int
+}
+
+// PruneResult describes what Prune removed, or would remove on a dry run
+type PruneResult struct {
+	// ClosedCases are the cases removed along with their history and product links
+	ClosedCases []Case
+	// HistoryRows and Runs count the rows removed by merging them into daily summaries
+	HistoryRows int
+	Runs        int
+	Vacuumed    bool
+}
+
+// errDryRun rolls back the transaction of a dry run
+var errDryRun = errors.New("dry run")
+
+// Prune applies a RetentionPolicy relative to now and then reclaims the space freed with VACUUM.
+// With dryRun nothing is changed, the result describes what would have been removed.
+func (c Cache) Prune(policy RetentionPolicy, now time.Time, dryRun bool) (PruneResult, error) {
+	result := PruneResult{}
+	err := c.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		txCache := c.withDB(tx)
+		if policy.ClosedCaseMonths > 0 {
+			result.ClosedCases, err = txCache.deleteClosedCases(now.AddDate(0, -policy.ClosedCaseMonths, 0))
+			if err != nil {
+				return err
+			}
+		}
+		if policy.CompactAfterDays > 0 {
+			before := now.AddDate(0, 0, -policy.CompactAfterDays)
+			result.HistoryRows, err = txCache.compactHistory(before)
+			if err != nil {
+				return err
+			}
+			result.Runs, err = txCache.compactRuns(before)
+			if err != nil {
+				return err
+			}
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && err != errDryRun {
+		return PruneResult{}, err
+	}
+	if dryRun {
+		return result, nil
+	}
+	// VACUUM cannot run inside a transaction
+	err = c.DB.Exec("VACUUM").Error
+	if err != nil {
+		return result, err
+	}
+	result.Vacuumed = true
+	log.Printf("Pruned %d closed cases, %d history rows and %d runs\n", len(result.ClosedCases), result.HistoryRows, result.Runs)
+	return result, nil
+}
+
+// deleteClosedCases removes closed cases last modified before a time, as DeleteCase would
+func (c Cache) deleteClosedCases(before time.Time) ([]Case, error) {
+	cases := make([]Case, 0)
+	err := c.DB.Where("status = 'Closed' AND last_modified_date < ?", before).Order("last_modified_date asc").Find(&cases).Error
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(cases))
+	for _, myCase := range cases {
+		ids = append(ids, myCase.Id)
+	}
+	for _, chunk := range chunks(ids) {
+		err = c.DB.Where("case_id IN ?", chunk).Delete(&caseProduct{}).Error
+		if err != nil {
+			return nil, err
+		}
+		err = c.DB.Where("case_id IN ?", chunk).Delete(&CaseHistory{}).Error
+		if err != nil {
+			return nil, err
+		}
+		err = c.DB.Where("id IN ?", chunk).Delete(&Case{}).Error
+		if err != nil {
+			return nil, err
+		}
+		if c.textIndex {
+			err = c.DB.Exec("DELETE FROM "+textIndexTable+" WHERE id IN ?", chunk).Error
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return cases, nil
+}
+
+// day is the UTC date rows are grouped by when compacted
+func day(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// compactHistory merges the changes of a field made on the same day before a time into a single change
+// from the first old value to the last new value, dropping it altogether when the two are equal.
+// The number of rows removed is returned.
+func (c Cache) compactHistory(before time.Time) (int, error) {
+	history := make([]CaseHistory, 0)
+	err := c.DB.Where("changed_at < ?", before).Order("case_id, field, changed_at, id").Find(&history).Error
+	if err != nil {
+		return 0, err
+	}
+	remove := make([]uint, 0)
+	for start := 0; start < len(history); {
+		first := history[start]
+		end := start + 1
+		for end < len(history) && history[end].CaseId == first.CaseId && history[end].Field == first.Field &&
+			day(history[end].ChangedAt) == day(first.ChangedAt) {
+			end++
+		}
+		if end-start > 1 {
+			last := history[end-1]
+			for _, h := range history[start+1 : end] {
+				remove = append(remove, h.ID)
+			}
+			if first.OldValue == last.NewValue {
+				remove = append(remove, first.ID)
+			} else {
+				err = c.DB.Model(&first).Updates(map[string]interface{}{"new_value": last.NewValue, "changed_at": last.ChangedAt}).Error
+				if err != nil {
+					return 0, err
+				}
+			}
+		}
+		start = end
+	}
+	err = c.deleteByID(&CaseHistory{}, remove)
+	return len(remove), err
+}
+
+// compactRuns keeps only the last run of each day before a time, adding the cases the removed runs marked stale
+// to its StaleCases and counting them in its CompactedRuns. Its CasesSeen is left as it is, the runs of a day see
+// mostly the same cases.
+// Cases last seen by a removed run are pointed at the run kept for its day. The number of runs removed is returned.
+func (c Cache) compactRuns(before time.Time) (int, error) {
+	runs := make([]Run, 0)
+	err := c.DB.Where("started_at < ?", before).Order("started_at, id").Find(&runs).Error
+	if err != nil {
+		return 0, err
+	}
+	remove := make([]uint, 0)
+	for start := 0; start < len(runs); {
+		end := start + 1
+		for end < len(runs) && day(runs[end].StartedAt) == day(runs[start].StartedAt) {
+			end++
+		}
+		if end-start > 1 {
+			kept := runs[end-1]
+			merged := make([]uint, 0, end-start-1)
+			for _, r := range runs[start : end-1] {
+				merged = append(merged, r.ID)
+				kept.StaleCases += r.StaleCases
+				kept.CompactedRuns += r.CompactedRuns + 1
+			}
+			err = c.DB.Model(&kept).Updates(map[string]interface{}{
+				"stale_cases":    kept.StaleCases,
+				"compacted_runs": kept.CompactedRuns,
+			}).Error
+			if err != nil {
+				return 0, err
+			}
+			err = c.DB.Model(&Case{}).Where("last_seen_run IN ?", merged).Update("last_seen_run", kept.ID).Error
+			if err != nil {
+				return 0, err
+			}
+			remove = append(remove, merged...)
+		}
+		start = end
+	}
+	err = c.deleteByID(&Run{}, remove)
+	return len(remove), err
+}
+
+// deleteByID deletes the rows of model with the given primary keys, in batches
+func (c Cache) deleteByID(model interface{}, ids []uint) error {
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > storeBatchSize {
+			n = storeBatchSize
+		}
+		err := c.DB.Where("id IN ?", ids[:n]).Delete(model).Error
+		if err != nil {
+			return err
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"github.com/jwmatthews/case_watcher/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func populateForPrune(t *testing.T, myCache *Cache, now time.Time) {
+	old := now.AddDate(-2, 0, 0)
+	storeCases(t, myCache, []api.Case{
+		{Id: "old-closed", CaseNumber: "0001", Status: "Closed", LastModifiedDate: old, Products: []string{"A"}},
+		{Id: "old-open", CaseNumber: "0002", Status: "Waiting on Red Hat", LastModifiedDate: old, Products: []string{"A"}},
+		{Id: "new-closed", CaseNumber: "0003", Status: "Closed", LastModifiedDate: now.AddDate(0, -1, 0)},
+	})
+
+	// Replace the history recorded by StoreCases with four changes on one old day, two of them undone the same day
+	require.NoError(t, myCache.DB.Where("1 = 1").Delete(&CaseHistory{}).Error)
+	oldDay := time.Date(2020, 6, 1, 9, 0, 0, 0, time.UTC)
+	history := []CaseHistory{
+		{CaseId: "old-open", Field: "Status", OldValue: "New", NewValue: "Waiting on Customer", ChangedAt: oldDay},
+		{CaseId: "old-open", Field: "Status", OldValue: "Waiting on Customer", NewValue: "Waiting on Red Hat", ChangedAt: oldDay.Add(time.Hour)},
+		{CaseId: "old-open", Field: "Owner", OldValue: "alice", NewValue: "bob", ChangedAt: oldDay},
+		{CaseId: "old-open", Field: "Owner", OldValue: "bob", NewValue: "alice", ChangedAt: oldDay.Add(time.Hour)},
+		{CaseId: "old-open", Field: "Status", OldValue: "Waiting on Red Hat", NewValue: "Closed", ChangedAt: now},
+	}
+	require.NoError(t, myCache.DB.Create(&history).Error)
+	runs := []Run{
+		{StartedAt: oldDay, CasesSeen: 3, StaleCases: 1},
+		{StartedAt: oldDay.Add(time.Hour), CasesSeen: 2},
+		{StartedAt: oldDay.Add(2 * time.Hour), CasesSeen: 2, StaleCases: 1},
+		{StartedAt: now, CasesSeen: 2},
+	}
+	require.NoError(t, myCache.DB.Create(&runs).Error)
+	require.NoError(t, myCache.DB.Model(&Case{}).Where("id = ?", "old-open").Update("last_seen_run", runs[0].ID).Error)
+}
+
+func TestCache_Prune(t *testing.T) {
+	t.Parallel()
+	myCache := InitCache(t)
+	now := time.Now()
+	populateForPrune(t, myCache, now)
+
+	result, err := myCache.Prune(RetentionPolicy{ClosedCaseMonths: 12, CompactAfterDays: 90}, now, false)
+	require.NoError(t, err)
+	require.Len(t, result.ClosedCases, 1)
+	assert.Equal(t, "old-closed", result.ClosedCases[0].Id)
+	assert.Equal(t, 3, result.HistoryRows)
+	assert.Equal(t, 2, result.Runs)
+	assert.True(t, result.Vacuumed)
+
+	_, err = myCache.GetCase("old-closed")
+	assert.Error(t, err)
+	for _, id := range []string{"old-open", "new-closed"} {
+		_, err = myCache.GetCase(id)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int64(1), countRows(t, myCache, "case_products"))
+
+	history, err := myCache.GetCaseHistory("old-open")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "New", history[0].OldValue)
+	assert.Equal(t, "Waiting on Red Hat", history[0].NewValue)
+	assert.Equal(t, "Closed", history[1].NewValue)
+
+	runs, err := myCache.GetRuns()
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	assert.Equal(t, 2, runs[1].CompactedRuns)
+	assert.Equal(t, 2, runs[1].StaleCases)
+	c, err := myCache.GetCase("old-open")
+	require.NoError(t, err)
+	assert.Equal(t, runs[1].ID, c.LastSeenRun)
+}
+
+func TestCache_PruneDryRun(t *testing.T) {
+	t.Parallel()
+	myCache := InitCache(t)
+	now := time.Now()
+	populateForPrune(t, myCache, now)
+
+	result, err := myCache.Prune(RetentionPolicy{ClosedCaseMonths: 12, CompactAfterDays: 90}, now, true)
+	require.NoError(t, err)
+	assert.Len(t, result.ClosedCases, 1)
+	assert.Equal(t, 3, result.HistoryRows)
+	assert.Equal(t, 2, result.Runs)
+	assert.False(t, result.Vacuumed)
+
+	assert.Equal(t, int64(3), countRows(t, myCache, "cases"))
+	assert.Equal(t, int64(5), countRows(t, myCache, "case_histories"))
+	assert.Equal(t, int64(4), countRows(t, myCache, "runs"))
+
+	// A zero policy keeps everything
+	result, err = myCache.Prune(RetentionPolicy{}, now, false)
+	require.NoError(t, err)
+	assert.Empty(t, result.ClosedCases)
+	assert.Equal(t, int64(3), countRows(t, myCache, "cases"))
+}
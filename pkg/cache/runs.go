@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// RecordRun notes that a search returned the cases with the seen ids. Every other Case has its MissedRuns
+// incremented and is marked Stale once staleAfter runs in a row have missed it, staleAfter of 0 or less never
+// marks a Case Stale. A stale Case seen again is no longer Stale. The cases newly marked Stale are returned.
+func (c Cache) RecordRun(query string, seen []string, staleAfter int) (Run, []Case, error) {
+	run := Run{StartedAt: time.Now(), Query: query, CasesSeen: len(seen)}
+	stale := make([]Case, 0)
+	err := c.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&run).Error
+		if err != nil {
+			return err
+		}
+		for _, chunk := range chunks(seen) {
+			err = tx.Model(&Case{}).Where("id IN ?", chunk).Updates(map[string]interface{}{
+				"last_seen_run": run.ID,
+				"last_seen_at":  run.StartedAt,
+				"missed_runs":   0,
+				"stale":         false,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		err = tx.Model(&Case{}).Where("last_seen_run IS NULL OR last_seen_run <> ?", run.ID).
+			Update("missed_runs", gorm.Expr("missed_runs + 1")).Error
+		if err != nil {
+			return err
+		}
+		if staleAfter <= 0 {
+			return nil
+		}
+		err = tx.Where("stale = ? AND missed_runs >= ?", false, staleAfter).Find(&stale).Error
+		if err != nil || len(stale) == 0 {
+			return err
+		}
+		ids := make([]string, 0, len(stale))
+		for i := range stale {
+			stale[i].Stale = true
+			ids = append(ids, stale[i].Id)
+		}
+		for _, chunk := range chunks(ids) {
+			err = tx.Model(&Case{}).Where("id IN ?", chunk).Update("stale", true).Error
+			if err != nil {
+				return err
+			}
+		}
+		run.StaleCases = len(stale)
+		return tx.Model(&run).Update("stale_cases", run.StaleCases).Error
+	})
+	if err != nil {
+		return Run{}, nil, err
+	}
+	return run, stale, nil
+}
+
+// GetRuns returns the recorded runs, most recent first
+func (c Cache) GetRuns() ([]Run, error) {
+	runs := make([]Run, 0)
+	err := c.DB.Order("started_at desc, id desc").Find(&runs).Error
+	if err != nil {
+		return []Run{}, err
+	}
+	return runs, nil
+}
+
+// SetIncludeStale includes Stale cases in the results of GetOpenCases, GetClosedCases, GetCasesActiveFrom,
+// GetCasesCreatedFrom and ListCases, which leave them out by default
+func (c *Cache) SetIncludeStale(include bool) {
+	c.IncludeStale = include
+}
+
+// current returns the query excluding Stale cases unless IncludeStale is set
+func (c Cache) current() *gorm.DB {
+	if c.IncludeStale {
+		return c.DB
+	}
+	return c.DB.Where("stale = ?", false)
+}
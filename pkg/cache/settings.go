@@ -0,0 +1,22 @@
+package cache
+
+import (
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// SetSetting remembers a value by name, replacing any previous value
+func (c Cache) SetSetting(name, value string) error {
+	return c.DB.Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&Setting{Name: name, Value: value, SetAt: time.Now()}).Error
+}
+
+// GetSetting returns the value remembered by SetSetting, empty when it was never set
+func (c Cache) GetSetting(name string) (string, error) {
+	settings := make([]Setting, 0, 1)
+	err := c.DB.Where("name = ?", name).Limit(1).Find(&settings).Error
+	if err != nil || len(settings) == 0 {
+		return "", err
+	}
+	return settings[0].Value, nil
+}
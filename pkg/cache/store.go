@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"github.com/jwmatthews/case_watcher/pkg/api"
+	"time"
+)
+
+// Store is everything the rest of case_watcher needs from the cache.
+// Cache implements it with gorm on top of SQLite or PostgreSQL, chosen by the DSN given to Init.
+type Store interface {
+	StoreCases(cases []api.Case) (StoreSummary, error)
+	StoreCase(myCase Case) error
+	StoreAccounts(accounts []api.Account) error
+	DeleteCase(id string) error
+	SetClassifier(classifier Classifier)
+	SetIncludeStale(include bool)
+	SetTriage(id, relevance, area string) error
+	SetAnnotations(id, notes, assignee string) error
+	SetScore(id string, score float64) error
+	SaveClassifierModel(name string, data []byte) error
+	LoadClassifierModel(name string) ([]byte, error)
+	SetSetting(name, value string) error
+	GetSetting(name string) (string, error)
+
+	GetCase(idOrNumber string) (Case, error)
+	RecordRun(query string, seen []string, staleAfter int) (Run, []Case, error)
+	GetRuns() ([]Run, error)
+	GetCaseHistory(id string) ([]CaseHistory, error)
+	GetFieldHistory(field string) ([]CaseHistory, error)
+	GetAccount(accountNumber string) (Account, error)
+	GetMissingAccountIDs() []string
+	GetAllCases() ([]Case, error)
+	GetOpenCases() ([]Case, error)
+	GetClosedCases() ([]Case, error)
+	GetCasesActiveFrom(since time.Time) ([]Case, error)
+	GetCasesCreatedFrom(since time.Time) ([]Case, error)
+	GetUniqueCaseStatusValues() ([]string, error)
+	ListCases(f CaseFilter) ([]Case, error)
+	SearchText(query string, limit int) ([]TextMatch, error)
+
+	Close() error
+}
+
+var _ Store = &Cache{}
@@ -0,0 +1,39 @@
+package cache_test
+
+import (
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/jwmatthews/case_watcher/pkg/cache/storetest"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+// postgresDSNEnv names the environment variable giving a PostgreSQL database the conformance suite may wipe
+const postgresDSNEnv = "CASE_WATCHER_TEST_POSTGRES_DSN"
+
+func TestSQLiteStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) cache.Store {
+		c, err := cache.Init(cache.MemoryDSN)
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		return &c
+	})
+}
+
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("set %s to run the conformance suite against PostgreSQL", postgresDSNEnv)
+	}
+	storetest.Run(t, func(t *testing.T) cache.Store {
+		c, err := cache.Init(dsn)
+		require.NoError(t, err)
+		require.NoError(t, c.DB.Exec("DROP SCHEMA public CASCADE").Error)
+		require.NoError(t, c.DB.Exec("CREATE SCHEMA public").Error)
+		require.NoError(t, c.Close())
+		c, err = cache.Init(dsn)
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		return &c
+	})
+}
@@ -0,0 +1,359 @@
+// Package storetest is a conformance test suite every cache.Store implementation is expected to pass
+package storetest
+
+import (
+	"github.com/jwmatthews/case_watcher/pkg/api"
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Run executes the suite, open must return a new empty Store for every call
+func Run(t *testing.T, open func(t *testing.T) cache.Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s cache.Store)
+	}{
+		{"StoreCasesAndGetCase", testStoreCasesAndGetCase},
+		{"StoreCasesIsIdempotent", testStoreCasesIsIdempotent},
+		{"StoreCasesReplacesProducts", testStoreCasesReplacesProducts},
+		{"StoreCasesSummary", testStoreCasesSummary},
+		{"DeleteCase", testDeleteCase},
+		{"OpenClosedAndActiveCases", testOpenClosedAndActiveCases},
+		{"UniqueCaseStatusValues", testUniqueCaseStatusValues},
+		{"MissingAccountIDs", testMissingAccountIDs},
+		{"ClassifierAndTriage", testClassifierAndTriage},
+		{"ClassifierModels", testClassifierModels},
+		{"Settings", testSettings},
+		{"CaseHistory", testCaseHistory},
+		{"RecordRunMarksStaleCases", testRecordRunMarksStaleCases},
+		{"ListCases", testListCases},
+		{"SearchText", testSearchText},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, open(t))
+		})
+	}
+}
+
+func ids(cases []cache.Case) []string {
+	result := make([]string, 0, len(cases))
+	for _, c := range cases {
+		result = append(result, c.Id)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func storeCases(t *testing.T, s cache.Store, cases []api.Case) cache.StoreSummary {
+	summary, err := s.StoreCases(cases)
+	require.NoError(t, err)
+	return summary
+}
+
+func testStoreCasesAndGetCase(t *testing.T, s cache.Store) {
+	created := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	_, err := s.StoreCases([]api.Case{{Id: "case1", CaseNumber: "0001", Summary: "My summary", Severity: "2 (High)",
+		Status: "Waiting on Red Hat", CreatedDate: created, CustomerEscalation: true, Products: []string{"A", "B"}}})
+	require.NoError(t, err)
+
+	for _, key := range []string{"case1", "0001"} {
+		c, err := s.GetCase(key)
+		require.NoError(t, err)
+		assert.Equal(t, "case1", c.Id)
+		assert.Equal(t, "My summary", c.Summary)
+		assert.Equal(t, "2 (High)", c.Severity)
+		assert.True(t, c.CustomerEscalation)
+		assert.True(t, created.Equal(c.CreatedDate))
+		assert.Len(t, c.Products, 2)
+	}
+	_, err = s.GetCase("missing")
+	assert.Error(t, err)
+}
+
+func testStoreCasesIsIdempotent(t *testing.T, s cache.Store) {
+	myCases := []api.Case{{Id: "case1", Summary: "My summary", Products: []string{"A", "B", "C"}}}
+	for i := 0; i < 3; i++ {
+		storeCases(t, s, myCases)
+	}
+	all, err := s.GetAllCases()
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	assert.Len(t, all[0].Products, 3)
+}
+
+func testStoreCasesSummary(t *testing.T, s cache.Store) {
+	myCases := []api.Case{
+		{Id: "case1", Summary: "first", Owner: "alice", CustomerEscalation: true, Products: []string{"A"},
+			LastModifiedDate: time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{Id: "case2", Summary: "second", Products: []string{"B"}},
+	}
+	summary := storeCases(t, s, myCases)
+	assert.Equal(t, []string{"case1", "case2"}, summary.Inserted)
+	assert.Empty(t, summary.Updated)
+	assert.Empty(t, summary.Unchanged)
+
+	summary = storeCases(t, s, myCases)
+	assert.Empty(t, summary.Inserted)
+	assert.Empty(t, summary.Updated)
+	assert.Equal(t, []string{"case1", "case2"}, summary.Unchanged)
+
+	// Values cleared upstream are cleared in the cache, and a case listed twice is stored once
+	myCases[0].CustomerEscalation = false
+	myCases[0].Owner = ""
+	summary = storeCases(t, s, append(myCases, api.Case{Id: "case3"}, api.Case{Id: "case3", Summary: "third"}))
+	assert.Equal(t, []string{"case3"}, summary.Inserted)
+	assert.Equal(t, []string{"case1"}, summary.Updated)
+	assert.Equal(t, []string{"case2"}, summary.Unchanged)
+	c, err := s.GetCase("case1")
+	require.NoError(t, err)
+	assert.False(t, c.CustomerEscalation)
+	assert.Equal(t, "", c.Owner)
+	c, err = s.GetCase("case3")
+	require.NoError(t, err)
+	assert.Equal(t, "third", c.Summary)
+}
+
+func testStoreCasesReplacesProducts(t *testing.T, s cache.Store) {
+	storeCases(t, s, []api.Case{{Id: "case1", Products: []string{"A", "B"}, Version: "1"}})
+	storeCases(t, s, []api.Case{{Id: "case1", Products: []string{"B", "C"}, Version: "1"}})
+	c, err := s.GetCase("case1")
+	require.NoError(t, err)
+	names := make([]string, 0, len(c.Products))
+	for _, p := range c.Products {
+		names = append(names, p.Name)
+		assert.Equal(t, "1", p.Version)
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"B", "C"}, names)
+}
+
+func testDeleteCase(t *testing.T, s cache.Store) {
+	storeCases(t, s, []api.Case{
+		{Id: "case1", Summary: "kernel panic", Products: []string{"A"}},
+		{Id: "case2", Summary: "kernel upgrade", Products: []string{"A"}},
+	})
+	require.NoError(t, s.DeleteCase("case1"))
+	_, err := s.GetCase("case1")
+	assert.Error(t, err)
+	assert.Error(t, s.DeleteCase("case1"))
+
+	c, err := s.GetCase("case2")
+	require.NoError(t, err)
+	assert.Len(t, c.Products, 1)
+	matches, err := s.SearchText("kernel", 10)
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	assert.Equal(t, "case2", matches[0].Id)
+}
+
+func testOpenClosedAndActiveCases(t *testing.T, s cache.Store) {
+	now := time.Now()
+	require.NoError(t, s.StoreCase(cache.Case{Id: "case1", Status: "Waiting on Customer", LastModifiedDate: now.AddDate(0, 0, -1), CreatedDate: now.AddDate(0, 0, -2)}))
+	require.NoError(t, s.StoreCase(cache.Case{Id: "case2", Status: "Unknown", LastModifiedDate: now.AddDate(0, 0, -25), CreatedDate: now.AddDate(0, -2, 0)}))
+	require.NoError(t, s.StoreCase(cache.Case{Id: "case3", Status: "Closed", LastModifiedDate: now.AddDate(0, 0, -3), CreatedDate: now.AddDate(0, -1, 0)}))
+
+	open, err := s.GetOpenCases()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"case1", "case2"}, ids(open))
+	closed, err := s.GetClosedCases()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"case3"}, ids(closed))
+	active, err := s.GetCasesActiveFrom(now.AddDate(0, 0, -7))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"case1", "case3"}, ids(active))
+	created, err := s.GetCasesCreatedFrom(now.AddDate(0, 0, -7))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"case1"}, ids(created))
+}
+
+func testUniqueCaseStatusValues(t *testing.T, s cache.Store) {
+	for i, status := range []string{"Foo", "Closed", "Foo", "Bar"} {
+		require.NoError(t, s.StoreCase(cache.Case{Id: string(rune('a' + i)), Status: status}))
+	}
+	values, err := s.GetUniqueCaseStatusValues()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Bar", "Closed", "Foo"}, values)
+}
+
+func testMissingAccountIDs(t *testing.T, s cache.Store) {
+	require.NoError(t, s.StoreCase(cache.Case{Id: "case1", AccountNumber: "1"}))
+	require.NoError(t, s.StoreCase(cache.Case{Id: "case2", AccountNumber: ""}))
+	require.NoError(t, s.StoreCase(cache.Case{Id: "case3", AccountNumber: "1"}))
+	require.NoError(t, s.StoreCase(cache.Case{Id: "case4", AccountNumber: "2"}))
+	assert.Equal(t, []string{"1", "2"}, s.GetMissingAccountIDs())
+	require.NoError(t, s.StoreAccounts([]api.Account{{AccountNumber: "2", Name: "Example Corp"}}))
+	assert.Equal(t, []string{"1"}, s.GetMissingAccountIDs(), "accounts with details cached are not missing")
+}
+
+// summaryClassifier marks cases as relevant when their summary is "ours"
+type summaryClassifier struct{}
+
+func (summaryClassifier) Classify(c *cache.Case) {
+	if c.Summary == "ours" {
+		c.Relevance = cache.RelevanceRelevant
+		c.ClassifiedBy = "summary"
+	}
+}
+
+func testClassifierAndTriage(t *testing.T, s cache.Store) {
+	s.SetClassifier(summaryClassifier{})
+	storeCases(t, s, []api.Case{{Id: "case1", Summary: "ours"}, {Id: "case2", Summary: "ours"}})
+	require.NoError(t, s.SetTriage("case2", cache.RelevanceIgnored, "elsewhere"))
+	storeCases(t, s, []api.Case{{Id: "case1", Summary: "ours"}, {Id: "case2", Summary: "ours"}})
+	require.NoError(t, s.SetScore("case1", 0.75))
+
+	c, err := s.GetCase("case1")
+	require.NoError(t, err)
+	assert.Equal(t, cache.RelevanceRelevant, c.Relevance)
+	assert.Equal(t, "summary", c.ClassifiedBy)
+	require.NotNil(t, c.Score)
+	assert.InDelta(t, 0.75, *c.Score, 1e-9)
+
+	c, err = s.GetCase("case2")
+	require.NoError(t, err)
+	assert.Equal(t, cache.RelevanceIgnored, c.Relevance)
+	assert.Equal(t, "elsewhere", c.Area)
+	assert.Equal(t, cache.ClassifiedManually, c.ClassifiedBy)
+
+	assert.Error(t, s.SetTriage("missing", cache.RelevanceIgnored, ""))
+}
+
+func testClassifierModels(t *testing.T, s cache.Store) {
+	_, err := s.LoadClassifierModel("model")
+	assert.Error(t, err)
+	require.NoError(t, s.SaveClassifierModel("model", []byte("v1")))
+	require.NoError(t, s.SaveClassifierModel("model", []byte("v2")))
+	data, err := s.LoadClassifierModel("model")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v2"), data)
+}
+
+func testSettings(t *testing.T, s cache.Store) {
+	value, err := s.GetSetting("name")
+	require.NoError(t, err)
+	assert.Equal(t, "", value)
+	require.NoError(t, s.SetSetting("name", "first"))
+	require.NoError(t, s.SetSetting("name", "second"))
+	require.NoError(t, s.SetSetting("other", "value"))
+	value, err = s.GetSetting("name")
+	require.NoError(t, err)
+	assert.Equal(t, "second", value)
+}
+
+func testCaseHistory(t *testing.T, s cache.Store) {
+	day := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, s.StoreCase(cache.Case{Id: "case1", Status: "Waiting on Red Hat", LastModifiedDate: day}))
+	require.NoError(t, s.StoreCase(cache.Case{Id: "case1", Status: "Closed", LastModifiedDate: day.AddDate(0, 0, 1)}))
+	history, err := s.GetCaseHistory("case1")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "Closed", history[1].NewValue)
+
+	require.NoError(t, s.StoreCase(cache.Case{Id: "case2", Status: "Closed", Severity: "3 (Normal)", LastModifiedDate: day}))
+	history, err = s.GetFieldHistory("Status")
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Equal(t, []string{"case1", "case2", "case1"}, []string{history[0].CaseId, history[1].CaseId, history[2].CaseId},
+		"oldest first")
+}
+
+func testRecordRunMarksStaleCases(t *testing.T, s cache.Store) {
+	storeCases(t, s, []api.Case{
+		{Id: "case1", CaseNumber: "0001", Status: "Waiting on Red Hat"},
+		{Id: "case2", CaseNumber: "0002", Status: "Waiting on Red Hat"},
+	})
+	for i := 0; i < 2; i++ {
+		run, stale, err := s.RecordRun("query", []string{"case1"}, 2)
+		require.NoError(t, err)
+		assert.Equal(t, 1, run.CasesSeen)
+		if i == 0 {
+			assert.Empty(t, stale)
+		} else {
+			require.Len(t, stale, 1)
+			assert.Equal(t, "0002", stale[0].CaseNumber)
+			assert.Equal(t, 1, run.StaleCases)
+		}
+	}
+	runs, err := s.GetRuns()
+	require.NoError(t, err)
+	assert.Len(t, runs, 2)
+
+	// Storing the case again does not reset what the runs recorded
+	storeCases(t, s, []api.Case{{Id: "case2", CaseNumber: "0002", Status: "Closed"}})
+	c, err := s.GetCase("case2")
+	require.NoError(t, err)
+	assert.True(t, c.Stale)
+	assert.Equal(t, 2, c.MissedRuns)
+	c, err = s.GetCase("case1")
+	require.NoError(t, err)
+	assert.False(t, c.Stale)
+	assert.Equal(t, runs[0].ID, c.LastSeenRun)
+	assert.False(t, c.LastSeenAt.IsZero())
+
+	open, err := s.GetOpenCases()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"case1"}, ids(open))
+	closed, err := s.GetClosedCases()
+	require.NoError(t, err)
+	assert.Empty(t, closed)
+	listed, err := s.ListCases(cache.CaseFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"case1"}, ids(listed))
+	s.SetIncludeStale(true)
+	closed, err = s.GetClosedCases()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"case2"}, ids(closed))
+	s.SetIncludeStale(false)
+
+	// A stale case returned by the search again is current again
+	_, stale, err := s.RecordRun("query", []string{"case1", "case2"}, 2)
+	require.NoError(t, err)
+	assert.Empty(t, stale)
+	c, err = s.GetCase("case2")
+	require.NoError(t, err)
+	assert.False(t, c.Stale)
+	assert.Equal(t, 0, c.MissedRuns)
+}
+
+func testListCases(t *testing.T, s cache.Store) {
+	storeCases(t, s, []api.Case{
+		{Id: "case1", Status: "Closed", Owner: "alice", Products: []string{"A"}},
+		{Id: "case2", Status: "Waiting on Customer", Owner: "bob", Products: []string{"B"}, CustomerEscalation: true},
+		{Id: "case3", Status: "Waiting on Customer", Owner: "alice", Products: []string{"A", "B"}},
+	})
+	cases, err := s.ListCases(cache.CaseFilter{Statuses: []string{"Waiting on Customer"}, Product: "B", SortBy: "-id"})
+	require.NoError(t, err)
+	require.Len(t, cases, 2)
+	assert.Equal(t, "case3", cases[0].Id)
+	assert.Equal(t, "case2", cases[1].Id)
+
+	escalated := false
+	cases, err = s.ListCases(cache.CaseFilter{Owner: "alice", Escalated: &escalated, Triage: cache.TriageUntriaged})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"case1", "case3"}, ids(cases))
+}
+
+func testSearchText(t *testing.T, s cache.Store) {
+	require.NoError(t, s.StoreCase(cache.Case{Id: "case1", Summary: "Velero restore fails for volumes"}))
+	require.NoError(t, s.StoreCase(cache.Case{Id: "case2", Summary: "Router returns 503"}))
+	require.NoError(t, s.StoreCase(cache.Case{Id: "case2", Summary: "Velero backup is stuck"}))
+
+	matches, err := s.SearchText("velero restore", 10)
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	assert.Equal(t, "case1", matches[0].Id)
+	assert.Contains(t, matches[0].Snippet, cache.SnippetStart)
+
+	matches, err = s.SearchText("velero", 10)
+	require.NoError(t, err)
+	assert.Len(t, matches, 2)
+	matches, err = s.SearchText("router", 10)
+	require.NoError(t, err)
+	assert.Len(t, matches, 0)
+}
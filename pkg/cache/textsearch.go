@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"log"
+	"strings"
+)
+
+// With SQLite the full text index is an FTS5 virtual table kept in sync by StoreCase.
+// FTS5 is only compiled into go-sqlite3 with the 'sqlite_fts5' build tag, without it
+// SearchText falls back to a slower LIKE match over the summary.
+// With PostgreSQL a GIN index over the summary's tsvector is used instead.
+const (
+	textIndexTable = "case_text"
+	// SnippetStart and SnippetEnd surround the matched terms in TextMatch.Snippet
+	SnippetStart = "**"
+	SnippetEnd   = "**"
+)
+
+// TextMatch is a single result from SearchText, lower Rank is a better match
+type TextMatch struct {
+	Id         string
+	CaseNumber string
+	Summary    string
+	Rank       float64
+	Snippet    string
+}
+
+// initTextIndex creates the full text index and rebuilds it when it is out of step with the cases table,
+// e.g. on the first run against an existing database
+func (c *Cache) initTextIndex() error {
+	if c.isPostgres() {
+		return c.DB.Exec("CREATE INDEX IF NOT EXISTS idx_cases_summary_text ON cases USING GIN (to_tsvector('english', summary))").Error
+	}
+	// Silence the logger while probing, the error is expected when FTS5 is not compiled in
+	quiet := c.DB.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+	err := quiet.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS " + textIndexTable +
+		" USING fts5(id UNINDEXED, case_number UNINDEXED, summary, description, comments, tokenize = 'porter unicode61')").Error
+	if err != nil {
+		log.Printf("Full text index unavailable, falling back to LIKE searches: %s\n", err)
+		c.textIndex = false
+		return nil
+	}
+	c.textIndex = true
+
+	var indexed, cases int64
+	err = c.DB.Table(textIndexTable).Count(&indexed).Error
+	if err != nil {
+		return err
+	}
+	err = c.DB.Model(&Case{}).Count(&cases).Error
+	if err != nil {
+		return err
+	}
+	if indexed == cases {
+		return nil
+	}
+	log.Printf("Rebuilding full text index, %d of %d cases indexed\n", indexed, cases)
+	err = c.DB.Exec("DELETE FROM " + textIndexTable).Error
+	if err != nil {
+		return err
+	}
+	return c.DB.Exec("INSERT INTO " + textIndexTable + " (id, case_number, summary) SELECT id, case_number, summary FROM cases").Error
+}
+
+// indexCase refreshes the full text index entry of a single case from what is stored in the cases table
+func (c Cache) indexCase(id string) error {
+	if !c.textIndex {
+		return nil
+	}
+	err := c.DB.Exec("DELETE FROM "+textIndexTable+" WHERE id = ?", id).Error
+	if err != nil {
+		return err
+	}
+	return c.DB.Exec("INSERT INTO "+textIndexTable+" (id, case_number, summary) SELECT id, case_number, summary FROM cases WHERE id = ?", id).Error
+}
+
+// indexCases refreshes the full text index entries of several cases
+func (c Cache) indexCases(ids []string) error {
+	if !c.textIndex {
+		return nil
+	}
+	for _, chunk := range chunks(ids) {
+		err := c.DB.Exec("DELETE FROM "+textIndexTable+" WHERE id IN ?", chunk).Error
+		if err != nil {
+			return err
+		}
+		err = c.DB.Exec("INSERT INTO "+textIndexTable+" (id, case_number, summary) SELECT id, case_number, summary FROM cases WHERE id IN ?", chunk).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unindexCase removes a case from the full text index
+func (c Cache) unindexCase(id string) error {
+	if !c.textIndex {
+		return nil
+	}
+	return c.DB.Exec("DELETE FROM "+textIndexTable+" WHERE id = ?", id).Error
+}
+
+// SearchText returns the cases matching an FTS5 query, best matches first.
+// See https://www.sqlite.org/fts5.html#full_text_query_syntax, e.g. '"velero restore"' for a phrase.
+func (c Cache) SearchText(query string, limit int) ([]TextMatch, error) {
+	if c.isPostgres() {
+		return c.searchTextPostgres(query, limit)
+	}
+	if !c.textIndex {
+		return c.searchTextLike(query, limit)
+	}
+	matches := make([]TextMatch, 0)
+	err := c.DB.Raw("SELECT t.id, t.case_number, c.summary, bm25("+textIndexTable+") AS rank, "+
+		"snippet("+textIndexTable+", -1, ?, ?, '...', 16) AS snippet "+
+		"FROM "+textIndexTable+" t JOIN cases c ON c.id = t.id "+
+		"WHERE "+textIndexTable+" MATCH ? ORDER BY rank LIMIT ?",
+		SnippetStart, SnippetEnd, query, limit).Scan(&matches).Error
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// searchTextPostgres uses websearch syntax, which accepts quoted phrases and '-' to exclude terms like FTS5.
+// ts_rank is negated so a lower Rank is a better match as with SQLite's bm25.
+func (c Cache) searchTextPostgres(query string, limit int) ([]TextMatch, error) {
+	matches := make([]TextMatch, 0)
+	err := c.DB.Raw("SELECT id, case_number, summary, "+
+		"-ts_rank(to_tsvector('english', summary), websearch_to_tsquery('english', @q)) AS rank, "+
+		"ts_headline('english', summary, websearch_to_tsquery('english', @q), @opts) AS snippet "+
+		"FROM cases WHERE to_tsvector('english', summary) @@ websearch_to_tsquery('english', @q) "+
+		"ORDER BY rank LIMIT @limit",
+		map[string]interface{}{
+			"q":     query,
+			"opts":  "StartSel=" + SnippetStart + ", StopSel=" + SnippetEnd,
+			"limit": limit,
+		}).Scan(&matches).Error
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// searchTextLike requires every word of the query to appear in the summary
+func (c Cache) searchTextLike(query string, limit int) ([]TextMatch, error) {
+	words := strings.Fields(strings.ReplaceAll(query, "\"", " "))
+	db := c.DB.Model(&Case{}).Order("last_modified_date desc").Limit(limit)
+	for _, w := range words {
+		db = db.Where("summary LIKE ?", "%"+w+"%")
+	}
+	cases := make([]Case, 0)
+	err := db.Find(&cases).Error
+	if err != nil {
+		return nil, err
+	}
+	matches := make([]TextMatch, 0, len(cases))
+	for _, myCase := range cases {
+		snippet := myCase.Summary
+		for _, w := range words {
+			snippet = highlight(snippet, w)
+		}
+		matches = append(matches, TextMatch{Id: myCase.Id, CaseNumber: myCase.CaseNumber, Summary: myCase.Summary, Snippet: snippet})
+	}
+	return matches, nil
+}
+
+// highlight wraps case-insensitive occurrences of word in the snippet markers
+func highlight(s, word string) string {
+	lower := strings.ToLower(s)
+	word = strings.ToLower(word)
+	if word == "" {
+		return s
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(lower, word)
+		if i < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		b.WriteString(s[:i] + SnippetStart + s[i:i+len(word)] + SnippetEnd)
+		s, lower = s[i+len(word):], lower[i+len(word):]
+	}
+}
@@ -0,0 +1,252 @@
+package classify
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ModelName is the name the trained relevance model is stored under in the cache
+const ModelName = "relevance-naive-bayes"
+
+// ErrNotEnoughExamples is returned when training data lacks examples of both relevant and ignored cases
+var ErrNotEnoughExamples = errors.New("need at least one 'relevant' and one 'ignored' triaged case to train")
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"for": true, "from": true, "in": true, "is": true, "it": true, "not": true, "of": true, "on": true,
+	"or": true, "the": true, "to": true, "with": true, "when": true, "after": true, "while": true,
+}
+
+// Model is a multinomial naive Bayes model predicting whether a Case is relevant to us.
+// It is trained from triaged cases and is serialized as JSON to be kept in the cache.
+type Model struct {
+	TrainedAt time.Time
+	// Docs is the number of training cases per label
+	Docs map[string]int
+	// Tokens is the count of each token per label
+	Tokens map[string]map[string]int
+	// TokenTotals is the total count of tokens per label
+	TokenTotals map[string]int
+	Vocabulary  int
+}
+
+// Tokenize turns the fields we learn from into tokens, the summary is split into lowercase words
+// while the products and type are kept whole and prefixed so they do not collide with summary words
+func Tokenize(c cache.Case) []string {
+	tokens := make([]string, 0)
+	words := strings.FieldsFunc(strings.ToLower(c.Summary), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, w := range words {
+		if len(w) < 2 || stopWords[w] {
+			continue
+		}
+		tokens = append(tokens, w)
+	}
+	for _, p := range c.Products {
+		tokens = append(tokens, "product:"+strings.ToLower(p.Name))
+	}
+	if c.Type != "" {
+		tokens = append(tokens, "type:"+strings.ToLower(c.Type))
+	}
+	return tokens
+}
+
+// TrainingCases returns the cases usable as training data, those marked relevant or ignored.
+// Unless includeRules is set only manually triaged cases are used, so the model does not simply re-learn our rules.
+func TrainingCases(cases []cache.Case, includeRules bool) []cache.Case {
+	examples := make([]cache.Case, 0)
+	for _, c := range cases {
+		if c.Relevance != cache.RelevanceRelevant && c.Relevance != cache.RelevanceIgnored {
+			continue
+		}
+		if !includeRules && c.ClassifiedBy != cache.ClassifiedManually {
+			continue
+		}
+		examples = append(examples, c)
+	}
+	return examples
+}
+
+// Train builds a Model from cases labelled relevant or ignored
+func Train(cases []cache.Case) (*Model, error) {
+	m := &Model{
+		TrainedAt:   time.Now(),
+		Docs:        map[string]int{},
+		Tokens:      map[string]map[string]int{cache.RelevanceRelevant: {}, cache.RelevanceIgnored: {}},
+		TokenTotals: map[string]int{},
+	}
+	vocabulary := map[string]bool{}
+	for _, c := range cases {
+		label := c.Relevance
+		if label != cache.RelevanceRelevant && label != cache.RelevanceIgnored {
+			continue
+		}
+		m.Docs[label]++
+		for _, t := range Tokenize(c) {
+			m.Tokens[label][t]++
+			m.TokenTotals[label]++
+			vocabulary[t] = true
+		}
+	}
+	if m.Docs[cache.RelevanceRelevant] == 0 || m.Docs[cache.RelevanceIgnored] == 0 {
+		return nil, ErrNotEnoughExamples
+	}
+	m.Vocabulary = len(vocabulary)
+	return m, nil
+}
+
+// Score returns the probability that the Case is relevant
+func (m *Model) Score(c cache.Case) float64 {
+	total := float64(m.Docs[cache.RelevanceRelevant] + m.Docs[cache.RelevanceIgnored])
+	logProb := map[string]float64{}
+	for _, label := range []string{cache.RelevanceRelevant, cache.RelevanceIgnored} {
+		lp := math.Log(float64(m.Docs[label]) / total)
+		denominator := float64(m.TokenTotals[label] + m.Vocabulary + 1)
+		for _, t := range Tokenize(c) {
+			// Laplace smoothing so unseen tokens do not zero out the probability
+			lp += math.Log(float64(m.Tokens[label][t]+1) / denominator)
+		}
+		logProb[label] = lp
+	}
+	// Equivalent to exp(r) / (exp(r) + exp(i)) without underflowing
+	return 1 / (1 + math.Exp(logProb[cache.RelevanceIgnored]-logProb[cache.RelevanceRelevant]))
+}
+
+// Classify sets the relevance score on the Case, it satisfies cache.Classifier
+func (m *Model) Classify(c *cache.Case) {
+	score := m.Score(*c)
+	c.Score = &score
+}
+
+// Marshal serializes the Model for storage in the cache
+func (m *Model) Marshal() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// UnmarshalModel restores a Model previously serialized with Marshal
+func UnmarshalModel(data []byte) (*Model, error) {
+	m := &Model{}
+	err := json.Unmarshal(data, m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Chain runs several classifiers in order, e.g. rules then the model score
+type Chain []cache.Classifier
+
+// Classify runs each classifier in the Chain
+func (ch Chain) Classify(c *cache.Case) {
+	for _, classifier := range ch {
+		classifier.Classify(c)
+	}
+}
+
+// Metrics summarizes how well predictions matched the triaged labels, a score of 0.5 or more predicts relevant
+type Metrics struct {
+	Examples       int
+	TruePositives  int
+	FalsePositives int
+	TrueNegatives  int
+	FalseNegatives int
+}
+
+func (m *Metrics) add(relevant bool, score float64) {
+	m.Examples++
+	predicted := score >= 0.5
+	switch {
+	case predicted && relevant:
+		m.TruePositives++
+	case predicted && !relevant:
+		m.FalsePositives++
+	case !predicted && !relevant:
+		m.TrueNegatives++
+	default:
+		m.FalseNegatives++
+	}
+}
+
+func (m Metrics) Accuracy() float64 {
+	return ratio(m.TruePositives+m.TrueNegatives, m.Examples)
+}
+
+func (m Metrics) Precision() float64 {
+	return ratio(m.TruePositives, m.TruePositives+m.FalsePositives)
+}
+
+func (m Metrics) Recall() float64 {
+	return ratio(m.TruePositives, m.TruePositives+m.FalseNegatives)
+}
+
+func (m Metrics) F1() float64 {
+	p, r := m.Precision(), m.Recall()
+	if p+r == 0 {
+		return 0
+	}
+	return 2 * p * r / (p + r)
+}
+
+func ratio(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
+
+// CrossValidate runs k-fold cross validation, returning the metrics of each fold.
+// Cases are assigned to folds by sorted Id so results are repeatable between runs.
+func CrossValidate(cases []cache.Case, folds int) ([]Metrics, error) {
+	if folds < 2 {
+		return nil, errors.New("cross validation needs at least 2 folds")
+	}
+	if len(cases) < folds {
+		return nil, errors.New("fewer triaged cases than folds")
+	}
+	sorted := make([]cache.Case, len(cases))
+	copy(sorted, cases)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Id < sorted[j].Id })
+
+	results := make([]Metrics, 0, folds)
+	for fold := 0; fold < folds; fold++ {
+		training := make([]cache.Case, 0, len(sorted))
+		testing := make([]cache.Case, 0, len(sorted)/folds+1)
+		for i, c := range sorted {
+			if i%folds == fold {
+				testing = append(testing, c)
+			} else {
+				training = append(training, c)
+			}
+		}
+		m, err := Train(training)
+		if err != nil {
+			return nil, err
+		}
+		metrics := Metrics{}
+		for _, c := range testing {
+			metrics.add(c.Relevance == cache.RelevanceRelevant, m.Score(c))
+		}
+		results = append(results, metrics)
+	}
+	return results, nil
+}
+
+// Sum combines the metrics of several folds
+func Sum(folds []Metrics) Metrics {
+	total := Metrics{}
+	for _, f := range folds {
+		total.Examples += f.Examples
+		total.TruePositives += f.TruePositives
+		total.FalsePositives += f.FalsePositives
+		total.TrueNegatives += f.TrueNegatives
+		total.FalseNegatives += f.FalseNegatives
+	}
+	return total
+}
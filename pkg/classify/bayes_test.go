@@ -0,0 +1,88 @@
+package classify
+
+import (
+	"fmt"
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func getTriagedCases() []cache.Case {
+	cases := make([]cache.Case, 0)
+	relevant := []string{"Velero restore hangs", "OADP backup fails with restic", "velero schedule missing backups",
+		"Restic pod crashloop during backup", "Backup to S3 fails with velero"}
+	ignored := []string{"Router returns 503", "Node NotReady after upgrade", "Ingress certificate expired",
+		"Console login loop", "Etcd defrag alerts firing"}
+	for i, s := range relevant {
+		cases = append(cases, cache.Case{Id: fmt.Sprintf("r%d", i), Summary: s,
+			Relevance: cache.RelevanceRelevant, ClassifiedBy: cache.ClassifiedManually})
+	}
+	for i, s := range ignored {
+		cases = append(cases, cache.Case{Id: fmt.Sprintf("i%d", i), Summary: s,
+			Relevance: cache.RelevanceIgnored, ClassifiedBy: cache.ClassifiedManually})
+	}
+	return cases
+}
+
+func TestTokenize(t *testing.T) {
+	c := cache.Case{Summary: "Velero restore: PVs are not restored!", Type: "Bug",
+		Products: []cache.Product{{Name: "OpenShift Container Platform"}}}
+	tokens := Tokenize(c)
+	assert.Equal(t, []string{"velero", "restore", "pvs", "restored", "product:openshift container platform", "type:bug"}, tokens)
+}
+
+func TestTrainAndScore(t *testing.T) {
+	m, err := Train(getTriagedCases())
+	require.NoError(t, err)
+
+	assert.Greater(t, m.Score(cache.Case{Summary: "velero backup stuck"}), 0.5)
+	assert.Less(t, m.Score(cache.Case{Summary: "router 503 after upgrade"}), 0.5)
+
+	// Survives a round trip through serialization
+	data, err := m.Marshal()
+	require.NoError(t, err)
+	restored, err := UnmarshalModel(data)
+	require.NoError(t, err)
+	assert.InDelta(t, m.Score(cache.Case{Summary: "velero"}), restored.Score(cache.Case{Summary: "velero"}), 1e-9)
+}
+
+func TestTrainNeedsBothLabels(t *testing.T) {
+	_, err := Train(getTriagedCases()[:5])
+	assert.ErrorIs(t, err, ErrNotEnoughExamples)
+}
+
+func TestTrainingCasesOnlyManualByDefault(t *testing.T) {
+	cases := getTriagedCases()
+	cases = append(cases,
+		cache.Case{Id: "rule1", Relevance: cache.RelevanceRelevant, ClassifiedBy: "some-rule"},
+		cache.Case{Id: "untriaged"})
+	assert.Len(t, TrainingCases(cases, false), 10)
+	assert.Len(t, TrainingCases(cases, true), 11)
+}
+
+func TestCrossValidate(t *testing.T) {
+	folds, err := CrossValidate(getTriagedCases(), 5)
+	require.NoError(t, err)
+	require.Len(t, folds, 5)
+	total := Sum(folds)
+	assert.Equal(t, 10, total.Examples)
+	assert.Equal(t, total.Examples, total.TruePositives+total.FalsePositives+total.TrueNegatives+total.FalseNegatives)
+	assert.GreaterOrEqual(t, total.Accuracy(), 0.5)
+
+	_, err = CrossValidate(getTriagedCases(), 1)
+	assert.Error(t, err)
+}
+
+func TestChain(t *testing.T) {
+	rs, err := NewRuleSet([]Rule{{Name: "velero", SummaryRegex: "(?i)velero", Relevance: cache.RelevanceRelevant}})
+	require.NoError(t, err)
+	m, err := Train(getTriagedCases())
+	require.NoError(t, err)
+
+	c := cache.Case{Summary: "Velero restore hangs"}
+	Chain{rs, m}.Classify(&c)
+	assert.Equal(t, "velero", c.ClassifiedBy)
+	require.NotNil(t, c.Score)
+	assert.Greater(t, *c.Score, 0.5)
+}
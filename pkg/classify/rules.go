@@ -0,0 +1,184 @@
+package classify
+
+import (
+	"fmt"
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/jwmatthews/case_watcher/pkg/sla"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Rule describes a declarative classification rule read from configuration.
+// Every condition that is set must match for the rule to apply, empty conditions are ignored.
+type Rule struct {
+	Name           string   `mapstructure:"name"`
+	SummaryRegex   string   `mapstructure:"summary_regex"`
+	Products       []string `mapstructure:"products"`
+	AccountNumbers []string `mapstructure:"account_numbers"`
+	Types          []string `mapstructure:"types"`
+	// SLAStatuses and SLARemaining match where the case stands against its SLA target, see RuleSet.WithSLA.
+	// SLARemaining matches a case waiting on us with at most that long left, negative once breached for that long.
+	SLAStatuses  []string      `mapstructure:"sla_status"`
+	SLARemaining time.Duration `mapstructure:"sla_remaining"`
+	// Relevance and Area are applied to a Case when the rule matches
+	Relevance string `mapstructure:"relevance"`
+	Area      string `mapstructure:"area"`
+}
+
+// Evaluation explains the outcome of evaluating a single Rule against a Case
+type Evaluation struct {
+	Rule    string
+	Matched bool
+	Reasons []string
+}
+
+// RuleSet is an ordered list of compiled rules, the first matching rule wins
+type RuleSet struct {
+	rules   []Rule
+	regexes []*regexp.Regexp
+	// The SLA conditions are measured against slaPolicy at slaNow, see WithSLA
+	slaPolicy   sla.Policy
+	slaAccounts func(accountNumber string) cache.Account
+	slaNow      time.Time
+}
+
+// NewRuleSet validates the rules and compiles their regular expressions
+func NewRuleSet(rules []Rule) (*RuleSet, error) {
+	rs := &RuleSet{rules: rules, regexes: make([]*regexp.Regexp, len(rules))}
+	for i, r := range rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("classification rule #%d is missing a 'name'", i+1)
+		}
+		switch r.Relevance {
+		case cache.RelevanceUnknown, cache.RelevanceRelevant, cache.RelevanceIgnored:
+		default:
+			return nil, fmt.Errorf("classification rule '%s' has unknown relevance '%s', expected '%s' or '%s'",
+				r.Name, r.Relevance, cache.RelevanceRelevant, cache.RelevanceIgnored)
+		}
+		for _, status := range r.SLAStatuses {
+			switch status {
+			case sla.StatusOK, sla.StatusAtRisk, sla.StatusBreached, sla.StatusPaused, sla.StatusClosed:
+			default:
+				return nil, fmt.Errorf("classification rule '%s' has unknown sla_status '%s', expected %s, %s, %s, %s or %s",
+					r.Name, status, sla.StatusOK, sla.StatusAtRisk, sla.StatusBreached, sla.StatusPaused, sla.StatusClosed)
+			}
+		}
+		if r.SummaryRegex != "" {
+			re, err := regexp.Compile(r.SummaryRegex)
+			if err != nil {
+				return nil, fmt.Errorf("classification rule '%s' has an invalid summary_regex: %s", r.Name, err)
+			}
+			rs.regexes[i] = re
+		}
+	}
+	return rs, nil
+}
+
+// WithSLA measures the SLA conditions against the policy at the given time, the current time when zero.
+// accounts looks up the account of a case for its enhanced SLA targets, it may be nil.
+func (rs *RuleSet) WithSLA(policy sla.Policy, accounts func(accountNumber string) cache.Account, now time.Time) *RuleSet {
+	rs.slaPolicy = policy
+	rs.slaAccounts = accounts
+	rs.slaNow = now
+	return rs
+}
+
+// UsesSLA returns true when a rule has an SLA condition
+func (rs *RuleSet) UsesSLA() bool {
+	for _, r := range rs.rules {
+		if len(r.SLAStatuses) > 0 || r.SLARemaining != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of rules
+func (rs *RuleSet) Len() int {
+	return len(rs.rules)
+}
+
+// Classify applies the first matching rule to the Case
+func (rs *RuleSet) Classify(c *cache.Case) {
+	for i, r := range rs.rules {
+		if rs.evaluate(i, *c).Matched {
+			c.Relevance = r.Relevance
+			c.Area = r.Area
+			c.ClassifiedBy = r.Name
+			return
+		}
+	}
+}
+
+// Explain evaluates every rule in order against the Case so a user can see why a rule did or did not apply
+func (rs *RuleSet) Explain(c cache.Case) []Evaluation {
+	evaluations := make([]Evaluation, 0, len(rs.rules))
+	for i := range rs.rules {
+		evaluations = append(evaluations, rs.evaluate(i, c))
+	}
+	return evaluations
+}
+
+func (rs *RuleSet) evaluate(index int, c cache.Case) Evaluation {
+	r := rs.rules[index]
+	e := Evaluation{Rule: r.Name, Matched: true}
+	check := func(ok bool, reason string) {
+		if !ok {
+			e.Matched = false
+			reason = "not " + reason
+		}
+		e.Reasons = append(e.Reasons, reason)
+	}
+	if re := rs.regexes[index]; re != nil {
+		check(re.MatchString(c.Summary), fmt.Sprintf("summary matches /%s/", r.SummaryRegex))
+	}
+	if len(r.Products) > 0 {
+		names := make([]string, 0, len(c.Products))
+		for _, p := range c.Products {
+			names = append(names, p.Name)
+		}
+		check(containsAny(r.Products, names), fmt.Sprintf("product in %q", r.Products))
+	}
+	if len(r.AccountNumbers) > 0 {
+		check(containsAny(r.AccountNumbers, []string{c.AccountNumber}), fmt.Sprintf("account number in %q", r.AccountNumbers))
+	}
+	if len(r.Types) > 0 {
+		check(containsAny(r.Types, []string{c.Type}), fmt.Sprintf("type in %q", r.Types))
+	}
+	if len(r.SLAStatuses) > 0 || r.SLARemaining != 0 {
+		s := rs.evaluateSLA(c)
+		if len(r.SLAStatuses) > 0 {
+			check(containsAny(r.SLAStatuses, []string{s.Status}), fmt.Sprintf("SLA status in %q", r.SLAStatuses))
+		}
+		if r.SLARemaining != 0 {
+			check(s.Waiting && s.Target > 0 && s.Remaining() <= r.SLARemaining,
+				fmt.Sprintf("SLA remaining at most %s", r.SLARemaining))
+		}
+	}
+	return e
+}
+
+func (rs *RuleSet) evaluateSLA(c cache.Case) sla.Evaluation {
+	account := cache.Account{}
+	if rs.slaAccounts != nil && c.AccountNumber != "" {
+		account = rs.slaAccounts(c.AccountNumber)
+	}
+	now := rs.slaNow
+	if now.IsZero() {
+		now = time.Now()
+	}
+	return rs.slaPolicy.Evaluate(c, account, now)
+}
+
+// containsAny is a case-insensitive check for any of the values being present in wanted
+func containsAny(wanted []string, values []string) bool {
+	for _, w := range wanted {
+		for _, v := range values {
+			if strings.EqualFold(w, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package classify
+
+import (
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/jwmatthews/case_watcher/pkg/sla"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func getSampleRules() []Rule {
+	return []Rule{
+		{Name: "velero", SummaryRegex: "(?i)velero|oadp", Relevance: cache.RelevanceRelevant, Area: "backup"},
+		{Name: "partner-accounts", AccountNumbers: []string{"42"}, Relevance: cache.RelevanceIgnored},
+		{Name: "mtc", Products: []string{"Migration Toolkit for Containers"}, Types: []string{"Bug"}, Relevance: cache.RelevanceRelevant, Area: "migration"},
+	}
+}
+
+func TestNewRuleSetRejectsInvalidRules(t *testing.T) {
+	_, err := NewRuleSet([]Rule{{Name: "bad", SummaryRegex: "("}})
+	assert.Error(t, err)
+	_, err = NewRuleSet([]Rule{{Name: "bad", Relevance: "maybe"}})
+	assert.Error(t, err)
+	_, err = NewRuleSet([]Rule{{SummaryRegex: "foo"}})
+	assert.Error(t, err)
+	_, err = NewRuleSet([]Rule{{Name: "bad", SLAStatuses: []string{"late"}}})
+	assert.Error(t, err)
+}
+
+func TestRuleSet_ClassifyFirstMatchWins(t *testing.T) {
+	rs, err := NewRuleSet(getSampleRules())
+	require.NoError(t, err)
+
+	// Matches both 'velero' and 'partner-accounts', only the first should apply
+	c := cache.Case{Id: "case1", Summary: "Velero restore is stuck", AccountNumber: "42"}
+	rs.Classify(&c)
+	assert.Equal(t, cache.RelevanceRelevant, c.Relevance)
+	assert.Equal(t, "backup", c.Area)
+	assert.Equal(t, "velero", c.ClassifiedBy)
+
+	c = cache.Case{Id: "case2", Summary: "Unrelated", AccountNumber: "42"}
+	rs.Classify(&c)
+	assert.Equal(t, cache.RelevanceIgnored, c.Relevance)
+	assert.Equal(t, "partner-accounts", c.ClassifiedBy)
+
+	c = cache.Case{Id: "case3", Summary: "Unrelated", AccountNumber: "1"}
+	rs.Classify(&c)
+	assert.Equal(t, cache.RelevanceUnknown, c.Relevance)
+	assert.Equal(t, "", c.ClassifiedBy)
+}
+
+func TestRuleSet_ClassifyRequiresAllConditions(t *testing.T) {
+	rs, err := NewRuleSet(getSampleRules())
+	require.NoError(t, err)
+
+	products := []cache.Product{{Name: "migration toolkit for containers"}}
+	c := cache.Case{Id: "case1", Type: "Bug", Products: products}
+	rs.Classify(&c)
+	assert.Equal(t, "mtc", c.ClassifiedBy)
+	assert.Equal(t, "migration", c.Area)
+
+	c = cache.Case{Id: "case2", Type: "Other", Products: products}
+	rs.Classify(&c)
+	assert.Equal(t, "", c.ClassifiedBy)
+}
+
+func TestRuleSet_Explain(t *testing.T) {
+	rs, err := NewRuleSet(getSampleRules())
+	require.NoError(t, err)
+
+	evaluations := rs.Explain(cache.Case{Id: "case1", Summary: "OADP backup fails", Type: "Bug"})
+	require.Len(t, evaluations, 3)
+	assert.True(t, evaluations[0].Matched)
+	assert.Equal(t, "velero", evaluations[0].Rule)
+	assert.False(t, evaluations[1].Matched)
+	assert.False(t, evaluations[2].Matched)
+	assert.Contains(t, evaluations[2].Reasons, "type in [\"Bug\"]")
+	assert.Contains(t, evaluations[2].Reasons[0], "not product in")
+}
+
+func TestRuleSet_ClassifySLA(t *testing.T) {
+	rs, err := NewRuleSet([]Rule{
+		{Name: "breached", SLAStatuses: []string{sla.StatusBreached}, Relevance: cache.RelevanceRelevant, Area: "escalate"},
+		{Name: "due-soon", SLARemaining: 2 * time.Hour, Relevance: cache.RelevanceRelevant, Area: "due"},
+	})
+	require.NoError(t, err)
+	assert.True(t, rs.UsesSLA())
+	now := time.Date(2022, 3, 10, 12, 0, 0, 0, time.UTC)
+	policy := sla.Policy{
+		Targets:         map[string]sla.Target{"2": {Response: 4 * time.Hour}},
+		EnhancedTargets: map[string]sla.Target{"2": {Response: time.Hour}},
+	}
+	accounts := func(accountNumber string) cache.Account {
+		return cache.Account{AccountNumber: accountNumber, HasEnhancedSLA: accountNumber == "42"}
+	}
+	rs.WithSLA(policy, accounts, now)
+
+	tests := []struct {
+		name    string
+		c       cache.Case
+		matched string
+	}{
+		{"past its target", cache.Case{Severity: "2 (High)", Status: "Open", CreatedDate: now.Add(-5 * time.Hour)}, "breached"},
+		{"within two hours of it", cache.Case{Severity: "2 (High)", Status: "Open", CreatedDate: now.Add(-3 * time.Hour)}, "due-soon"},
+		{"well within it", cache.Case{Severity: "2 (High)", Status: "Open", CreatedDate: now.Add(-time.Hour)}, ""},
+		{"enhanced SLA account", cache.Case{Severity: "2 (High)", Status: "Open", CreatedDate: now.Add(-2 * time.Hour), AccountNumber: "42"}, "breached"},
+		{"waiting on the customer", cache.Case{Severity: "2 (High)", Status: "Waiting on Customer", CreatedDate: now.Add(-5 * time.Hour)}, ""},
+		{"no target", cache.Case{Severity: "4 (Low)", Status: "Open", CreatedDate: now.Add(-5 * time.Hour)}, ""},
+	}
+	for _, tt := range tests {
+		c := tt.c
+		rs.Classify(&c)
+		assert.Equal(t, tt.matched, c.ClassifiedBy, tt.name)
+	}
+
+	evaluations := rs.Explain(tests[1].c)
+	assert.Equal(t, []string{"not SLA status in [\"breached\"]"}, evaluations[0].Reasons)
+	assert.Equal(t, []string{"SLA remaining at most 2h0m0s"}, evaluations[1].Reasons)
+}
@@ -1,8 +1,15 @@
 package email
 
 import (
+	"bytes"
+	"encoding/base64"
 	"fmt"
 	"github.com/jwmatthews/case_watcher/pkg/report"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -21,7 +28,74 @@ const (
 	CharSet = "UTF-8"
 )
 
-func Send(report report.Report, sender string, region string, recipients []string) error {
+// Attachment is a file sent along with the report
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// rawMessage returns the report as a MIME message with the attachments, for SES to send as is
+func rawMessage(subject, html, sender string, recipients []string, attachments []Attachment) ([]byte, error) {
+	buf := bytes.Buffer{}
+	mw := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "From: %s\r\n", sender)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(recipients, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode(CharSet, subject))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=" + CharSet},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = writeBase64(part, []byte(html))
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range attachments {
+		part, err = mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = writeBase64(part, a.Data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = mw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeBase64 writes data base64 encoded in lines of 76 characters, as MIME requires
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 0 {
+		n := 76
+		if len(encoded) < n {
+			n = len(encoded)
+		}
+		_, err := io.WriteString(w, encoded[:n]+"\r\n")
+		if err != nil {
+			return err
+		}
+		encoded = encoded[n:]
+	}
+	return nil
+}
+
+// Send emails the report, with the attachments when there are any
+func Send(report report.Report, sender string, region string, recipients []string, attachments ...Attachment) error {
 	sess, err := session.NewSession(&aws.Config{
 		Region: aws.String(region)},
 	)
@@ -57,7 +131,21 @@ func Send(report report.Report, sender string, region string, recipients []strin
 		Source: aws.String(sender),
 	}
 
-	result, err := svc.SendEmail(input)
+	var result fmt.Stringer
+	if len(attachments) == 0 {
+		result, err = svc.SendEmail(input)
+	} else {
+		var data []byte
+		data, err = rawMessage(report.GetSubjectLine(), report.ToHTML(), sender, recipients, attachments)
+		if err != nil {
+			return err
+		}
+		result, err = svc.SendRawEmail(&ses.SendRawEmailInput{
+			Destinations: toAddresses,
+			RawMessage:   &ses.RawMessage{Data: data},
+			Source:       aws.String(sender),
+		})
+	}
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
 			switch aerr.Code() {
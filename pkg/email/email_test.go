@@ -0,0 +1,53 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"testing"
+)
+
+func TestRawMessage(t *testing.T) {
+	attachment := Attachment{Filename: "case_watcher-2022-01-05.xlsx", ContentType: "application/zip", Data: bytes.Repeat([]byte{0, 1, 2}, 100)}
+	data, err := rawMessage("Case Watcher: 3 open cases", "<h1>Report</h1>", "me@example.com",
+		[]string{"a@example.com", "b@example.com"}, []Attachment{attachment})
+	require.NoError(t, err)
+
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, "me@example.com", msg.Header.Get("From"))
+	assert.Equal(t, "a@example.com, b@example.com", msg.Header.Get("To"))
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, "Case Watcher: 3 open cases", subject)
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/mixed", mediaType)
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	html, err := r.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "text/html; charset=UTF-8", html.Header.Get("Content-Type"))
+
+	file, err := r.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, attachment.Filename, file.FileName())
+	assert.Equal(t, "application/zip", file.Header.Get("Content-Type"))
+	encoded, err := ioutil.ReadAll(file)
+	require.NoError(t, err)
+	lines := bytes.Split(bytes.TrimSpace(encoded), []byte("\r\n"))
+	for _, line := range lines {
+		assert.LessOrEqual(t, len(line), 76)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(bytes.Join(lines, nil)))
+	require.NoError(t, err)
+	assert.Equal(t, attachment.Data, decoded)
+
+	_, err = r.NextPart()
+	assert.Error(t, err, "only the body and the attachment")
+}
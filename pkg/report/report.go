@@ -3,38 +3,86 @@ package report
 import (
 	"fmt"
 	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/jwmatthews/case_watcher/pkg/rollup"
+	"github.com/jwmatthews/case_watcher/pkg/sla"
+	"github.com/jwmatthews/case_watcher/pkg/trends"
+	"html/template"
+	"net/url"
+	"sort"
 	"time"
 )
 
 type Report struct {
-	Cache         *cache.Cache
+	Cache         cache.Store
 	SpreadsheetID string
+	// SLA adds a section of the open cases breaching or at risk of breaching their targets, when it has targets
+	SLA sla.Policy
+	// Trends adds charts of the cases opened and closed and the backlog over these periods, when there are any
+	Trends trends.Options
+	// TopAccounts adds a section of this many accounts with the most open cases, along with the strategic accounts
+	// with open severity 1 or 2 cases and the accounts with escalations, when it is more than 0
+	TopAccounts int
+	// Owners adds a section of the open and idle cases of each owner, nil leaves it out
+	Owners *rollup.OwnerOptions
+	// Window is the time the report covers, the week up to now when it is zero, see GetWindow
+	Window Window
+	// Clock tells the time, time.Now when nil
+	Clock func() time.Time
 }
 
+// LatestTabSetting names the cache setting holding the id of the tab of a spreadsheet to link to
+func LatestTabSetting(spreadsheetId string) string {
+	return "spreadsheet_latest_tab:" + spreadsheetId
+}
+
+// GetSpreadsheetURL links to the spreadsheet, opening the tab of the latest open cases when its id is known
 func (r Report) GetSpreadsheetURL() string {
-	return fmt.Sprintf("https://docs.google.com/spreadsheets/d/%s", r.SpreadsheetID)
+	url := fmt.Sprintf("https://docs.google.com/spreadsheets/d/%s", r.SpreadsheetID)
+	if r.Cache == nil {
+		return url
+	}
+	gid, err := r.Cache.GetSetting(LatestTabSetting(r.SpreadsheetID))
+	if err != nil || gid == "" {
+		return url
+	}
+	return url + "#gid=" + gid
 }
 
 func (r Report) toString() {
 
 }
 
+// GetSubjectLine names the report by the day its window ends
 func (r Report) GetSubjectLine() string {
-	currentDate := time.Now().Format("2006-01-02")
-	return fmt.Sprintf("Case Report for %s", currentDate)
+	return fmt.Sprintf("Case Report for %s", r.GetWindow().Until.Format("2006-01-02"))
 }
 
+// describe returns the window as shown in the report, e.g. "from 2022-03-01 to 2022-03-08"
+func (w Window) describe() string {
+	layout := "2006-01-02"
+	if w.Since.Hour() != 0 || w.Since.Minute() != 0 || w.Until.Hour() != 0 || w.Until.Minute() != 0 {
+		layout = "2006-01-02 15:04"
+	}
+	return fmt.Sprintf("from %s to %s", w.Since.Format(layout), w.Until.Format(layout))
+}
+
+// ToHTML returns the report of the cases active, opened and closed in the window of the report,
+// along with the cases open now
 func (r Report) ToHTML() string {
-	currentDate := time.Now().Format("2006-01-02")
+	window := r.GetWindow()
 	openCases, err := r.GetOpenCases()
 	if err != nil {
 		return "<h1>Error processing report</h1>"
 	}
-	closedCases, err := r.GetClosedCases()
+	closedCases, err := r.GetCasesClosedIn(window)
 	if err != nil {
 		return "<h1>Error processing report</h1>"
 	}
-	activeCases, err := r.GetActiveCasesFrom(time.Now().AddDate(0, 0, -7))
+	activeCases, err := r.GetActiveCasesIn(window)
+	if err != nil {
+		return "<h1>Error processing report</h1>"
+	}
+	newCases, err := r.GetNewCasesIn(window)
 	if err != nil {
 		return "<h1>Error processing report</h1>"
 	}
@@ -42,13 +90,220 @@ func (r Report) ToHTML() string {
 		"<p>This email was sent with "+
 		"<a href='https://github.com/jwmatthews/case_watcher'>Case Watcher</a></p>"+
 		"<p>%d Open Cases</p>"+
-		"<p>%d Active Cases updated in past week</p>"+
-		"<p>%d Closed Cases</p>"+
+		"<p>%d Active Cases updated %s</p>"+
+		"<p>%d Cases closed %s</p>"+
 		"<p>For more details visit the <a href='%s'>spreadsheet here</a></p>",
-		currentDate, len(openCases), len(activeCases), len(closedCases), r.GetSpreadsheetURL())
+		window.Until.Format("2006-01-02"), len(openCases), len(activeCases), window.describe(), len(closedCases),
+		window.describe(), r.GetSpreadsheetURL())
+	html += newCasesToHTML(newCases, window)
+	if r.SLA.Enabled() {
+		accounts := r.GetAccounts(openCases)
+		html += slaToHTML(r.SLA.Overdue(openCases, accounts, window.Until), r.SLA.Aging(openCases, accounts, window.Until))
+	}
+	if r.TopAccounts > 0 {
+		html += accountsToHTML(rollup.ByAccount(openCases, r.GetAccounts(openCases)), r.TopAccounts)
+	}
+	if r.Owners != nil {
+		html += ownersToHTML(rollup.ByOwner(openCases, *r.Owners, window.Until), r.Owners.IdleDays)
+	}
+	if r.Trends.Periods > 0 {
+		t, err := r.GetTrends(window.Until)
+		if err != nil {
+			return "<h1>Error processing report</h1>"
+		}
+		html += trendsToHTML(t)
+	}
+	return html
+}
+
+// accountsToHTML lists the accounts with the most open cases, then the strategic accounts with severe cases
+// and the accounts with escalations along with those cases
+func accountsToHTML(accounts []rollup.Account, top int) string {
+	if len(accounts) == 0 {
+		return ""
+	}
+	html := fmt.Sprintf("<h2>Top %d Accounts by open cases</h2>", len(rollup.Top(accounts, top))) +
+		"<table><tr><th>Account</th><th>CSM</th><th>Open</th><th>Severity 1/2</th><th>Escalated</th><th>Strategic</th><th>TAM</th></tr>"
+	for _, a := range rollup.Top(accounts, top) {
+		csm, tam := "", false
+		if a.Details != nil {
+			csm, tam = a.Details.CSMUserName, a.Details.HasTAM
+		}
+		html += fmt.Sprintf("<tr><td>%s</td><td>%s</td><td>%d</td><td>%d</td><td>%d</td><td>%s</td><td>%s</td></tr>",
+			template.HTMLEscapeString(a.Name()), template.HTMLEscapeString(csm), a.Open, a.Severe, a.Escalated,
+			yesNo(a.Strategic()), yesNo(tam))
+	}
+	html += "</table>"
+	html += accountCasesToHTML("Strategic accounts with open severity 1 or 2 cases", rollup.StrategicWithSevere(accounts),
+		rollup.IsSevere)
+	html += accountCasesToHTML("Accounts with escalations", rollup.WithEscalations(accounts),
+		func(c cache.Case) bool { return c.CustomerEscalation })
+	return html
+}
+
+// accountCasesToHTML lists the accounts, each with those of its cases to show
+func accountCasesToHTML(title string, accounts []rollup.Account, show func(c cache.Case) bool) string {
+	if len(accounts) == 0 {
+		return ""
+	}
+	html := fmt.Sprintf("<h3>%s</h3><ul>", title)
+	for _, a := range accounts {
+		html += fmt.Sprintf("<li>%s (%s)<ul>", template.HTMLEscapeString(a.Name()), template.HTMLEscapeString(a.AccountNumber))
+		for _, c := range a.Cases {
+			if show(c) {
+				html += fmt.Sprintf("<li>%s [%s] %s</li>", caseLink(c),
+					template.HTMLEscapeString(c.Severity), template.HTMLEscapeString(c.Summary))
+			}
+		}
+		html += "</ul></li>"
+	}
+	return html + "</ul>"
+}
+
+// ownersToHTML lists the open cases of each owner by severity with their oldest case, then the idle cases of each owner
+func ownersToHTML(owners []rollup.Owner, idleDays int) string {
+	if len(owners) == 0 {
+		return ""
+	}
+	if idleDays == 0 {
+		idleDays = rollup.DefaultIdleDays
+	}
+	levels := rollup.Levels(owners)
+	html := "<h2>Open cases by owner</h2><table><tr><th>Owner</th><th>Open</th>"
+	for _, level := range levels {
+		html += fmt.Sprintf("<th>Sev %s</th>", template.HTMLEscapeString(level))
+	}
+	html += fmt.Sprintf("<th>Oldest (days)</th><th>Idle over %d days</th></tr>", idleDays)
+	for _, o := range owners {
+		html += fmt.Sprintf("<tr><td>%s</td><td>%d</td>", template.HTMLEscapeString(o.Name), o.Open)
+		for _, level := range levels {
+			html += fmt.Sprintf("<td>%d</td>", o.Severities[level])
+		}
+		html += fmt.Sprintf("<td>%.0f</td><td>%d</td></tr>", o.Oldest.Hours()/24, len(o.Idle))
+	}
+	html += "</table>"
+
+	idle := ""
+	for _, o := range owners {
+		if len(o.Idle) == 0 {
+			continue
+		}
+		idle += fmt.Sprintf("<li>%s<ul>", template.HTMLEscapeString(o.Name))
+		for _, c := range o.Idle {
+			idle += fmt.Sprintf("<li>%s [%s] %s (last changed %s by %s)</li>", caseLink(c),
+				template.HTMLEscapeString(c.Severity), template.HTMLEscapeString(c.Summary),
+				c.LastModifiedDate.Format("2006-01-02"), template.HTMLEscapeString(c.LastModifiedByName))
+		}
+		idle += "</ul></li>"
+	}
+	if idle != "" {
+		html += fmt.Sprintf("<h3>Cases idle for more than %d days</h3><ul>%s</ul>", idleDays, idle)
+	}
 	return html
 }
 
+// caseLink returns a link to a case labelled with its number. The URI is escaped for the attribute and, as
+// html/template does, replaced by '#' when its scheme is not http or https.
+func caseLink(c cache.Case) string {
+	uri := c.Uri
+	if u, err := url.Parse(uri); err != nil || (u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https") {
+		uri = "#"
+	}
+	return fmt.Sprintf("<a href='%s'>%s</a>", template.HTMLEscapeString(uri), template.HTMLEscapeString(c.CaseNumber))
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return ""
+}
+
+// trendsToHTML charts the cases opened and closed and the backlog, in total and by severity and product, as inline SVG
+func trendsToHTML(t trends.Trends) string {
+	labels := t.Labels()
+	html := fmt.Sprintf("<h2>Trends by %s</h2>", t.Interval)
+	html += "<div>" + trends.Chart("Cases opened, closed and open", labels, trends.Flow(t.Total)) + "</div>"
+	if len(t.BySeverity) > 0 {
+		html += "<div>" + trends.Chart("Open cases by severity", labels, trends.Backlogs(t.BySeverity)) + "</div>"
+	}
+	if len(t.ByProduct) > 0 {
+		html += "<div>" + trends.Chart("Open cases by product", labels, trends.Backlogs(t.ByProduct)) + "</div>"
+	}
+	html += "<table><tr><th>Period</th><th>Opened</th><th>Closed</th><th>Open</th><th>Median days to close</th></tr>"
+	for _, p := range t.Total.Periods {
+		html += fmt.Sprintf("<tr><td>%s</td><td>%d</td><td>%d</td><td>%d</td><td>%s</td></tr>",
+			t.Label(p), p.Opened, p.Closed, p.Backlog, trends.Days(p.MedianTimeToClose))
+	}
+	return html + "</table>"
+}
+
+// slaToHTML lists the cases breaching or at risk of breaching their targets, and how long cases waited by severity
+func slaToHTML(overdue []sla.CaseEvaluation, aging []sla.Aging) string {
+	if len(aging) == 0 {
+		return ""
+	}
+	html := "<h2>Cases waiting on us</h2><table><tr><th>Severity</th><th>Waiting</th><th>Breached</th>" +
+		"<th>At risk</th><th>Longest since update (h)</th><th>Oldest (h)</th></tr>"
+	for _, a := range aging {
+		html += fmt.Sprintf("<tr><td>%s</td><td>%d</td><td>%d</td><td>%d</td><td>%.1f</td><td>%.1f</td></tr>",
+			template.HTMLEscapeString(a.Level), a.Waiting, a.Breached, a.AtRisk, sla.Hours(a.Longest), sla.Hours(a.Oldest))
+	}
+	html += "</table>"
+	if len(overdue) == 0 {
+		return html
+	}
+	html += fmt.Sprintf("<h2>%d Cases breaching or at risk of breaching their SLA</h2><ul>", len(overdue))
+	for _, e := range overdue {
+		state := fmt.Sprintf("%.1fh left", sla.Hours(e.Remaining()))
+		if e.Status == sla.StatusBreached {
+			state = fmt.Sprintf("breached %.1fh ago", sla.Hours(-e.Remaining()))
+		}
+		measure := e.Measure
+		if e.CustomerUpdate {
+			measure = "reply"
+		}
+		html += fmt.Sprintf("<li>%s [%s] %s (%s %s)</li>",
+			caseLink(e.Case), template.HTMLEscapeString(e.Case.Severity),
+			template.HTMLEscapeString(e.Case.Summary), measure, state)
+	}
+	return html + "</ul>"
+}
+
+// GetAccounts returns the cached accounts of the cases by account number
+func (r Report) GetAccounts(cases []cache.Case) map[string]cache.Account {
+	accounts := map[string]cache.Account{}
+	for _, c := range cases {
+		if _, ok := accounts[c.AccountNumber]; ok || c.AccountNumber == "" {
+			continue
+		}
+		// Accounts not cached yet are left out, e.g. they are taken to have no enhanced SLA
+		account, err := r.Cache.GetAccount(c.AccountNumber)
+		if err == nil {
+			accounts[c.AccountNumber] = account
+		}
+	}
+	return accounts
+}
+
+// newCasesToHTML lists the cases opened in the window, most likely to be relevant first
+func newCasesToHTML(cases []cache.Case, window Window) string {
+	if len(cases) == 0 {
+		return ""
+	}
+	html := fmt.Sprintf("<h2>%d New Cases opened %s</h2><ul>", len(cases), window.describe())
+	for _, c := range cases {
+		score := "unscored"
+		if c.Score != nil {
+			score = fmt.Sprintf("%.0f%% relevant", *c.Score*100)
+		}
+		html += fmt.Sprintf("<li>%s [%s] %s (%s)</li>",
+			caseLink(c), template.HTMLEscapeString(c.Severity),
+			template.HTMLEscapeString(c.Summary), score)
+	}
+	return html + "</ul>"
+}
+
 func (r Report) GetOpenCases() ([]cache.Case, error) {
 	return r.Cache.GetOpenCases()
 }
@@ -57,10 +312,84 @@ func (r Report) GetClosedCases() ([]cache.Case, error) {
 	return r.Cache.GetClosedCases()
 }
 
+// GetNewCasesFrom returns cases created since 'since', sorted by descending relevance score with unscored cases last
+func (r Report) GetNewCasesFrom(since time.Time) ([]cache.Case, error) {
+	cases, err := r.Cache.GetCasesCreatedFrom(since)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(cases, func(i, j int) bool {
+		a, b := cases[i].Score, cases[j].Score
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		return *a > *b
+	})
+	return cases, nil
+}
+
+// GetTrends returns the trends of the cached cases over the periods of r.Trends ending now,
+// with cases closed when their status history last shows them closing
+func (r Report) GetTrends(now time.Time) (trends.Trends, error) {
+	cases, err := r.Cache.ListCases(cache.CaseFilter{})
+	if err != nil {
+		return trends.Trends{}, err
+	}
+	history, err := r.Cache.GetFieldHistory("Status")
+	if err != nil {
+		return trends.Trends{}, err
+	}
+	return trends.Compute(cases, trends.ClosedDates(cases, history), r.Trends, now)
+}
+
 func (r Report) GetActiveCasesFrom(since time.Time) ([]cache.Case, error) {
 	return r.Cache.GetCasesActiveFrom(since)
 }
 
-func GetReport(myCache *cache.Cache, spreadsheetId string) Report {
+// GetActiveCasesIn returns the cases last modified in the window
+func (r Report) GetActiveCasesIn(w Window) ([]cache.Case, error) {
+	cases, err := r.GetActiveCasesFrom(w.Since)
+	if err != nil {
+		return nil, err
+	}
+	return within(cases, w, func(c cache.Case) time.Time { return c.LastModifiedDate }), nil
+}
+
+// GetNewCasesIn returns the cases created in the window, sorted like GetNewCasesFrom
+func (r Report) GetNewCasesIn(w Window) ([]cache.Case, error) {
+	cases, err := r.GetNewCasesFrom(w.Since)
+	if err != nil {
+		return nil, err
+	}
+	return within(cases, w, func(c cache.Case) time.Time { return c.CreatedDate }), nil
+}
+
+// GetCasesClosedIn returns the closed cases which were closed in the window, when their status history
+// last shows them closing
+func (r Report) GetCasesClosedIn(w Window) ([]cache.Case, error) {
+	cases, err := r.GetClosedCases()
+	if err != nil {
+		return nil, err
+	}
+	history, err := r.Cache.GetFieldHistory("Status")
+	if err != nil {
+		return nil, err
+	}
+	closed := trends.ClosedDates(cases, history)
+	return within(cases, w, func(c cache.Case) time.Time { return closed[c.Id] }), nil
+}
+
+// within returns the cases whose time, as given by at, is in the window
+func within(cases []cache.Case, w Window, at func(c cache.Case) time.Time) []cache.Case {
+	result := make([]cache.Case, 0, len(cases))
+	for _, c := range cases {
+		if w.Contains(at(c)) {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+func GetReport(myCache cache.Store, spreadsheetId string) Report {
 	return Report{Cache: myCache, SpreadsheetID: spreadsheetId}
 }
@@ -1,64 +1,56 @@
 package report
 
 import (
-	"fmt"
+	"github.com/jwmatthews/case_watcher/pkg/api"
 	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/jwmatthews/case_watcher/pkg/rollup"
+	"github.com/jwmatthews/case_watcher/pkg/sla"
+	"github.com/jwmatthews/case_watcher/pkg/trends"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"os"
+	"strings"
 	"testing"
 	"time"
 )
 
-const (
-	dbName = "unit_tests.db"
-)
-
-func CleanUpDB(dbName string) error {
-	_, err := os.Stat(dbName)
-	if err != nil {
-		fmt.Printf("Error looking up test db file, %s: %s\n", dbName, err)
-		return err
-	}
-	err = os.Remove(dbName)
-	if err != nil {
-		fmt.Printf("Error removing test db file, %s: %s\n", dbName, err)
-		return err
-	}
-	return nil
-}
-
-func InitCache(t *testing.T, dbName string) *cache.Cache {
-	myCache, err := cache.Init(dbName)
+// InitCache returns an empty in-memory cache which is closed when the test finishes
+func InitCache(t *testing.T) *cache.Cache {
+	myCache, err := cache.Init(cache.MemoryDSN)
 	if err != nil {
 		t.Fatalf("Failed to initiative database: %s\n", err)
 	}
+	t.Cleanup(func() { myCache.Close() })
 	return &myCache
 }
 
 func TestReport_GetSubjectLine(t *testing.T) {
-	myCache := InitCache(t, dbName)
-	defer CleanUpDB(dbName)
+	t.Parallel()
+	myCache := InitCache(t)
 
 	r := GetReport(myCache, "myspreadsheetID")
+	r.Clock = fixedClock
 	subjLine := r.GetSubjectLine()
-	now := time.Now().Format("2006-01-02")
-	assert.Contains(t, subjLine, now)
+	assert.Contains(t, subjLine, "2022-03-16")
 }
 
 func TestReport_GetSpreadsheetURL(t *testing.T) {
-	myCache := InitCache(t, dbName)
-	defer CleanUpDB(dbName)
+	t.Parallel()
+	myCache := InitCache(t)
 
 	sID := "spreadsheetIDX1243434"
 	r := GetReport(myCache, sID)
 	foundURL := r.GetSpreadsheetURL()
-	assert.Contains(t, foundURL, sID)
+	assert.Equal(t, "https://docs.google.com/spreadsheets/d/"+sID, foundURL)
+
+	require.NoError(t, myCache.SetSetting(LatestTabSetting(sID), "1234"))
+	assert.Equal(t, "https://docs.google.com/spreadsheets/d/"+sID+"#gid=1234", r.GetSpreadsheetURL())
+	assert.Equal(t, "https://docs.google.com/spreadsheets/d/other", GetReport(myCache, "other").GetSpreadsheetURL(),
+		"the tab of another spreadsheet is not linked to")
 }
 
 func TestReport_ToHTMLWithEmptyCache(t *testing.T) {
-	myCache := InitCache(t, dbName)
-	defer CleanUpDB(dbName)
+	t.Parallel()
+	myCache := InitCache(t)
 
 	sID := "spreadsheetIDX1243434"
 	r := GetReport(myCache, sID)
@@ -67,8 +59,8 @@ func TestReport_ToHTMLWithEmptyCache(t *testing.T) {
 }
 
 func TestReport_GetActiveCasesFromWithEmptyCache(t *testing.T) {
-	myCache := InitCache(t, dbName)
-	defer CleanUpDB(dbName)
+	t.Parallel()
+	myCache := InitCache(t)
 
 	sID := "spreadsheetIDX1243434"
 	r := GetReport(myCache, sID)
@@ -76,3 +68,150 @@ func TestReport_GetActiveCasesFromWithEmptyCache(t *testing.T) {
 	require.NoError(t, err)
 	assert.Len(t, cases, 0)
 }
+
+func TestReport_GetNewCasesFromSortsByScore(t *testing.T) {
+	t.Parallel()
+	myCache := InitCache(t)
+
+	low, high := 0.2, 0.9
+	now := time.Now()
+	require.NoError(t, myCache.StoreCase(cache.Case{Id: "unscored", CreatedDate: now}))
+	require.NoError(t, myCache.StoreCase(cache.Case{Id: "low", CreatedDate: now, Score: &low}))
+	require.NoError(t, myCache.StoreCase(cache.Case{Id: "high", CreatedDate: now, Score: &high}))
+	require.NoError(t, myCache.StoreCase(cache.Case{Id: "old", CreatedDate: now.AddDate(0, -1, 0), Score: &high}))
+
+	r := GetReport(myCache, "spreadsheetID")
+	cases, err := r.GetNewCasesFrom(now.AddDate(0, 0, -7))
+	require.NoError(t, err)
+	require.Len(t, cases, 3)
+	assert.Equal(t, "high", cases[0].Id)
+	assert.Equal(t, "low", cases[1].Id)
+	assert.Equal(t, "unscored", cases[2].Id)
+	assert.Contains(t, r.ToHTML(), "90% relevant")
+}
+
+func TestReport_ToHTMLWithSLA(t *testing.T) {
+	t.Parallel()
+	myCache := InitCache(t)
+
+	now := time.Now()
+	require.NoError(t, myCache.StoreCase(cache.Case{Id: "1", CaseNumber: "0001", Severity: "1 (Urgent)", Status: "Open",
+		Summary: "backup fails", AccountNumber: "42", CreatedDate: now.Add(-3 * time.Hour)}))
+	require.NoError(t, myCache.StoreCase(cache.Case{Id: "2", CaseNumber: "0002", Severity: "2 (High)", Status: "Open",
+		Summary: "slow restore", CreatedDate: now.Add(-30 * time.Hour), LastPublicUpdateDate: now.Add(-time.Hour),
+		LastPublicUpdateBy: "Sam Engineer"}))
+	require.NoError(t, myCache.StoreCase(cache.Case{Id: "3", CaseNumber: "0003", Severity: "1 (Urgent)",
+		Status: "Waiting on Customer", CreatedDate: now.Add(-48 * time.Hour)}))
+	require.NoError(t, myCache.StoreCase(cache.Case{Id: "4", CaseNumber: "0004", Severity: "2 (High)", Status: "Open",
+		Summary: "slow backup", ContactName: "Pat Example", CreatedDate: now.Add(-50 * time.Hour),
+		LastPublicUpdateDate: now.Add(-26 * time.Hour), LastPublicUpdateBy: "Pat Example"}))
+	require.NoError(t, myCache.StoreAccounts([]api.Account{{AccountNumber: "42", HasEnhancedSLA: true}}))
+
+	r := GetReport(myCache, "spreadsheetID")
+	assert.NotContains(t, r.ToHTML(), "SLA", "no section without targets")
+
+	r.Clock = func() time.Time { return now }
+	r.SLA = sla.Policy{
+		Targets:         map[string]sla.Target{"1": {Response: 4 * time.Hour}, "2": {Response: 8 * time.Hour, Update: 24 * time.Hour}},
+		EnhancedTargets: map[string]sla.Target{"1": {Response: 2 * time.Hour}},
+	}
+	html := r.ToHTML()
+	assert.Contains(t, html, "<h2>2 Cases breaching or at risk of breaching their SLA</h2>")
+	assert.Contains(t, html, "0001</a> [1 (Urgent)] backup fails (response breached 1.0h ago)", "the account has an enhanced SLA")
+	assert.NotContains(t, html, "slow restore (update", "updated within its target")
+	assert.Contains(t, html, "0004</a> [2 (High)] slow backup (reply breached 2.0h ago)",
+		"waiting on us since the customer's update, not since creation")
+	assert.Contains(t, html, "<tr><td>1</td><td>1</td><td>1</td><td>0</td><td>3.0</td><td>3.0</td></tr>",
+		"the case waiting on the customer is left out")
+	assert.Contains(t, html, "<tr><td>2</td><td>2</td><td>1</td><td>0</td><td>26.0</td><td>50.0</td></tr>")
+}
+
+func TestReport_GetTrends(t *testing.T) {
+	t.Parallel()
+	myCache := InitCache(t)
+
+	now := time.Now()
+	require.NoError(t, myCache.StoreCase(cache.Case{Id: "1", Severity: "2 (High)", Status: "Open",
+		CreatedDate: now.AddDate(0, 0, -20), LastModifiedDate: now.AddDate(0, 0, -20)}))
+	require.NoError(t, myCache.StoreCase(cache.Case{Id: "1", Status: "Closed", LastModifiedDate: now.AddDate(0, 0, -10)}))
+	require.NoError(t, myCache.StoreCase(cache.Case{Id: "2", Severity: "3 (Normal)", Status: "Open", CreatedDate: now.AddDate(0, 0, -1),
+		Products: []cache.Product{{Name: "OpenShift"}}}))
+
+	r := GetReport(myCache, "spreadsheetID")
+	assert.NotContains(t, r.ToHTML(), "<svg", "no trends without periods")
+
+	r.Trends = trends.Options{Interval: trends.IntervalWeek, Periods: 4}
+	result, err := r.GetTrends(now)
+	require.NoError(t, err)
+	require.Len(t, result.Total.Periods, 4)
+	opened, closed := 0, 0
+	for _, p := range result.Total.Periods {
+		opened += p.Opened
+		closed += p.Closed
+	}
+	assert.Equal(t, 2, opened)
+	assert.Equal(t, 1, closed, "closed when the status history shows it closing")
+	assert.Equal(t, 1, result.Total.Periods[3].Backlog)
+	require.Len(t, result.ByProduct, 1)
+
+	html := r.ToHTML()
+	assert.Contains(t, html, "<h2>Trends by week</h2>")
+	assert.Equal(t, 3, strings.Count(html, "<svg"), "in total, by severity and by product")
+	assert.Contains(t, html, "<td>10.0</td>", "median days to close")
+}
+
+func TestReport_ToHTMLWithAccounts(t *testing.T) {
+	t.Parallel()
+	myCache := InitCache(t)
+
+	require.NoError(t, myCache.StoreCase(cache.Case{Id: "1", CaseNumber: "0001", Severity: "1 (Urgent)", Status: "Open",
+		Summary: "backup fails", AccountNumber: "42"}))
+	require.NoError(t, myCache.StoreCase(cache.Case{Id: "2", CaseNumber: "0002", Severity: "3 (Normal)", Status: "Open",
+		Summary: "slow restore", AccountNumber: "42", CustomerEscalation: true}))
+	require.NoError(t, myCache.StoreCase(cache.Case{Id: "3", CaseNumber: "0003", Severity: "2 (High)", Status: "Open",
+		Summary: "upgrade stuck", AccountNumber: "7"}))
+	require.NoError(t, myCache.StoreAccounts([]api.Account{{AccountNumber: "42", Name: "Example & Co", Strategic: true,
+		CSMUserName: "Casey"}}))
+
+	r := GetReport(myCache, "spreadsheetID")
+	assert.NotContains(t, r.ToHTML(), "Accounts", "no section without top accounts")
+
+	r.TopAccounts = 1
+	html := r.ToHTML()
+	assert.Contains(t, html, "<h2>Top 1 Accounts by open cases</h2>")
+	assert.Contains(t, html, "<tr><td>Example &amp; Co</td><td>Casey</td><td>2</td><td>1</td><td>1</td><td>yes</td><td></td></tr>")
+	assert.NotContains(t, html, "<td>7</td>", "only the top account")
+	assert.Contains(t, html, "<h3>Strategic accounts with open severity 1 or 2 cases</h3><ul><li>Example &amp; Co (42)<ul>"+
+		"<li><a href=''>0001</a> [1 (Urgent)] backup fails</li></ul></li></ul>")
+	assert.Contains(t, html, "<h3>Accounts with escalations</h3><ul><li>Example &amp; Co (42)<ul>"+
+		"<li><a href=''>0002</a> [3 (Normal)] slow restore</li></ul></li></ul>")
+}
+
+func TestReport_ToHTMLWithOwners(t *testing.T) {
+	t.Parallel()
+	myCache := InitCache(t)
+
+	now := time.Now()
+	require.NoError(t, myCache.StoreCase(cache.Case{Id: "1", CaseNumber: "0001", Severity: "1 (Urgent)", Status: "Open",
+		Owner: "sengineer", CreatedDate: now.AddDate(0, 0, -3), LastModifiedDate: now.AddDate(0, 0, -1)}))
+	require.NoError(t, myCache.StoreCase(cache.Case{Id: "2", CaseNumber: "0002", Severity: "3 (Normal)", Status: "Open",
+		Summary: "slow restore", Owner: "Sam Engineer", CreatedDate: now.AddDate(0, 0, -30),
+		LastModifiedDate: time.Date(2022, 3, 1, 0, 0, 0, 0, time.Local), LastModifiedByName: "Pat Example"}))
+
+	r := GetReport(myCache, "spreadsheetID")
+	assert.NotContains(t, r.ToHTML(), "by owner")
+
+	r.Clock = func() time.Time { return now }
+	r.Owners = &rollup.OwnerOptions{Aliases: []rollup.Alias{{Name: "Sam Engineer", Names: []string{"sengineer"}}}}
+	html := r.ToHTML()
+	assert.Contains(t, html, "<h2>Open cases by owner</h2><table><tr><th>Owner</th><th>Open</th><th>Sev 1</th><th>Sev 3</th>"+
+		"<th>Oldest (days)</th><th>Idle over 7 days</th></tr><tr><td>Sam Engineer</td><td>2</td><td>1</td><td>1</td><td>30</td><td>1</td></tr>")
+	assert.Contains(t, html, "<h3>Cases idle for more than 7 days</h3><ul><li>Sam Engineer<ul>"+
+		"<li><a href=''>0002</a> [3 (Normal)] slow restore (last changed 2022-03-01 by Pat Example)</li></ul></li></ul>")
+}
+
+func TestCaseLink(t *testing.T) {
+	assert.Equal(t, "<a href='https://example.com/cases/0001?a=1&amp;b=&#39;2&#39;'>0001</a>",
+		caseLink(cache.Case{CaseNumber: "0001", Uri: "https://example.com/cases/0001?a=1&b='2'"}))
+	assert.Equal(t, "<a href='#'>&lt;b&gt;</a>", caseLink(cache.Case{CaseNumber: "<b>", Uri: "javascript:alert(1)"}))
+}
@@ -0,0 +1,92 @@
+package report
+
+import (
+	"fmt"
+	"time"
+)
+
+// Periods a report can cover, ending now
+const (
+	PeriodDaily   = "daily"
+	PeriodWeekly  = "weekly"
+	PeriodMonthly = "monthly"
+	// PeriodLastSent covers the time since the last report was sent, a week when none was
+	PeriodLastSent = "last-sent"
+)
+
+// LastSentSetting names the cache setting holding when the last report was sent, in RFC3339
+const LastSentSetting = "report_last_sent"
+
+// Window is the time a report covers, from Since up to Until
+type Window struct {
+	Since time.Time
+	Until time.Time
+}
+
+// Validate checks the window ends after it starts
+func (w Window) Validate() error {
+	if !w.Since.Before(w.Until) {
+		return fmt.Errorf("the report window must end after it starts, got %s to %s",
+			w.Since.Format(time.RFC3339), w.Until.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Contains returns true when t is in the window
+func (w Window) Contains(t time.Time) bool {
+	return !t.Before(w.Since) && t.Before(w.Until)
+}
+
+// PeriodWindow returns the window of a period ending at until, lastSent being when the last report was sent,
+// zero when none was
+func PeriodWindow(period string, until, lastSent time.Time) (Window, error) {
+	w := Window{Until: until}
+	switch period {
+	case PeriodDaily:
+		w.Since = until.AddDate(0, 0, -1)
+	case PeriodWeekly, "":
+		w.Since = until.AddDate(0, 0, -7)
+	case PeriodMonthly:
+		w.Since = until.AddDate(0, -1, 0)
+	case PeriodLastSent:
+		if lastSent.IsZero() {
+			return PeriodWindow(PeriodWeekly, until, lastSent)
+		}
+		w.Since = lastSent
+	default:
+		return Window{}, fmt.Errorf("unknown period '%s', expected %s, %s, %s or %s",
+			period, PeriodDaily, PeriodWeekly, PeriodMonthly, PeriodLastSent)
+	}
+	return w, w.Validate()
+}
+
+// LastSent returns when the last report was sent according to the cache, zero when none was
+func (r Report) LastSent() (time.Time, error) {
+	value, err := r.Cache.GetSetting(LastSentSetting)
+	if err != nil || value == "" {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// MarkSent records in the cache that the report was sent, the end of its window starting the next one
+func (r Report) MarkSent() error {
+	return r.Cache.SetSetting(LastSentSetting, r.GetWindow().Until.Format(time.RFC3339))
+}
+
+// now returns the time from the clock of the report, time.Now when it has none
+func (r Report) now() time.Time {
+	if r.Clock != nil {
+		return r.Clock()
+	}
+	return time.Now()
+}
+
+// GetWindow returns the window of the report, the week up to now when none was set
+func (r Report) GetWindow() Window {
+	if r.Window.Until.IsZero() {
+		w, _ := PeriodWindow(PeriodWeekly, r.now(), time.Time{})
+		return w
+	}
+	return r.Window
+}
@@ -0,0 +1,106 @@
+package report
+
+import (
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/jwmatthews/case_watcher/pkg/sla"
+	"github.com/jwmatthews/case_watcher/pkg/trends"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var clockTime = time.Date(2022, 3, 16, 9, 0, 0, 0, time.UTC)
+
+// fixedClock always tells clockTime
+func fixedClock() time.Time {
+	return clockTime
+}
+
+func TestPeriodWindow(t *testing.T) {
+	until := clockTime
+	daily, err := PeriodWindow(PeriodDaily, until, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, Window{Since: until.AddDate(0, 0, -1), Until: until}, daily)
+	weekly, err := PeriodWindow(PeriodWeekly, until, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, until.AddDate(0, 0, -7), weekly.Since)
+	monthly, err := PeriodWindow(PeriodMonthly, until, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 2, 16, 9, 0, 0, 0, time.UTC), monthly.Since)
+
+	lastSent := until.Add(-50 * time.Hour)
+	sinceSent, err := PeriodWindow(PeriodLastSent, until, lastSent)
+	require.NoError(t, err)
+	assert.Equal(t, lastSent, sinceSent.Since)
+	neverSent, err := PeriodWindow(PeriodLastSent, until, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, weekly, neverSent, "a week when no report was sent")
+
+	_, err = PeriodWindow("yearly", until, time.Time{})
+	assert.Error(t, err)
+	_, err = PeriodWindow(PeriodLastSent, until, until.Add(time.Hour))
+	assert.Error(t, err, "sent after the end of the window")
+}
+
+func TestReport_Window(t *testing.T) {
+	t.Parallel()
+	myCache := InitCache(t)
+
+	day := func(d int) time.Time { return time.Date(2022, 3, d, 12, 0, 0, 0, time.UTC) }
+	require.NoError(t, myCache.StoreCase(cache.Case{Id: "before", CaseNumber: "0001", Status: "Open",
+		CreatedDate: day(1), LastModifiedDate: day(1)}))
+	require.NoError(t, myCache.StoreCase(cache.Case{Id: "in", CaseNumber: "0002", Status: "Open", Severity: "1 (Urgent)",
+		CreatedDate: day(5), LastModifiedDate: day(6)}))
+	require.NoError(t, myCache.StoreCase(cache.Case{Id: "after", CaseNumber: "0003", Status: "Open",
+		CreatedDate: day(12), LastModifiedDate: day(12)}))
+	require.NoError(t, myCache.StoreCase(cache.Case{Id: "closed", CaseNumber: "0004", Status: "Open",
+		CreatedDate: day(1), LastModifiedDate: day(1)}))
+	require.NoError(t, myCache.StoreCase(cache.Case{Id: "closed", Status: "Closed", LastModifiedDate: day(7)}))
+
+	r := GetReport(myCache, "spreadsheetID")
+	r.Clock = fixedClock
+	assert.Equal(t, Window{Since: clockTime.AddDate(0, 0, -7), Until: clockTime}, r.GetWindow(), "the week up to now")
+	assert.Equal(t, "Case Report for 2022-03-16", r.GetSubjectLine())
+
+	r.Window = Window{Since: time.Date(2022, 3, 3, 0, 0, 0, 0, time.UTC), Until: time.Date(2022, 3, 10, 0, 0, 0, 0, time.UTC)}
+	assert.Equal(t, "Case Report for 2022-03-10", r.GetSubjectLine())
+	active, err := r.GetActiveCasesIn(r.Window)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"in", "closed"}, caseIds(active))
+	created, err := r.GetNewCasesIn(r.Window)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"in"}, caseIds(created))
+	closed, err := r.GetCasesClosedIn(r.Window)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"closed"}, caseIds(closed))
+
+	r.SLA = sla.Policy{Targets: map[string]sla.Target{"1": {Response: 7 * 24 * time.Hour}}}
+	r.Trends = trends.Options{Interval: trends.IntervalWeek, Periods: 1}
+	html := r.ToHTML()
+	assert.Contains(t, html, "<tr><td>1</td><td>1</td><td>0</td><td>0</td><td>108.0</td><td>108.0</td></tr>",
+		"SLAs are measured at the end of the window")
+	assert.NotContains(t, html, "breaching")
+	assert.Contains(t, html, "<td>2022-03-07</td>", "trends end with the week of the end of the window")
+	assert.NotContains(t, html, "2022-03-14")
+	assert.Contains(t, html, "<h1>Department Case Report 2022-03-10</h1>")
+	assert.Contains(t, html, "<p>2 Active Cases updated from 2022-03-03 to 2022-03-10</p>")
+	assert.Contains(t, html, "<p>1 Cases closed from 2022-03-03 to 2022-03-10</p>")
+	assert.Contains(t, html, "<h2>1 New Cases opened from 2022-03-03 to 2022-03-10</h2>")
+
+	lastSent, err := r.LastSent()
+	require.NoError(t, err)
+	assert.True(t, lastSent.IsZero())
+	require.NoError(t, r.MarkSent())
+	lastSent, err = r.LastSent()
+	require.NoError(t, err)
+	assert.True(t, r.Window.Until.Equal(lastSent), "the next report starts where this one ended")
+}
+
+func caseIds(cases []cache.Case) []string {
+	ids := make([]string, 0, len(cases))
+	for _, c := range cases {
+		ids = append(ids, c.Id)
+	}
+	return ids
+}
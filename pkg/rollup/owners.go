@@ -0,0 +1,145 @@
+package rollup
+
+import (
+	"fmt"
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/jwmatthews/case_watcher/pkg/sla"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Unassigned names the owner of cases without one
+const Unassigned = "(unassigned)"
+
+// DefaultIdleDays is after how many days without a change an open case is idle, when OwnerOptions give none
+const DefaultIdleDays = 7
+
+// Alias maps the names a team member shows up as, e.g. their SSO name in the portal, to the member
+type Alias struct {
+	Name  string   `mapstructure:"name"`
+	Names []string `mapstructure:"names"`
+}
+
+// OwnerOptions say who owners are and when their cases are idle
+type OwnerOptions struct {
+	Aliases []Alias `mapstructure:"aliases"`
+	// IdleDays is after how many days without a change an open case is idle
+	IdleDays int `mapstructure:"idle_days"`
+}
+
+// Validate checks every alias names a team member and no name maps to two members
+func (o OwnerOptions) Validate() error {
+	seen := map[string]string{}
+	for _, a := range o.Aliases {
+		if a.Name == "" {
+			return fmt.Errorf("an alias needs the name of the team member")
+		}
+		for _, name := range append([]string{a.Name}, a.Names...) {
+			key := strings.ToLower(strings.TrimSpace(name))
+			if other, ok := seen[key]; ok && other != a.Name {
+				return fmt.Errorf("'%s' is an alias of both %s and %s", name, other, a.Name)
+			}
+			seen[key] = a.Name
+		}
+	}
+	if o.IdleDays < 0 {
+		return fmt.Errorf("idle_days must not be negative, got %d", o.IdleDays)
+	}
+	return nil
+}
+
+// Member returns the team member owning cases with this owner, ignoring case, the owner itself when it has no alias
+func (o OwnerOptions) Member(owner string) string {
+	owner = strings.TrimSpace(owner)
+	if owner == "" {
+		return Unassigned
+	}
+	for _, a := range o.Aliases {
+		for _, name := range append([]string{a.Name}, a.Names...) {
+			if strings.EqualFold(strings.TrimSpace(name), owner) {
+				return a.Name
+			}
+		}
+	}
+	return owner
+}
+
+func (o OwnerOptions) idleAfter() time.Duration {
+	days := o.IdleDays
+	if days == 0 {
+		days = DefaultIdleDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Owner is the workload of a team member
+type Owner struct {
+	Name string
+	Open int
+	// Severities counts the open cases by severity level, e.g. "1" for "1 (Urgent)"
+	Severities map[string]int
+	// Oldest is the age of the oldest open case
+	Oldest time.Duration
+	// Idle are the open cases without a change for more than the idle days, the longest idle first
+	Idle  []cache.Case
+	Cases []cache.Case
+}
+
+// ByOwner returns the open cases of each team member, those with the most open cases first. Closed cases are left out.
+func ByOwner(cases []cache.Case, o OwnerOptions, now time.Time) []Owner {
+	byName := map[string]*Owner{}
+	names := make([]string, 0)
+	for _, c := range cases {
+		if c.Status == "Closed" {
+			continue
+		}
+		name := o.Member(c.Owner)
+		owner, ok := byName[name]
+		if !ok {
+			owner = &Owner{Name: name, Severities: map[string]int{}}
+			byName[name] = owner
+			names = append(names, name)
+		}
+		owner.Open++
+		owner.Severities[sla.Level(c.Severity)]++
+		if !c.CreatedDate.IsZero() && now.Sub(c.CreatedDate) > owner.Oldest {
+			owner.Oldest = now.Sub(c.CreatedDate)
+		}
+		if now.Sub(c.LastModifiedDate) > o.idleAfter() {
+			owner.Idle = append(owner.Idle, c)
+		}
+		owner.Cases = append(owner.Cases, c)
+	}
+	result := make([]Owner, 0, len(names))
+	for _, name := range names {
+		owner := *byName[name]
+		sort.SliceStable(owner.Idle, func(i, j int) bool {
+			return owner.Idle[i].LastModifiedDate.Before(owner.Idle[j].LastModifiedDate)
+		})
+		result = append(result, owner)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Open != result[j].Open {
+			return result[i].Open > result[j].Open
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Levels returns the severity levels of the open cases of the owners, in order
+func Levels(owners []Owner) []string {
+	seen := map[string]bool{}
+	levels := make([]string, 0)
+	for _, owner := range owners {
+		for level := range owner.Severities {
+			if !seen[level] {
+				seen[level] = true
+				levels = append(levels, level)
+			}
+		}
+	}
+	sort.Strings(levels)
+	return levels
+}
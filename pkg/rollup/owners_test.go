@@ -0,0 +1,52 @@
+package rollup
+
+import (
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestOwnerOptions_Member(t *testing.T) {
+	o := OwnerOptions{Aliases: []Alias{{Name: "Sam Engineer", Names: []string{"sengineer", "Samuel Engineer"}}}}
+	require.NoError(t, o.Validate())
+	assert.Equal(t, "Sam Engineer", o.Member("SEngineer"))
+	assert.Equal(t, "Sam Engineer", o.Member("Samuel Engineer"))
+	assert.Equal(t, "Robin Engineer", o.Member("Robin Engineer"), "no alias")
+	assert.Equal(t, Unassigned, o.Member(" "))
+
+	assert.Error(t, OwnerOptions{Aliases: []Alias{{Names: []string{"x"}}}}.Validate())
+	assert.Error(t, OwnerOptions{Aliases: []Alias{{Name: "A", Names: []string{"x"}}, {Name: "B", Names: []string{"X"}}}}.Validate())
+	assert.Error(t, OwnerOptions{IdleDays: -1}.Validate())
+}
+
+func TestByOwner(t *testing.T) {
+	now := time.Date(2022, 3, 16, 12, 0, 0, 0, time.UTC)
+	days := func(n int) time.Time { return now.AddDate(0, 0, -n) }
+	cases := []cache.Case{
+		{Id: "1", Owner: "sengineer", Severity: "1 (Urgent)", CreatedDate: days(3), LastModifiedDate: days(1)},
+		{Id: "2", Owner: "Sam Engineer", Severity: "3 (Normal)", CreatedDate: days(30), LastModifiedDate: days(10)},
+		{Id: "3", Owner: "Sam Engineer", Severity: "3 (Normal)", CreatedDate: days(40), LastModifiedDate: days(20)},
+		{Id: "4", Owner: "Sam Engineer", Severity: "2 (High)", Status: "Closed", CreatedDate: days(90), LastModifiedDate: days(60)},
+		{Id: "5", Owner: "Robin Engineer", Severity: "2 (High)", CreatedDate: days(2), LastModifiedDate: days(2)},
+		{Id: "6", Severity: "4 (Low)", CreatedDate: days(5), LastModifiedDate: days(5)},
+	}
+	o := OwnerOptions{Aliases: []Alias{{Name: "Sam Engineer", Names: []string{"sengineer"}}}}
+
+	owners := ByOwner(cases, o, now)
+	require.Len(t, owners, 3)
+	assert.Equal(t, []string{"Sam Engineer", Unassigned, "Robin Engineer"}, []string{owners[0].Name, owners[1].Name, owners[2].Name},
+		"most open cases first, then by name")
+	sam := owners[0]
+	assert.Equal(t, 3, sam.Open, "closed cases are left out")
+	assert.Equal(t, map[string]int{"1": 1, "3": 2}, sam.Severities)
+	assert.Equal(t, 40*24*time.Hour, sam.Oldest)
+	require.Len(t, sam.Idle, 2)
+	assert.Equal(t, []string{"3", "2"}, []string{sam.Idle[0].Id, sam.Idle[1].Id}, "the longest idle first")
+	assert.Empty(t, owners[2].Idle)
+
+	o.IdleDays = 15
+	assert.Len(t, ByOwner(cases, o, now)[0].Idle, 1)
+	assert.Equal(t, []string{"1", "2", "3", "4"}, Levels(owners))
+}
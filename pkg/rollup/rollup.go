@@ -0,0 +1,107 @@
+// Package rollup sums up the open cases of each account and each owner, for the accounts and owners sections of the report
+package rollup
+
+import (
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/jwmatthews/case_watcher/pkg/sla"
+	"sort"
+)
+
+// Account is what an account has open
+type Account struct {
+	AccountNumber string
+	// Details are the cached details of the account, nil when they are not cached
+	Details *cache.Account
+	// Open counts the open cases, Severe those of severity 1 or 2 and Escalated those the customer escalated
+	Open      int
+	Severe    int
+	Escalated int
+	Cases     []cache.Case
+}
+
+// Name returns the name of the account, its number when its details are not cached
+func (a Account) Name() string {
+	if a.Details == nil || a.Details.Name == "" {
+		return a.AccountNumber
+	}
+	return a.Details.Name
+}
+
+// Strategic returns true when the cached details mark the account as strategic
+func (a Account) Strategic() bool {
+	return a.Details != nil && a.Details.Strategic
+}
+
+// IsSevere returns true for cases of severity 1 and 2, e.g. "1 (Urgent)"
+func IsSevere(c cache.Case) bool {
+	level := sla.Level(c.Severity)
+	return level == "1" || level == "2"
+}
+
+// ByAccount returns the open cases of each account, those with the most open cases first, details giving
+// the cached accounts by number. Closed cases and cases without an account are left out.
+func ByAccount(cases []cache.Case, details map[string]cache.Account) []Account {
+	byNumber := map[string]*Account{}
+	numbers := make([]string, 0)
+	for _, c := range cases {
+		if c.Status == "Closed" || c.AccountNumber == "" {
+			continue
+		}
+		a, ok := byNumber[c.AccountNumber]
+		if !ok {
+			a = &Account{AccountNumber: c.AccountNumber}
+			if d, ok := details[c.AccountNumber]; ok {
+				a.Details = &d
+			}
+			byNumber[c.AccountNumber] = a
+			numbers = append(numbers, c.AccountNumber)
+		}
+		a.Open++
+		if IsSevere(c) {
+			a.Severe++
+		}
+		if c.CustomerEscalation {
+			a.Escalated++
+		}
+		a.Cases = append(a.Cases, c)
+	}
+	result := make([]Account, 0, len(numbers))
+	for _, number := range numbers {
+		result = append(result, *byNumber[number])
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Open != result[j].Open {
+			return result[i].Open > result[j].Open
+		}
+		return result[i].AccountNumber < result[j].AccountNumber
+	})
+	return result
+}
+
+// Top returns the first n accounts, all of them when there are fewer
+func Top(accounts []Account, n int) []Account {
+	if n < len(accounts) {
+		return accounts[:n]
+	}
+	return accounts
+}
+
+// StrategicWithSevere returns the strategic accounts with open severity 1 or 2 cases
+func StrategicWithSevere(accounts []Account) []Account {
+	return filter(accounts, func(a Account) bool { return a.Strategic() && a.Severe > 0 })
+}
+
+// WithEscalations returns the accounts with open escalated cases
+func WithEscalations(accounts []Account) []Account {
+	return filter(accounts, func(a Account) bool { return a.Escalated > 0 })
+}
+
+func filter(accounts []Account, keep func(a Account) bool) []Account {
+	result := make([]Account, 0)
+	for _, a := range accounts {
+		if keep(a) {
+			result = append(result, a)
+		}
+	}
+	return result
+}
@@ -0,0 +1,41 @@
+package rollup
+
+import (
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestByAccount(t *testing.T) {
+	cases := []cache.Case{
+		{Id: "1", AccountNumber: "42", Severity: "1 (Urgent)", Status: "Waiting on Red Hat"},
+		{Id: "2", AccountNumber: "42", Severity: "3 (Normal)", Status: "Waiting on Customer", CustomerEscalation: true},
+		{Id: "3", AccountNumber: "42", Severity: "2 (High)", Status: "Closed", CustomerEscalation: true},
+		{Id: "4", AccountNumber: "7", Severity: "2 (High)", Status: "Waiting on Red Hat"},
+		{Id: "5", AccountNumber: "9", Severity: "4 (Low)", Status: "Waiting on Red Hat"},
+		{Id: "6", Severity: "1 (Urgent)", Status: "Waiting on Red Hat"},
+	}
+	details := map[string]cache.Account{
+		"42": {AccountNumber: "42", Name: "Example Corp", Strategic: true},
+		"7":  {AccountNumber: "7", Name: "Other Corp"},
+	}
+
+	accounts := ByAccount(cases, details)
+	require.Len(t, accounts, 3, "closed cases and cases without an account are left out")
+	assert.Equal(t, []string{"42", "7", "9"}, []string{accounts[0].AccountNumber, accounts[1].AccountNumber, accounts[2].AccountNumber},
+		"most open cases first, then by number")
+	assert.Equal(t, []int{2, 1, 1}, []int{accounts[0].Open, accounts[0].Severe, accounts[0].Escalated})
+	assert.Equal(t, "Example Corp", accounts[0].Name())
+	assert.Equal(t, "9", accounts[2].Name(), "not cached")
+	assert.False(t, accounts[2].Strategic())
+
+	assert.Len(t, Top(accounts, 2), 2)
+	assert.Len(t, Top(accounts, 10), 3)
+	strategic := StrategicWithSevere(accounts)
+	require.Len(t, strategic, 1)
+	assert.Equal(t, "42", strategic[0].AccountNumber)
+	escalated := WithEscalations(accounts)
+	require.Len(t, escalated, 1)
+	assert.Equal(t, "42", escalated[0].AccountNumber)
+}
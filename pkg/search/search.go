@@ -33,3 +33,39 @@ func Search(url, username, password, searchQuery, expression string) (*api.Respo
 
 	return resp, nil
 }
+
+// GetCases fetches cases individually by case number, e.g. to learn the final status of cases no longer
+// matching the search query. Cases which cannot be fetched are logged and left out.
+func GetCases(url, username, password string, caseNumbers []string, expression string) []api.Case {
+	client := api.NewClient(url, username, password)
+	ctx := context.Background()
+
+	cases := make([]api.Case, 0, len(caseNumbers))
+	for _, caseNumber := range caseNumbers {
+		theCase, err := client.GetCase(ctx, caseNumber, expression)
+		if err != nil {
+			log.Printf("Unable to fetch case %s:  %v\n", caseNumber, err)
+			continue
+		}
+		cases = append(cases, theCase)
+	}
+	return cases
+}
+
+// GetAccounts fetches the details of accounts by account number, e.g. of the accounts of cached cases.
+// Accounts which cannot be fetched are logged and left out.
+func GetAccounts(url, username, password string, accountNumbers []string) []api.Account {
+	client := api.NewClient(url, username, password)
+	ctx := context.Background()
+
+	accounts := make([]api.Account, 0, len(accountNumbers))
+	for _, accountNumber := range accountNumbers {
+		account, err := client.GetAccount(ctx, accountNumber)
+		if err != nil {
+			log.Printf("Unable to fetch account %s:  %v\n", accountNumber, err)
+			continue
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts
+}
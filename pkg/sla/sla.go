@@ -0,0 +1,261 @@
+// Package sla measures how long open cases have waited on us against response and update targets per severity
+package sla
+
+import (
+	"fmt"
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Status of a case against its target
+const (
+	// StatusNone is given when no target applies to the severity of the case
+	StatusNone     = ""
+	StatusOK       = "ok"
+	StatusAtRisk   = "at_risk"
+	StatusBreached = "breached"
+	// StatusPaused is given while the case waits on the customer
+	StatusPaused = "paused"
+	StatusClosed = "closed"
+)
+
+// What a target measures
+const (
+	// MeasureResponse is the time from creation to the first public update
+	MeasureResponse = "response"
+	// MeasureUpdate is the time since the last public update
+	MeasureUpdate = "update"
+)
+
+// DefaultAtRisk is the share of a target after which a case is at risk, when the Policy gives none
+const DefaultAtRisk = 0.75
+
+// DefaultPausedStatuses are the case statuses which stop the clock, when the Policy gives none
+var DefaultPausedStatuses = []string{"Waiting on Customer"}
+
+// Target is how soon a case needs its first public update, and then the next ones, in calendar time
+type Target struct {
+	Response time.Duration `mapstructure:"response"`
+	Update   time.Duration `mapstructure:"update"`
+}
+
+// Policy holds the targets by severity level, the digit severities start with, e.g. "2" for "2 (High)"
+type Policy struct {
+	Targets map[string]Target `mapstructure:"targets"`
+	// EnhancedTargets replace Targets for accounts with an enhanced SLA, severities they leave out use Targets
+	EnhancedTargets map[string]Target `mapstructure:"enhanced_targets"`
+	// AtRisk is the share of a target, e.g. 0.75, after which a case is at risk
+	AtRisk float64 `mapstructure:"at_risk"`
+	// PausedStatuses are the case statuses in which a case waits on the customer rather than on us
+	PausedStatuses []string `mapstructure:"paused_statuses"`
+}
+
+// Enabled returns true when the policy has targets
+func (p Policy) Enabled() bool {
+	return len(p.Targets) > 0 || len(p.EnhancedTargets) > 0
+}
+
+// Validate checks the targets are positive and AtRisk is a share of them
+func (p Policy) Validate() error {
+	for name, targets := range map[string]map[string]Target{"targets": p.Targets, "enhanced_targets": p.EnhancedTargets} {
+		for severity, t := range targets {
+			if t.Response < 0 || t.Update < 0 {
+				return fmt.Errorf("%s of severity %s: targets must not be negative", name, severity)
+			}
+		}
+	}
+	if p.AtRisk < 0 || p.AtRisk > 1 {
+		return fmt.Errorf("at_risk must be between 0 and 1, got %g", p.AtRisk)
+	}
+	return nil
+}
+
+func (p Policy) atRisk() float64 {
+	if p.AtRisk == 0 {
+		return DefaultAtRisk
+	}
+	return p.AtRisk
+}
+
+func (p Policy) paused(status string) bool {
+	statuses := p.PausedStatuses
+	if len(statuses) == 0 {
+		statuses = DefaultPausedStatuses
+	}
+	for _, s := range statuses {
+		if strings.EqualFold(s, status) {
+			return true
+		}
+	}
+	return false
+}
+
+// Level returns the severity level of a severity such as "2 (High)", the severity itself when it has no leading digit
+func Level(severity string) string {
+	severity = strings.TrimSpace(severity)
+	if severity != "" && severity[0] >= '0' && severity[0] <= '9' {
+		return severity[:1]
+	}
+	return severity
+}
+
+// target returns the target of a severity for the account, and whether there is one
+func (p Policy) target(severity string, a cache.Account) (Target, bool) {
+	level := Level(severity)
+	if a.HasEnhancedSLA {
+		if t, ok := p.EnhancedTargets[level]; ok {
+			return t, true
+		}
+	}
+	t, ok := p.Targets[level]
+	return t, ok
+}
+
+// Evaluation is where a case stands against its target
+type Evaluation struct {
+	// Age is the time since the case was created
+	Age time.Duration
+	// SinceUpdate is the time since the last public update, the Age when there was none
+	SinceUpdate time.Duration
+	// CustomerUpdate is true when the last public update came from the customer, the case waiting on our reply since
+	CustomerUpdate bool
+	// Waiting is true while the case waits on us, i.e. it is open and not in a paused status
+	Waiting bool
+	// Measure is MeasureResponse until the case had a public update and MeasureUpdate afterwards
+	Measure string
+	// Target is the time allowed for the Measure, 0 when there is no target
+	Target time.Duration
+	// Elapsed is the time counted against the Target
+	Elapsed time.Duration
+	Status  string
+}
+
+// Remaining returns the time left before the target is breached, negative once it is
+func (e Evaluation) Remaining() time.Duration {
+	return e.Target - e.Elapsed
+}
+
+// updatedByCustomer returns true when the last public update of the case came from its contact or creator
+func updatedByCustomer(c cache.Case) bool {
+	return c.LastPublicUpdateBy != "" &&
+		(strings.EqualFold(c.LastPublicUpdateBy, c.ContactName) || strings.EqualFold(c.LastPublicUpdateBy, c.CreatedByName))
+}
+
+// Evaluate measures a case against the target for its severity, a being the account of the case
+func (p Policy) Evaluate(c cache.Case, a cache.Account, now time.Time) Evaluation {
+	e := Evaluation{Age: now.Sub(c.CreatedDate), Measure: MeasureResponse}
+	e.SinceUpdate = e.Age
+	// Only the last public update is known: after ours the next one is due within the update target, and after
+	// the customer's our reply is, so both are measured from the update
+	if !c.LastPublicUpdateDate.IsZero() && c.LastPublicUpdateDate.After(c.CreatedDate) {
+		e.SinceUpdate = now.Sub(c.LastPublicUpdateDate)
+		e.Measure = MeasureUpdate
+		e.CustomerUpdate = updatedByCustomer(c)
+	}
+	e.Elapsed = e.SinceUpdate
+	switch {
+	case c.Status == "Closed":
+		e.Status = StatusClosed
+		return e
+	case p.paused(c.Status):
+		e.Status = StatusPaused
+		return e
+	}
+	e.Waiting = true
+
+	t, ok := p.target(c.Severity, a)
+	if ok && e.Measure == MeasureResponse {
+		e.Target = t.Response
+	} else if ok {
+		e.Target = t.Update
+	}
+	switch {
+	case e.Target <= 0:
+		e.Status = StatusNone
+	case e.Elapsed >= e.Target:
+		e.Status = StatusBreached
+	case float64(e.Elapsed) >= float64(e.Target)*p.atRisk():
+		e.Status = StatusAtRisk
+	default:
+		e.Status = StatusOK
+	}
+	return e
+}
+
+// CaseEvaluation is a case along with its Evaluation
+type CaseEvaluation struct {
+	Case cache.Case
+	Evaluation
+}
+
+// Overdue returns the cases breaching or at risk of breaching their target, those with the least time left first
+func (p Policy) Overdue(cases []cache.Case, accounts map[string]cache.Account, now time.Time) []CaseEvaluation {
+	overdue := make([]CaseEvaluation, 0)
+	for _, c := range cases {
+		e := p.Evaluate(c, accounts[c.AccountNumber], now)
+		if e.Status == StatusBreached || e.Status == StatusAtRisk {
+			overdue = append(overdue, CaseEvaluation{Case: c, Evaluation: e})
+		}
+	}
+	sort.SliceStable(overdue, func(i, j int) bool {
+		return overdue[i].Remaining() < overdue[j].Remaining()
+	})
+	return overdue
+}
+
+// Aging sums up, for a severity level, the open cases waiting on us
+type Aging struct {
+	Level    string
+	Waiting  int
+	Breached int
+	AtRisk   int
+	// Longest is the longest time one of the cases has waited since its last public update
+	Longest time.Duration
+	// Oldest is the age of the oldest of the cases
+	Oldest time.Duration
+}
+
+// Aging returns the Aging of each severity level among the cases waiting on us, by level
+func (p Policy) Aging(cases []cache.Case, accounts map[string]cache.Account, now time.Time) []Aging {
+	byLevel := map[string]*Aging{}
+	levels := make([]string, 0)
+	for _, c := range cases {
+		e := p.Evaluate(c, accounts[c.AccountNumber], now)
+		if !e.Waiting {
+			continue
+		}
+		level := Level(c.Severity)
+		a, ok := byLevel[level]
+		if !ok {
+			a = &Aging{Level: level}
+			byLevel[level] = a
+			levels = append(levels, level)
+		}
+		a.Waiting++
+		switch e.Status {
+		case StatusBreached:
+			a.Breached++
+		case StatusAtRisk:
+			a.AtRisk++
+		}
+		if e.SinceUpdate > a.Longest {
+			a.Longest = e.SinceUpdate
+		}
+		if e.Age > a.Oldest {
+			a.Oldest = e.Age
+		}
+	}
+	sort.Strings(levels)
+	aging := make([]Aging, 0, len(levels))
+	for _, level := range levels {
+		aging = append(aging, *byLevel[level])
+	}
+	return aging
+}
+
+// Hours returns a duration in hours rounded to a tenth, how durations are shown in reports and spreadsheets
+func Hours(d time.Duration) float64 {
+	return float64(d.Round(6*time.Minute)) / float64(time.Hour)
+}
@@ -0,0 +1,110 @@
+package sla
+
+import (
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2022, 3, 10, 12, 0, 0, 0, time.UTC)
+
+var policy = Policy{
+	Targets: map[string]Target{
+		"1": {Response: time.Hour, Update: 4 * time.Hour},
+		"2": {Response: 4 * time.Hour, Update: 24 * time.Hour},
+	},
+	EnhancedTargets: map[string]Target{"2": {Response: 2 * time.Hour, Update: 8 * time.Hour}},
+}
+
+func TestLevel(t *testing.T) {
+	assert.Equal(t, "2", Level("2 (High)"))
+	assert.Equal(t, "1", Level(" 1 (Urgent)"))
+	assert.Equal(t, "Urgent", Level("Urgent"))
+	assert.Equal(t, "", Level(""))
+}
+
+func TestEvaluate(t *testing.T) {
+	created := now.Add(-30 * time.Hour)
+	tests := []struct {
+		name    string
+		c       cache.Case
+		a       cache.Account
+		measure string
+		elapsed time.Duration
+		status  string
+	}{
+		{"no response yet", cache.Case{Severity: "1 (Urgent)", Status: "Waiting on Red Hat", CreatedDate: now.Add(-50 * time.Minute)},
+			cache.Account{}, MeasureResponse, 50 * time.Minute, StatusAtRisk},
+		{"updated recently", cache.Case{Severity: "2 (High)", Status: "Waiting on Red Hat", CreatedDate: created,
+			LastPublicUpdateDate: now.Add(-3 * time.Hour), LastPublicUpdateBy: "Sam Engineer"}, cache.Account{}, MeasureUpdate, 3 * time.Hour, StatusOK},
+		{"updated by the customer", cache.Case{Severity: "2 (High)", Status: "Waiting on Red Hat", CreatedDate: created,
+			ContactName: "Pat Example", LastPublicUpdateDate: now.Add(-time.Hour), LastPublicUpdateBy: "Pat Example"},
+			cache.Account{}, MeasureUpdate, time.Hour, StatusOK},
+		{"updated by the creator", cache.Case{Severity: "2 (High)", Status: "Waiting on Red Hat", CreatedDate: created,
+			ContactName: "Pat Example", CreatedByName: "Alex Example", LastPublicUpdateDate: now.Add(-20 * time.Hour),
+			LastPublicUpdateBy: "alex example"}, cache.Account{}, MeasureUpdate, 20 * time.Hour, StatusAtRisk},
+		{"updated by us", cache.Case{Severity: "2 (High)", Status: "Waiting on Red Hat", CreatedDate: created,
+			ContactName: "Pat Example", LastPublicUpdateDate: now.Add(-time.Hour), LastPublicUpdateBy: "Sam Engineer"},
+			cache.Account{}, MeasureUpdate, time.Hour, StatusOK},
+		{"enhanced SLA", cache.Case{Severity: "2 (High)", Status: "Waiting on Red Hat", CreatedDate: created,
+			LastPublicUpdateDate: now.Add(-9 * time.Hour), LastPublicUpdateBy: "Sam Engineer"}, cache.Account{HasEnhancedSLA: true}, MeasureUpdate, 9 * time.Hour, StatusBreached},
+		{"enhanced without its own target", cache.Case{Severity: "1 (Urgent)", Status: "Waiting on Red Hat", CreatedDate: created,
+			LastPublicUpdateDate: now.Add(-5 * time.Hour), LastPublicUpdateBy: "Sam Engineer"}, cache.Account{HasEnhancedSLA: true}, MeasureUpdate, 5 * time.Hour, StatusBreached},
+		{"waiting on the customer", cache.Case{Severity: "1 (Urgent)", Status: "Waiting on Customer", CreatedDate: created},
+			cache.Account{}, MeasureResponse, 30 * time.Hour, StatusPaused},
+		{"closed", cache.Case{Severity: "1 (Urgent)", Status: "Closed", CreatedDate: created}, cache.Account{}, MeasureResponse, 30 * time.Hour, StatusClosed},
+		{"no target", cache.Case{Severity: "4 (Low)", Status: "Waiting on Red Hat", CreatedDate: created}, cache.Account{}, MeasureResponse, 30 * time.Hour, StatusNone},
+	}
+	for _, test := range tests {
+		e := policy.Evaluate(test.c, test.a, now)
+		assert.Equal(t, test.measure, e.Measure, test.name)
+		assert.Equal(t, test.elapsed, e.Elapsed, test.name)
+		assert.Equal(t, test.status, e.Status, test.name)
+	}
+
+	for i, customer := range map[int]bool{1: false, 2: true, 3: true, 4: false} {
+		assert.Equal(t, customer, policy.Evaluate(tests[i].c, tests[i].a, now).CustomerUpdate, tests[i].name)
+	}
+
+	e := policy.Evaluate(tests[1].c, cache.Account{}, now)
+	assert.Equal(t, 30*time.Hour, e.Age)
+	assert.Equal(t, 21*time.Hour, e.Remaining())
+	assert.True(t, e.Waiting)
+}
+
+func TestOverdueAndAging(t *testing.T) {
+	cases := []cache.Case{
+		{Id: "a", Severity: "2 (High)", Status: "Waiting on Red Hat", CreatedDate: now.Add(-48 * time.Hour), LastPublicUpdateDate: now.Add(-20 * time.Hour),
+			LastPublicUpdateBy: "Sam Engineer"},
+		{Id: "b", Severity: "2 (High)", Status: "Waiting on Red Hat", CreatedDate: now.Add(-72 * time.Hour), LastPublicUpdateDate: now.Add(-30 * time.Hour),
+			LastPublicUpdateBy: "Sam Engineer"},
+		{Id: "c", Severity: "2 (High)", Status: "Waiting on Customer", CreatedDate: now.Add(-99 * time.Hour)},
+		{Id: "d", Severity: "1 (Urgent)", Status: "Waiting on Red Hat", CreatedDate: now.Add(-10 * time.Minute), AccountNumber: "42"},
+	}
+	overdue := policy.Overdue(cases, nil, now)
+	require.Len(t, overdue, 2)
+	assert.Equal(t, "b", overdue[0].Case.Id, "the most overdue first")
+	assert.Equal(t, StatusBreached, overdue[0].Status)
+	assert.Equal(t, "a", overdue[1].Case.Id)
+	assert.Equal(t, StatusAtRisk, overdue[1].Status)
+
+	assert.Equal(t, []Aging{
+		{Level: "1", Waiting: 1, Longest: 10 * time.Minute, Oldest: 10 * time.Minute},
+		{Level: "2", Waiting: 2, Breached: 1, AtRisk: 1, Longest: 30 * time.Hour, Oldest: 72 * time.Hour},
+	}, policy.Aging(cases, nil, now), "cases waiting on the customer are left out")
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	assert.NoError(t, policy.Validate())
+	assert.False(t, Policy{}.Enabled())
+	assert.Error(t, Policy{AtRisk: 1.5}.Validate())
+	assert.Error(t, Policy{Targets: map[string]Target{"1": {Response: -time.Hour}}}.Validate())
+}
+
+func TestHours(t *testing.T) {
+	assert.Equal(t, 1.5, Hours(90*time.Minute))
+	assert.Equal(t, 0.3, Hours(17*time.Minute))
+	assert.Equal(t, -2.0, Hours(-2*time.Hour))
+}
@@ -0,0 +1,186 @@
+package spreadsheet
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"gorm.io/gorm"
+	"log"
+	"strings"
+)
+
+// AnnotationColumns names the header of each column the team annotates, columns left empty are not read
+type AnnotationColumns struct {
+	// Id is the header of the column holding the case Id, IdHeader when empty
+	Id string `mapstructure:"id"`
+	// Relevance holds e.g. 'ours' or 'not ours', matched against RelevantValues and IgnoredValues
+	Relevance string `mapstructure:"relevance"`
+	Area      string `mapstructure:"area"`
+	Notes     string `mapstructure:"notes"`
+	Assignee  string `mapstructure:"assignee"`
+	// RelevantValues and IgnoredValues replace DefaultRelevantValues and DefaultIgnoredValues when given
+	RelevantValues []string `mapstructure:"relevant_values"`
+	IgnoredValues  []string `mapstructure:"ignored_values"`
+}
+
+// Values of the relevance column understood unless AnnotationColumns lists its own, compared ignoring case
+var (
+	DefaultRelevantValues = []string{"ours", "yes", "y", "relevant"}
+	DefaultIgnoredValues  = []string{"not ours", "no", "n", "ignored"}
+)
+
+// Annotation is what the team noted about a case in a row of the spreadsheet
+type Annotation struct {
+	Id    string
+	Sheet string
+	// Row is 1 based, as shown in the spreadsheet
+	Row int
+	// Relevance is cache.RelevanceRelevant, cache.RelevanceIgnored or cache.RelevanceUnknown when not given
+	Relevance string
+	Area      string
+	Notes     string
+	Assignee  string
+}
+
+// PullSummary lists the ids of the cases whose triage or annotations were changed by a pull,
+// Unknown the ids found in the spreadsheet but not in the cache
+type PullSummary struct {
+	Triaged   []string
+	Annotated []string
+	Unknown   []string
+}
+
+// Pull reads the annotation columns of the given tabs and stores them in the cache, see ApplyAnnotations
+func Pull(sink Sink, spreadsheetId string, sheetNames []string, cols AnnotationColumns, s cache.Store) (PullSummary, error) {
+	annotations := make([]Annotation, 0)
+	for _, sheetName := range sheetNames {
+		rows, err := readSheet(sink, spreadsheetId, sheetName)
+		if err != nil {
+			return PullSummary{}, err
+		}
+		found, err := ParseAnnotations(sheetName, rows, cols)
+		if err != nil {
+			return PullSummary{}, err
+		}
+		annotations = append(annotations, found...)
+	}
+	return ApplyAnnotations(s, annotations, cols)
+}
+
+// ParseAnnotations reads the annotations from the rows of a tab, the first row being the header.
+// Rows without a case Id are skipped, as are relevance values which are not understood.
+func ParseAnnotations(sheetName string, rows [][]interface{}, cols AnnotationColumns) ([]Annotation, error) {
+	annotations := make([]Annotation, 0)
+	if len(rows) == 0 {
+		return annotations, nil
+	}
+	column := map[string]int{}
+	for i, name := range rows[0] {
+		column[strings.TrimSpace(fmt.Sprint(name))] = i
+	}
+	idHeader := cols.Id
+	if idHeader == "" {
+		idHeader = IdHeader
+	}
+	idColumn, ok := column[idHeader]
+	if !ok {
+		return nil, fmt.Errorf("tab '%s' has no '%s' column", sheetName, idHeader)
+	}
+	cell := func(row []interface{}, header string) string {
+		i, ok := column[header]
+		if header == "" || !ok {
+			return ""
+		}
+		return strings.TrimSpace(cellString(row, i))
+	}
+	for _, header := range []string{cols.Relevance, cols.Area, cols.Notes, cols.Assignee} {
+		if _, ok := column[header]; header != "" && !ok {
+			return nil, fmt.Errorf("tab '%s' has no '%s' column", sheetName, header)
+		}
+	}
+
+	for i, row := range rows[1:] {
+		id := strings.TrimSpace(cellString(row, idColumn))
+		if id == "" {
+			continue
+		}
+		a := Annotation{
+			Id:       id,
+			Sheet:    sheetName,
+			Row:      i + 2,
+			Area:     cell(row, cols.Area),
+			Notes:    cell(row, cols.Notes),
+			Assignee: cell(row, cols.Assignee),
+		}
+		value := cell(row, cols.Relevance)
+		switch {
+		case value == "":
+		case matchesAny(value, cols.RelevantValues, DefaultRelevantValues):
+			a.Relevance = cache.RelevanceRelevant
+		case matchesAny(value, cols.IgnoredValues, DefaultIgnoredValues):
+			a.Relevance = cache.RelevanceIgnored
+		default:
+			log.Printf("Ignoring '%s' in %s row %d, it is neither relevant nor ignored\n", value, sheetName, a.Row)
+		}
+		annotations = append(annotations, a)
+	}
+	return annotations, nil
+}
+
+// matchesAny compares value ignoring case with values, or with defaults when values is empty
+func matchesAny(value string, values, defaults []string) bool {
+	if len(values) == 0 {
+		values = defaults
+	}
+	for _, v := range values {
+		if strings.EqualFold(value, strings.TrimSpace(v)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyAnnotations stores annotations in the cache. A relevance is recorded as manual triage, keeping the
+// cached area unless an area column is read. Notes and assignee are replaced when their column is read,
+// an empty cell clearing them. Nothing is written for a case already up to date.
+func ApplyAnnotations(s cache.Store, annotations []Annotation, cols AnnotationColumns) (PullSummary, error) {
+	summary := PullSummary{}
+	for _, a := range annotations {
+		c, err := s.GetCase(a.Id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			summary.Unknown = append(summary.Unknown, a.Id)
+			continue
+		}
+		if err != nil {
+			return summary, err
+		}
+		if a.Relevance != cache.RelevanceUnknown {
+			area := c.Area
+			if cols.Area != "" {
+				area = a.Area
+			}
+			if c.ClassifiedBy != cache.ClassifiedManually || c.Relevance != a.Relevance || c.Area != area {
+				err = s.SetTriage(c.Id, a.Relevance, area)
+				if err != nil {
+					return summary, err
+				}
+				summary.Triaged = append(summary.Triaged, c.Id)
+			}
+		}
+		notes, assignee := c.Notes, c.Assignee
+		if cols.Notes != "" {
+			notes = a.Notes
+		}
+		if cols.Assignee != "" {
+			assignee = a.Assignee
+		}
+		if notes != c.Notes || assignee != c.Assignee {
+			err = s.SetAnnotations(c.Id, notes, assignee)
+			if err != nil {
+				return summary, err
+			}
+			summary.Annotated = append(summary.Annotated, c.Id)
+		}
+	}
+	return summary, nil
+}
@@ -0,0 +1,107 @@
+package spreadsheet
+
+import (
+	"github.com/jwmatthews/case_watcher/pkg/api"
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var annotationColumns = AnnotationColumns{Relevance: "Ours?", Notes: "Notes", Assignee: "Assignee"}
+
+func TestParseAnnotations(t *testing.T) {
+	rows := [][]interface{}{
+		{"Id", "Status", "Ours?", "Notes", "Assignee"},
+		{"1", "Open", "Ours", "needs a fix", "alice"},
+		{"2", "Open", " not ours "},
+		{"3", "Open", "maybe", "", "bob"},
+		{"", "", "ours", "a note below the table"},
+	}
+	annotations, err := ParseAnnotations("Open Cases", rows, annotationColumns)
+	require.NoError(t, err)
+	assert.Equal(t, []Annotation{
+		{Id: "1", Sheet: "Open Cases", Row: 2, Relevance: cache.RelevanceRelevant, Notes: "needs a fix", Assignee: "alice"},
+		{Id: "2", Sheet: "Open Cases", Row: 3, Relevance: cache.RelevanceIgnored},
+		{Id: "3", Sheet: "Open Cases", Row: 4, Assignee: "bob"},
+	}, annotations)
+
+	custom := annotationColumns
+	custom.RelevantValues = []string{"maybe"}
+	annotations, err = ParseAnnotations("Open Cases", rows, custom)
+	require.NoError(t, err)
+	assert.Equal(t, cache.RelevanceUnknown, annotations[0].Relevance, "custom values replace the defaults")
+	assert.Equal(t, cache.RelevanceRelevant, annotations[2].Relevance)
+
+	relabelled := AnnotationColumns{Id: "Case Id", Relevance: "Ours?"}
+	annotations, err = ParseAnnotations("Open Cases", [][]interface{}{{"Case Id", "Ours?"}, {"7", "ours"}}, relabelled)
+	require.NoError(t, err)
+	assert.Equal(t, []Annotation{{Id: "7", Sheet: "Open Cases", Row: 2, Relevance: cache.RelevanceRelevant}}, annotations)
+
+	_, err = ParseAnnotations("Open Cases", [][]interface{}{{"Id", "Status"}}, annotationColumns)
+	assert.Error(t, err, "a configured column missing from the tab is an error")
+	_, err = ParseAnnotations("Open Cases", [][]interface{}{{"Status", "Ours?"}}, annotationColumns)
+	assert.Error(t, err)
+}
+
+func TestPull(t *testing.T) {
+	c, err := cache.Init(cache.MemoryDSN)
+	require.NoError(t, err)
+	defer c.Close()
+	_, err = c.StoreCases([]api.Case{{Id: "1", Status: "Open"}, {Id: "2", Status: "Open"}, {Id: "3", Status: "Closed"}})
+	require.NoError(t, err)
+	require.NoError(t, c.SetTriage("2", cache.RelevanceRelevant, "storage"))
+
+	server, sink := newTestSink(t, "sheet-id")
+	server.AddSheet("sheet-id", OpenCasesSheet, [][]interface{}{
+		{"Id", "Status", "Ours?", "Notes", "Assignee"},
+		{"1", "Open", "ours", "needs a fix", "alice"},
+		{"2", "Open", "not ours"},
+		{"9", "Open", "ours"},
+	})
+	server.AddSheet("sheet-id", ClosedCasesSheet, [][]interface{}{
+		{"Id", "Status", "Ours?", "Notes", "Assignee"},
+		{"3", "Closed", "", "fixed in 1.2"},
+	})
+	sheetNames := []string{OpenCasesSheet, ClosedCasesSheet}
+	summary, err := Pull(sink, "sheet-id", sheetNames, annotationColumns, &c)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, summary.Triaged)
+	assert.Equal(t, []string{"1", "3"}, summary.Annotated)
+	assert.Equal(t, []string{"9"}, summary.Unknown)
+
+	one, err := c.GetCase("1")
+	require.NoError(t, err)
+	assert.Equal(t, cache.RelevanceRelevant, one.Relevance)
+	assert.Equal(t, cache.ClassifiedManually, one.ClassifiedBy)
+	assert.Equal(t, "needs a fix", one.Notes)
+	assert.Equal(t, "alice", one.Assignee)
+	two, err := c.GetCase("2")
+	require.NoError(t, err)
+	assert.Equal(t, cache.RelevanceIgnored, two.Relevance)
+	assert.Equal(t, "storage", two.Area, "the area is kept without an area column")
+	three, err := c.GetCase("3")
+	require.NoError(t, err)
+	assert.Equal(t, cache.RelevanceUnknown, three.Relevance)
+	assert.Equal(t, "fixed in 1.2", three.Notes)
+
+	// Pulling again changes nothing, and annotations survive the next search
+	summary, err = Pull(sink, "sheet-id", sheetNames, annotationColumns, &c)
+	require.NoError(t, err)
+	assert.Empty(t, summary.Triaged)
+	assert.Empty(t, summary.Annotated)
+	_, err = c.StoreCases([]api.Case{{Id: "1", Status: "Waiting on Customer"}})
+	require.NoError(t, err)
+	one, err = c.GetCase("1")
+	require.NoError(t, err)
+	assert.Equal(t, "needs a fix", one.Notes)
+	assert.Equal(t, "alice", one.Assignee)
+}
+
+func TestApplyAnnotations_CacheErrors(t *testing.T) {
+	c, err := cache.Init(cache.MemoryDSN)
+	require.NoError(t, err)
+	require.NoError(t, c.Close())
+	_, err = ApplyAnnotations(&c, []Annotation{{Id: "1", Relevance: cache.RelevanceRelevant}}, annotationColumns)
+	assert.Error(t, err, "only a case missing from the cache is unknown")
+}
@@ -0,0 +1,135 @@
+package spreadsheet
+
+import (
+	"fmt"
+	"github.com/jwmatthews/case_watcher/pkg/sla"
+	"google.golang.org/api/sheets/v4"
+	"log"
+)
+
+// Background colors of the conditional formatting
+var (
+	severity1Color  = &sheets.Color{Red: 0.96, Green: 0.78, Blue: 0.76}
+	severity2Color  = &sheets.Color{Red: 0.99, Green: 0.90, Blue: 0.70}
+	escalationColor = &sheets.Color{Red: 0.85, Green: 0.80, Blue: 0.95}
+)
+
+// ColumnLetter returns the A1 notation of a 0 based column index, e.g. 0 is A and 26 is AA
+func ColumnLetter(i int) string {
+	letters := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		letters = string(rune('A'+(i-1)%26)) + letters
+	}
+	return letters
+}
+
+// conditionalRule colors the rows of a range, below the header, for which formula is true.
+// The formula is written for the first row, i.e. row 2.
+func conditionalRule(sheetId int64, start, end int, formula string, color *sheets.Color) *sheets.ConditionalFormatRule {
+	return &sheets.ConditionalFormatRule{
+		Ranges: []*sheets.GridRange{{SheetId: sheetId, StartRowIndex: 1, StartColumnIndex: int64(start), EndColumnIndex: int64(end)}},
+		BooleanRule: &sheets.BooleanRule{
+			Condition: &sheets.BooleanCondition{
+				Type:   "CUSTOM_FORMULA",
+				Values: []*sheets.ConditionValue{{UserEnteredValue: formula}},
+			},
+			Format: &sheets.CellFormat{BackgroundColor: color},
+		},
+	}
+}
+
+// ruleFormula returns the custom formula of a conditional format rule, empty when it has none
+func ruleFormula(rule *sheets.ConditionalFormatRule) string {
+	if rule.BooleanRule == nil || rule.BooleanRule.Condition == nil || len(rule.BooleanRule.Condition.Values) == 0 {
+		return ""
+	}
+	return rule.BooleanRule.Condition.Values[0].UserEnteredValue
+}
+
+// formatRequests returns the requests formatting a tab written with the layout. Conditional format rules with
+// the same formula as one of ours are taken to be ours and not added again, rules the team added are left alone.
+func formatRequests(sheetId int64, layout Layout, existing []*sheets.ConditionalFormatRule) []*sheets.Request {
+	columns := len(layout.columns())
+	requests := []*sheets.Request{
+		{UpdateSheetProperties: &sheets.UpdateSheetPropertiesRequest{
+			Properties: &sheets.SheetProperties{SheetId: sheetId, GridProperties: &sheets.GridProperties{FrozenRowCount: 1}},
+			Fields:     "gridProperties.frozenRowCount",
+		}},
+		{RepeatCell: &sheets.RepeatCellRequest{
+			Range:  &sheets.GridRange{SheetId: sheetId, StartRowIndex: 0, EndRowIndex: 1, StartColumnIndex: 0, EndColumnIndex: int64(columns)},
+			Cell:   &sheets.CellData{UserEnteredFormat: &sheets.CellFormat{TextFormat: &sheets.TextFormat{Bold: true}}},
+			Fields: "userEnteredFormat.textFormat.bold",
+		}},
+		// The filter spans every column, the team's included, so sorting keeps their notes with the case
+		{SetBasicFilter: &sheets.SetBasicFilterRequest{
+			Filter: &sheets.BasicFilter{Range: &sheets.GridRange{SheetId: sheetId, StartColumnIndex: 0}},
+		}},
+	}
+
+	rules := make([]*sheets.ConditionalFormatRule, 0)
+	if i, _ := layout.column("severity"); i >= 0 {
+		// Severities read e.g. '1 (Urgent)'
+		cell := fmt.Sprintf("$%s2", ColumnLetter(i))
+		rules = append(rules,
+			conditionalRule(sheetId, i, i+1, fmt.Sprintf("=LEFT(%s,1)=\"1\"", cell), severity1Color),
+			conditionalRule(sheetId, i, i+1, fmt.Sprintf("=LEFT(%s,1)=\"2\"", cell), severity2Color))
+	}
+	if i, _ := layout.column("escalated"); i >= 0 {
+		rules = append(rules, conditionalRule(sheetId, 0, columns, fmt.Sprintf("=$%s2=TRUE", ColumnLetter(i)), escalationColor))
+	}
+	if i, _ := layout.column("sla_status"); i >= 0 {
+		cell := fmt.Sprintf("$%s2", ColumnLetter(i))
+		rules = append(rules,
+			conditionalRule(sheetId, i, i+1, fmt.Sprintf("=%s=\"%s\"", cell, sla.StatusBreached), severity1Color),
+			conditionalRule(sheetId, i, i+1, fmt.Sprintf("=%s=\"%s\"", cell, sla.StatusAtRisk), severity2Color))
+	}
+	present := map[string]bool{}
+	for _, rule := range existing {
+		present[ruleFormula(rule)] = true
+	}
+	index := int64(len(existing))
+	for _, rule := range rules {
+		if present[ruleFormula(rule)] {
+			continue
+		}
+		requests = append(requests, &sheets.Request{
+			AddConditionalFormatRule: &sheets.AddConditionalFormatRuleRequest{Rule: rule, Index: index},
+		})
+		index++
+	}
+
+	requests = append(requests, &sheets.Request{
+		AutoResizeDimensions: &sheets.AutoResizeDimensionsRequest{
+			Dimensions: &sheets.DimensionRange{SheetId: sheetId, Dimension: "COLUMNS", StartIndex: 0},
+		},
+	})
+	return requests
+}
+
+// formatSheets applies formatRequests to tabs by name, nothing is done unless the layout asks for Formatting
+func formatSheets(sink Sink, spreadsheetId string, layout Layout, sheetNames ...string) error {
+	if !layout.Formatting {
+		return nil
+	}
+	tabs, err := sink.Sheets(spreadsheetId)
+	if err != nil {
+		return fmt.Errorf("unable to read spreadsheet %s: %w", spreadsheetId, err)
+	}
+	requests := make([]*sheets.Request, 0)
+	for _, name := range sheetNames {
+		for _, sheet := range tabs {
+			if sheet.Properties.Title == name {
+				requests = append(requests, formatRequests(sheet.Properties.SheetId, layout, sheet.ConditionalFormats)...)
+			}
+		}
+	}
+	if len(requests) == 0 {
+		return nil
+	}
+	err = sink.Apply(spreadsheetId, requests)
+	if err != nil {
+		log.Printf("Error, failed to format spreadsheet: %s, received error: %s\n", spreadsheetId, err)
+		return err
+	}
+	return nil
+}
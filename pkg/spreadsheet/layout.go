@@ -0,0 +1,287 @@
+package spreadsheet
+
+import (
+	"fmt"
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/jwmatthews/case_watcher/pkg/sla"
+	"strings"
+	"time"
+)
+
+// Report holds the cases written to the spreadsheet, see BuildReport.
+// Accounts by account number is only needed for account fields.
+type Report struct {
+	OpenCases   []cache.Case
+	ClosedCases []cache.Case
+	Accounts    map[string]cache.Account
+	// ActiveCases were modified in the past ActiveDays, nil leaves the tab out
+	ActiveCases []cache.Case
+	ActiveDays  int
+	// ProductCases are the open cases by product name, nil leaves the product tabs out
+	ProductCases map[string][]cache.Case
+	// Summary are the rows of the summary tab, nil leaves it out
+	Summary [][]interface{}
+}
+
+// Formula is a cell holding a formula, e.g. =hyperlink("..."). Only cells of this type are written as formulas
+// to workbooks, other strings are text whatever they start with.
+type Formula string
+
+// field is a value of a case which can be written to a column
+type field struct {
+	Name   string
+	Header string
+	// Value returns the cell, a time.Time is written with the DateFormat of the Layout
+	Value func(c cache.Case, a cache.Account) interface{}
+}
+
+var fields = []field{
+	{"uri", "Uri", func(c cache.Case, a cache.Account) interface{} {
+		return Formula(fmt.Sprintf("=hyperlink(\"%s\")", strings.ReplaceAll(c.Uri, "\"", "\"\"")))
+	}},
+	{"number", "CaseNumber", func(c cache.Case, a cache.Account) interface{} { return c.CaseNumber }},
+	{"severity", "Severity", func(c cache.Case, a cache.Account) interface{} { return c.Severity }},
+	{"id", IdHeader, func(c cache.Case, a cache.Account) interface{} { return c.Id }},
+	{"status", "Status", func(c cache.Case, a cache.Account) interface{} { return c.Status }},
+	{"summary", "Summary", func(c cache.Case, a cache.Account) interface{} { return c.Summary }},
+	{"type", "Type", func(c cache.Case, a cache.Account) interface{} { return c.Type }},
+	{"owner", "Owner", func(c cache.Case, a cache.Account) interface{} { return c.Owner }},
+	{"contact", "ContactName", func(c cache.Case, a cache.Account) interface{} { return c.ContactName }},
+	{"created_by", "CreatedByName", func(c cache.Case, a cache.Account) interface{} { return c.CreatedByName }},
+	{"created", "CreatedDate", func(c cache.Case, a cache.Account) interface{} { return c.CreatedDate }},
+	{"modified_by", "LastModifiedByName", func(c cache.Case, a cache.Account) interface{} { return c.LastModifiedByName }},
+	{"modified", "LastModifiedDate", func(c cache.Case, a cache.Account) interface{} { return c.LastModifiedDate }},
+	{"last_public_update", "LastPublicUpdateDate", func(c cache.Case, a cache.Account) interface{} { return c.LastPublicUpdateDate }},
+	{"escalated", "CustomerEscalation", func(c cache.Case, a cache.Account) interface{} { return c.CustomerEscalation }},
+	{"products", "Products", func(c cache.Case, a cache.Account) interface{} {
+		names := make([]string, 0, len(c.Products))
+		for _, p := range c.Products {
+			names = append(names, p.Name)
+		}
+		return strings.Join(names, ", ")
+	}},
+	{"version", "Version", func(c cache.Case, a cache.Account) interface{} { return c.Version }},
+	{"account", "AccountNumber", func(c cache.Case, a cache.Account) interface{} { return c.AccountNumber }},
+	{"account_name", "AccountName", func(c cache.Case, a cache.Account) interface{} { return a.Name }},
+	{"account_tam", "TAM", func(c cache.Case, a cache.Account) interface{} { return a.HasTAM }},
+	{"account_strategic", "Strategic", func(c cache.Case, a cache.Account) interface{} { return a.Strategic }},
+	{"account_csm", "CSM", func(c cache.Case, a cache.Account) interface{} { return a.CSMUserName }},
+	{"relevance", "Relevance", func(c cache.Case, a cache.Account) interface{} { return c.Relevance }},
+	{"area", "Area", func(c cache.Case, a cache.Account) interface{} { return c.Area }},
+	{"assignee", "Assignee", func(c cache.Case, a cache.Account) interface{} { return c.Assignee }},
+	{"notes", "Notes", func(c cache.Case, a cache.Account) interface{} { return c.Notes }},
+}
+
+// slaField is a field measured against the SLA policy of the Layout
+type slaField struct {
+	Name   string
+	Header string
+	// Evaluated returns the cell from the Evaluation of the case
+	Evaluated func(e sla.Evaluation) interface{}
+}
+
+var slaFields = []slaField{
+	{Name: "sla_status", Header: "SLAStatus", Evaluated: func(e sla.Evaluation) interface{} { return e.Status }},
+	{Name: "sla_age", Header: "AgeHours", Evaluated: func(e sla.Evaluation) interface{} { return sla.Hours(e.Age) }},
+	{Name: "sla_since_update", Header: "HoursSinceUpdate", Evaluated: func(e sla.Evaluation) interface{} { return sla.Hours(e.SinceUpdate) }},
+	{Name: "sla_remaining", Header: "SLAHoursLeft", Evaluated: func(e sla.Evaluation) interface{} {
+		if e.Target <= 0 {
+			return ""
+		}
+		return sla.Hours(e.Remaining())
+	}},
+}
+
+// DefaultColumns are written when a Layout lists no columns
+var DefaultColumns = []Column{
+	{Field: "uri"},
+	{Field: "severity"},
+	{Field: "id"},
+	{Field: "status"},
+	{Field: "summary"},
+	{Field: "created_by"},
+	{Field: "created"},
+	{Field: "modified"},
+}
+
+// DefaultDateFormat is the Go time layout dates are written with when a Layout gives none
+const DefaultDateFormat = "2006-01-02 15:04:05 MST"
+
+// Column selects a field of the case for a column, Header replaces the default label of the field when given
+type Column struct {
+	Field  string `mapstructure:"field"`
+	Header string `mapstructure:"header"`
+}
+
+// Layout describes the columns written for each case and how the tabs are formatted
+type Layout struct {
+	Columns []Column `mapstructure:"columns"`
+	// DateFormat is a Go time layout, e.g. "2006-01-02"
+	DateFormat string `mapstructure:"date_format"`
+	// Formatting freezes and filters the header row, colors severity 1 and 2, escalated cases and SLA breaches and sizes the columns
+	Formatting bool `mapstructure:"formatting"`
+
+	// The sla_ fields are measured against slaPolicy at slaNow, see WithSLA
+	slaPolicy sla.Policy
+	slaNow    time.Time
+}
+
+// WithSLA returns the layout measuring the sla_ fields against the policy at the given time
+func (l Layout) WithSLA(policy sla.Policy, now time.Time) Layout {
+	l.slaPolicy = policy
+	l.slaNow = now
+	return l
+}
+
+// FieldNames returns the names of the fields a Column may select
+func FieldNames() []string {
+	names := make([]string, 0, len(fields)+len(slaFields))
+	for _, f := range fields {
+		names = append(names, f.Name)
+	}
+	for _, f := range slaFields {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// lookupField returns the field of a name, without a Value for the sla_ fields
+func lookupField(name string) (field, bool) {
+	for _, f := range fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	if f, ok := lookupSLAField(name); ok {
+		return field{Name: f.Name, Header: f.Header}, true
+	}
+	return field{}, false
+}
+
+func lookupSLAField(name string) (slaField, bool) {
+	for _, f := range slaFields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return slaField{}, false
+}
+
+// Validate checks every column names a known field and no two columns share a header
+func (l Layout) Validate() error {
+	headers := map[string]bool{}
+	for i, col := range l.columns() {
+		f, ok := lookupField(col.Field)
+		if !ok {
+			return fmt.Errorf("column %d: unknown field '%s', expected one of %s", i+1, col.Field, strings.Join(FieldNames(), ", "))
+		}
+		header := col.header(f)
+		if headers[header] {
+			return fmt.Errorf("column %d: header '%s' is used twice", i+1, header)
+		}
+		headers[header] = true
+	}
+	return nil
+}
+
+func (l Layout) columns() []Column {
+	if len(l.Columns) == 0 {
+		return DefaultColumns
+	}
+	return l.Columns
+}
+
+func (col Column) header(f field) string {
+	if col.Header != "" {
+		return col.Header
+	}
+	return f.Header
+}
+
+// column returns the index and header of the first column showing a field, -1 when there is none
+func (l Layout) column(name string) (int, string) {
+	for i, col := range l.columns() {
+		if col.Field == name {
+			f, _ := lookupField(name)
+			return i, col.header(f)
+		}
+	}
+	return -1, ""
+}
+
+// IdHeader returns the header of the column holding the case Id, which Upsert and Pull match rows by
+func (l Layout) IdHeader() (string, error) {
+	i, header := l.column("id")
+	if i < 0 {
+		return "", fmt.Errorf("the layout has no column showing the 'id' field")
+	}
+	return header, nil
+}
+
+// NeedsAccounts returns true when a column shows a field of the account of the case,
+// or a field measured against SLA targets which differ for accounts with an enhanced SLA
+func (l Layout) NeedsAccounts() bool {
+	for _, col := range l.columns() {
+		if strings.HasPrefix(col.Field, "account_") {
+			return true
+		}
+		if strings.HasPrefix(col.Field, "sla_") && len(l.slaPolicy.EnhancedTargets) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Header returns the header row
+func (l Layout) Header() []interface{} {
+	row := make([]interface{}, 0, len(l.columns()))
+	for _, col := range l.columns() {
+		f, _ := lookupField(col.Field)
+		row = append(row, col.header(f))
+	}
+	return row
+}
+
+// Row returns the cells of a case, unknown fields are left empty
+func (l Layout) Row(c cache.Case, accounts map[string]cache.Account) []interface{} {
+	dateFormat := l.DateFormat
+	if dateFormat == "" {
+		dateFormat = DefaultDateFormat
+	}
+	now := l.slaNow
+	if now.IsZero() {
+		now = time.Now()
+	}
+	account := accounts[c.AccountNumber]
+	row := make([]interface{}, 0, len(l.columns()))
+	for _, col := range l.columns() {
+		var value interface{}
+		if f, ok := lookupSLAField(col.Field); ok {
+			value = f.Evaluated(l.slaPolicy.Evaluate(c, account, now))
+		} else if f, ok := lookupField(col.Field); ok {
+			value = f.Value(c, account)
+		} else {
+			row = append(row, "")
+			continue
+		}
+		if t, ok := value.(time.Time); ok {
+			if t.IsZero() {
+				value = ""
+			} else {
+				value = t.Format(dateFormat)
+			}
+		}
+		row = append(row, value)
+	}
+	return row
+}
+
+// Values returns the header followed by a row for each case
+func (l Layout) Values(cases []cache.Case, accounts map[string]cache.Account) [][]interface{} {
+	values := make([][]interface{}, 0, len(cases)+1)
+	values = append(values, l.Header())
+	for _, c := range cases {
+		values = append(values, l.Row(c, accounts))
+	}
+	return values
+}
@@ -0,0 +1,145 @@
+package spreadsheet
+
+import (
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/jwmatthews/case_watcher/pkg/sla"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/sheets/v4"
+	"testing"
+	"time"
+)
+
+var layoutCase = cache.Case{
+	Id:                 "500",
+	CaseNumber:         "0300",
+	Uri:                "https://example.com/500",
+	Severity:           "1 (Urgent)",
+	Status:             "Open",
+	Summary:            "velero backup fails",
+	CreatedByName:      "someone",
+	CreatedDate:        time.Date(2022, 1, 5, 10, 30, 0, 0, time.UTC),
+	AccountNumber:      "42",
+	CustomerEscalation: true,
+	Products:           []cache.Product{{Name: "OpenShift", Version: "4.9"}, {Name: "OADP", Version: "4.9"}},
+	Version:            "4.9",
+}
+
+func TestLayout_Defaults(t *testing.T) {
+	layout := Layout{}
+	require.NoError(t, layout.Validate())
+	assert.Equal(t, []interface{}{"Uri", "Severity", "Id", "Status", "Summary", "CreatedByName", "CreatedDate", "LastModifiedDate"},
+		layout.Header())
+	assert.Equal(t, []interface{}{Formula("=hyperlink(\"https://example.com/500\")"), "1 (Urgent)", "500", "Open", "velero backup fails",
+		"someone", "2022-01-05 10:30:00 UTC", ""}, layout.Row(layoutCase, nil), "a date never set is left empty")
+	idHeader, err := layout.IdHeader()
+	require.NoError(t, err)
+	assert.Equal(t, IdHeader, idHeader)
+	assert.False(t, layout.NeedsAccounts())
+
+	quoted := layoutCase
+	quoted.Uri = `https://example.com/"),1+("`
+	assert.Equal(t, Formula(`=hyperlink("https://example.com/""),1+(""")`), layout.Row(quoted, nil)[0],
+		"quotes in the URI do not end the formula's string")
+}
+
+func TestLayout_Columns(t *testing.T) {
+	layout := Layout{
+		Columns: []Column{
+			{Field: "number", Header: "Case"},
+			{Field: "id", Header: "Case Id"},
+			{Field: "created"},
+			{Field: "products"},
+			{Field: "version"},
+			{Field: "account_name"},
+			{Field: "account_tam"},
+			{Field: "account_strategic"},
+			{Field: "escalated"},
+		},
+		DateFormat: "2006-01-02",
+	}
+	require.NoError(t, layout.Validate())
+	assert.True(t, layout.NeedsAccounts())
+	idHeader, err := layout.IdHeader()
+	require.NoError(t, err)
+	assert.Equal(t, "Case Id", idHeader)
+
+	accounts := map[string]cache.Account{"42": {AccountNumber: "42", Name: "Example Corp", HasTAM: true}}
+	values := layout.Values([]cache.Case{layoutCase}, accounts)
+	assert.Equal(t, [][]interface{}{
+		{"Case", "Case Id", "CreatedDate", "Products", "Version", "AccountName", "TAM", "Strategic", "CustomerEscalation"},
+		{"0300", "500", "2022-01-05", "OpenShift, OADP", "4.9", "Example Corp", true, false, true},
+	}, values)
+	assert.Equal(t, []interface{}{"0300", "500", "2022-01-05", "OpenShift, OADP", "4.9", "", false, false, true},
+		layout.Row(layoutCase, nil), "account fields are empty when the account is not cached")
+}
+
+func TestLayout_WithSLA(t *testing.T) {
+	now := layoutCase.CreatedDate.Add(3 * time.Hour)
+	policy := sla.Policy{
+		Targets:         map[string]sla.Target{"1": {Response: 4 * time.Hour}},
+		EnhancedTargets: map[string]sla.Target{"1": {Response: 2 * time.Hour}},
+	}
+	layout := Layout{Columns: []Column{{Field: "id"}, {Field: "sla_status"}, {Field: "sla_age"}, {Field: "sla_since_update"}, {Field: "sla_remaining"}}}
+	assert.False(t, layout.NeedsAccounts())
+	layout = layout.WithSLA(policy, now)
+	assert.True(t, layout.NeedsAccounts(), "enhanced targets depend on the account")
+
+	assert.Equal(t, []interface{}{"500", sla.StatusAtRisk, 3.0, 3.0, 1.0}, layout.Row(layoutCase, nil))
+	accounts := map[string]cache.Account{"42": {AccountNumber: "42", HasEnhancedSLA: true}}
+	assert.Equal(t, []interface{}{"500", sla.StatusBreached, 3.0, 3.0, -1.0}, layout.Row(layoutCase, accounts))
+	low := layoutCase
+	low.Severity = "4 (Low)"
+	assert.Equal(t, []interface{}{"500", sla.StatusNone, 3.0, 3.0, ""}, layout.Row(low, nil), "no target for the severity")
+
+	requests := formatRequests(7, layout, nil)
+	require.Len(t, requests, 6)
+	assert.Equal(t, `=$B2="breached"`, ruleFormula(requests[3].AddConditionalFormatRule.Rule))
+	assert.Equal(t, `=$B2="at_risk"`, ruleFormula(requests[4].AddConditionalFormatRule.Rule))
+}
+
+func TestLayout_Validate(t *testing.T) {
+	assert.Error(t, Layout{Columns: []Column{{Field: "id"}, {Field: "nope"}}}.Validate())
+	assert.Error(t, Layout{Columns: []Column{{Field: "id"}, {Field: "number", Header: "Id"}}}.Validate())
+	_, err := Layout{Columns: []Column{{Field: "number"}}}.IdHeader()
+	assert.Error(t, err)
+}
+
+func TestColumnLetter(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 1: "B", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		assert.Equal(t, want, ColumnLetter(i))
+	}
+}
+
+func TestFormatRequests(t *testing.T) {
+	layout := Layout{Columns: []Column{{Field: "id"}, {Field: "severity"}, {Field: "summary"}, {Field: "escalated"}}}
+	requests := formatRequests(7, layout, nil)
+	require.Len(t, requests, 7)
+	assert.Equal(t, int64(1), requests[0].UpdateSheetProperties.Properties.GridProperties.FrozenRowCount)
+	assert.True(t, requests[1].RepeatCell.Cell.UserEnteredFormat.TextFormat.Bold)
+	assert.Zero(t, requests[2].SetBasicFilter.Filter.Range.EndColumnIndex, "the filter covers the team's columns too")
+	formulas := make([]string, 0)
+	for i, r := range requests[3:6] {
+		require.NotNil(t, r.AddConditionalFormatRule)
+		assert.Equal(t, int64(i), r.AddConditionalFormatRule.Index)
+		formulas = append(formulas, ruleFormula(r.AddConditionalFormatRule.Rule))
+	}
+	assert.Equal(t, []string{`=LEFT($B2,1)="1"`, `=LEFT($B2,1)="2"`, `=$D2=TRUE`}, formulas)
+	severityRange := requests[3].AddConditionalFormatRule.Rule.Ranges[0]
+	assert.Equal(t, []int64{7, 1, 1, 2}, []int64{severityRange.SheetId, severityRange.StartRowIndex, severityRange.StartColumnIndex, severityRange.EndColumnIndex})
+	assert.Zero(t, requests[6].AutoResizeDimensions.Dimensions.EndIndex)
+
+	// Formatting again adds only the rules missing, after those already there
+	existing := []*sheets.ConditionalFormatRule{
+		conditionalRule(7, 0, 1, `=$A2="team rule"`, escalationColor),
+		requests[3].AddConditionalFormatRule.Rule,
+		requests[5].AddConditionalFormatRule.Rule,
+	}
+	again := formatRequests(7, layout, existing)
+	require.Len(t, again, 5)
+	assert.Equal(t, `=LEFT($B2,1)="2"`, ruleFormula(again[3].AddConditionalFormatRule.Rule))
+	assert.Equal(t, int64(3), again[3].AddConditionalFormatRule.Index)
+
+	plain := formatRequests(7, Layout{Columns: []Column{{Field: "id"}}}, nil)
+	assert.Len(t, plain, 4, "no conditional colors without severity or escalation columns")
+}
@@ -0,0 +1,296 @@
+package spreadsheet
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// IdHeader is the default header of the column identifying the case of a row
+const IdHeader = "Id"
+
+// RowUpdate overwrites the managed columns of a row, Row is 0 based with the header as row 0
+type RowUpdate struct {
+	Row    int
+	Values []interface{}
+}
+
+// UserCell is a cell of a user column along with the header of its column
+type UserCell struct {
+	Header string
+	Value  interface{}
+}
+
+// SheetPlan lists the changes bringing a sheet in line with the wanted rows.
+// Managed columns are those of the wanted header, columns to their right belong to the team and are left alone.
+type SheetPlan struct {
+	Updates []RowUpdate
+	// Appends are whole rows to add after the last row, the user columns of a case moved from another sheet included
+	Appends [][]interface{}
+	// Deletes are the rows of cases no longer wanted, highest first so each can be removed in turn
+	Deletes []int
+	// Moved holds the user cells of deleted rows by case id, to be carried to the sheet the case moved to
+	Moved map[string][]UserCell
+	// InsertColumns is how many empty columns to insert at UserColumn, where the user columns start on the sheet,
+	// before anything is written, making room for the columns the layout gained
+	InsertColumns int
+	UserColumn    int
+}
+
+// Empty returns true when the sheet is already up to date
+func (p SheetPlan) Empty() bool {
+	return len(p.Updates) == 0 && len(p.Appends) == 0 && len(p.Deletes) == 0 && p.InsertColumns == 0
+}
+
+// PlanUpsert compares the rows of a sheet with the wanted rows, both starting with a header row, keyed by the
+// idHeader column. Changed rows are updated in place, missing cases appended with any user cells found in carried,
+// and rows of cases not wanted deleted. Rows without an id, e.g. notes below the table, are left alone.
+// Carried cells go to the user column of the same header, columns are added for headers the sheet lacks.
+// The user columns start at the first header of the sheet the wanted header lacks. When the layout gained or
+// renamed columns they are moved right, see InsertColumns, a column no longer written is kept as a user column.
+func PlanUpsert(existing, wanted [][]interface{}, idHeader string, carried map[string][]UserCell) (SheetPlan, error) {
+	plan := SheetPlan{Moved: map[string][]UserCell{}}
+	if len(wanted) == 0 {
+		return plan, fmt.Errorf("wanted rows must start with a header")
+	}
+	header := wanted[0]
+	managed := len(header)
+	idColumn := -1
+	for i, name := range header {
+		if name == idHeader {
+			idColumn = i
+		}
+	}
+	if idColumn < 0 {
+		return plan, fmt.Errorf("header has no '%s' column", idHeader)
+	}
+
+	var existingHeader []interface{}
+	if len(existing) > 0 {
+		existingHeader = existing[0]
+	}
+	if start := managedColumns(existingHeader, header); start < managed && len(existingHeader) > start {
+		// The layout changed since the sheet was written, the user columns move right to stay clear of ours
+		plan.InsertColumns = managed - start
+		plan.UserColumn = start
+		existing = insertColumns(existing, start, plan.InsertColumns)
+		existingHeader = existing[0]
+	}
+	userHeaders := make([]string, 0)
+	for i := managed; i < len(existingHeader); i++ {
+		userHeaders = append(userHeaders, cellString(existingHeader, i))
+	}
+	headerChanged := len(existing) == 0 || !sameCells(existingHeader, header)
+	wantedRows := map[string][]interface{}{}
+	for _, row := range wanted[1:] {
+		wantedRows[cellString(row, idColumn)] = row
+	}
+
+	found := map[string]bool{}
+	for i := 1; i < len(existing); i++ {
+		row := existing[i]
+		id := cellString(row, idColumn)
+		if id == "" {
+			continue
+		}
+		want, ok := wantedRows[id]
+		if !ok || found[id] {
+			plan.Deletes = append(plan.Deletes, i)
+			if user := userCells(row, existingHeader, managed); !ok && len(user) > 0 {
+				plan.Moved[id] = user
+			}
+			continue
+		}
+		found[id] = true
+		if !sameCells(row, want) {
+			plan.Updates = append(plan.Updates, RowUpdate{Row: i, Values: want})
+		}
+	}
+	userColumns := len(userHeaders)
+	for _, row := range wanted[1:] {
+		id := cellString(row, idColumn)
+		if found[id] {
+			continue
+		}
+		found[id] = true
+		appended := append([]interface{}{}, row...)
+		if user, ok := carried[id]; ok {
+			for len(appended) < managed {
+				appended = append(appended, "")
+			}
+			var cells []interface{}
+			cells, userHeaders = placeUserCells(user, userHeaders)
+			appended = append(appended, cells...)
+		}
+		plan.Appends = append(plan.Appends, appended)
+	}
+	if headerChanged || len(userHeaders) > userColumns {
+		values := append([]interface{}{}, header...)
+		if len(userHeaders) > userColumns {
+			for _, h := range userHeaders {
+				values = append(values, h)
+			}
+		}
+		plan.Updates = append([]RowUpdate{{Row: 0, Values: values}}, plan.Updates...)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(plan.Deletes)))
+	return plan, nil
+}
+
+// managedColumns returns how many columns of a sheet are ours, those its header starts with which are headers of the
+// wanted header, each matched once. The user columns start with the first header the layout does not write.
+func managedColumns(existingHeader, header []interface{}) int {
+	unmatched := map[string]int{}
+	for i := range header {
+		unmatched[cellString(header, i)]++
+	}
+	for i := range existingHeader {
+		name := cellString(existingHeader, i)
+		if unmatched[name] == 0 {
+			return i
+		}
+		unmatched[name]--
+	}
+	return len(existingHeader)
+}
+
+// insertColumns returns the rows with n empty cells inserted before column at, in rows reaching that far
+func insertColumns(rows [][]interface{}, at, n int) [][]interface{} {
+	result := make([][]interface{}, 0, len(rows))
+	for _, row := range rows {
+		if len(row) <= at {
+			result = append(result, row)
+			continue
+		}
+		shifted := append([]interface{}{}, row[:at]...)
+		for i := 0; i < n; i++ {
+			shifted = append(shifted, "")
+		}
+		result = append(result, append(shifted, row[at:]...))
+	}
+	return result
+}
+
+// placeUserCells lays carried cells out along the user columns of a sheet, the n-th cell under a header going to
+// the n-th column of that header. Headers the sheet lacks are added to the returned headers.
+func placeUserCells(user []UserCell, headers []string) ([]interface{}, []string) {
+	cells := make([]interface{}, len(headers))
+	for i := range cells {
+		cells[i] = ""
+	}
+	seen := map[string]int{}
+	for _, cell := range user {
+		column := -1
+		n := seen[cell.Header]
+		seen[cell.Header]++
+		for i, h := range headers {
+			if h != cell.Header {
+				continue
+			}
+			if n == 0 {
+				column = i
+				break
+			}
+			n--
+		}
+		if column < 0 {
+			headers = append(headers, cell.Header)
+			cells = append(cells, "")
+			column = len(headers) - 1
+		}
+		cells[column] = cell.Value
+	}
+	for len(cells) > 0 && cellString(cells, len(cells)-1) == "" {
+		cells = cells[:len(cells)-1]
+	}
+	return cells, headers
+}
+
+func cellString(row []interface{}, i int) string {
+	if i >= len(row) || row[i] == nil {
+		return ""
+	}
+	if f, ok := row[i].(float64); ok {
+		// Sheets returns numbers as such, and Sprint writes large ones with an exponent
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(row[i])
+}
+
+// sameCells compares the managed cells of a row read from a sheet with the wanted row,
+// the API leaves out trailing empty cells and returns what was entered as Sheets parsed it
+func sameCells(row, want []interface{}) bool {
+	for i := range want {
+		if cellKey(row, i) != cellKey(want, i) {
+			return false
+		}
+	}
+	return true
+}
+
+// numberPattern matches the numbers Sheets parses from entered text
+var numberPattern = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+
+// dateLayouts are the dates Sheets parses from entered text, in the formats it shows them by default first
+var dateLayouts = []string{
+	"1/2/2006 15:04:05",
+	"1/2/2006",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	DefaultDateFormat,
+	time.RFC3339,
+}
+
+// cellKey normalises a cell the way Sheets reads back a value entered with USER_ENTERED: formula names
+// in upper case, numbers and booleans parsed and dates shown in the format of the cell,
+// so a row only counts as changed when one of its values did
+func cellKey(row []interface{}, i int) string {
+	s := cellString(row, i)
+	switch {
+	case strings.HasPrefix(s, "="):
+		return upperFormula(s)
+	case strings.EqualFold(s, "true") || strings.EqualFold(s, "false"):
+		return strings.ToUpper(s)
+	case numberPattern.MatchString(s):
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return strconv.FormatFloat(f, 'f', -1, 64)
+		}
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format("2006-01-02 15:04:05")
+		}
+	}
+	return s
+}
+
+// upperFormula upper cases a formula outside its string literals, as Sheets does with function names and references
+func upperFormula(formula string) string {
+	var b strings.Builder
+	quoted := false
+	for _, r := range formula {
+		if r == '"' {
+			quoted = !quoted
+		}
+		if !quoted {
+			r = unicode.ToUpper(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// userCells returns the cells holding a value to the right of the managed columns along with their headers
+func userCells(row, header []interface{}, managed int) []UserCell {
+	var user []UserCell
+	for i := managed; i < len(row); i++ {
+		if cellString(row, i) != "" {
+			user = append(user, UserCell{Header: cellString(header, i), Value: row[i]})
+		}
+	}
+	return user
+}
@@ -0,0 +1,178 @@
+package spreadsheet
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var header = []interface{}{"Id", "Status", "Summary"}
+
+func row(cells ...interface{}) []interface{} {
+	return cells
+}
+
+func TestPlanUpsert_EmptySheet(t *testing.T) {
+	wanted := [][]interface{}{header, row("1", "Open", "first"), row("2", "Open", "second")}
+	plan, err := PlanUpsert(nil, wanted, IdHeader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []RowUpdate{{Row: 0, Values: header}}, plan.Updates)
+	assert.Equal(t, wanted[1:], plan.Appends)
+	assert.Empty(t, plan.Deletes)
+}
+
+func TestPlanUpsert_UpToDate(t *testing.T) {
+	existing := [][]interface{}{
+		row("Id", "Status", "Summary", "Notes"),
+		row("1", "Open", "first", "call back friday"),
+		row("2", "Open"),
+	}
+	wanted := [][]interface{}{header, row("1", "Open", "first"), row("2", "Open", "")}
+	plan, err := PlanUpsert(existing, wanted, IdHeader, nil)
+	require.NoError(t, err)
+	assert.True(t, plan.Empty(), "trailing empty cells left out by the API and user columns are not changes")
+}
+
+func TestPlanUpsert_UpToDateAsReadBack(t *testing.T) {
+	existing := [][]interface{}{
+		row("Id", "Uri", "Created", "Escalated"),
+		row(float64(12345678), `=HYPERLINK("https://example.com/a?q=x")`, "1/3/2022 9:30:00", true),
+	}
+	wanted := [][]interface{}{
+		row("Id", "Uri", "Created", "Escalated"),
+		row("12345678", Formula(`=hyperlink("https://example.com/a?q=x")`), "2022-01-03 09:30:00", true),
+	}
+	plan, err := PlanUpsert(existing, wanted, IdHeader, nil)
+	require.NoError(t, err)
+	assert.True(t, plan.Empty(), "numbers, formula names and dates as Sheets parsed them are not changes")
+
+	wanted[1][1] = Formula(`=hyperlink("https://example.com/a?q=X")`)
+	plan, err = PlanUpsert(existing, wanted, IdHeader, nil)
+	require.NoError(t, err)
+	assert.Len(t, plan.Updates, 1, "text in a formula keeps its case")
+}
+
+func TestPlanUpsert_UpdatesAppendsAndDeletes(t *testing.T) {
+	existing := [][]interface{}{
+		row("Id", "Status", "Summary", "Notes"),
+		row("1", "Open", "first", "note 1"),
+		row("2", "Open", "second", "note 2"),
+		row("3", "Open", "third"),
+		row("", "", "", "a note below the table"),
+	}
+	wanted := [][]interface{}{header, row("3", "Open", "third"), row("1", "Waiting", "first"), row("4", "Open", "fourth")}
+	carried := map[string][]UserCell{"4": {{Header: "Notes", Value: "note 4"}}}
+	plan, err := PlanUpsert(existing, wanted, IdHeader, carried)
+	require.NoError(t, err)
+	assert.Equal(t, []RowUpdate{{Row: 1, Values: row("1", "Waiting", "first")}}, plan.Updates)
+	assert.Equal(t, [][]interface{}{row("4", "Open", "fourth", "note 4")}, plan.Appends)
+	assert.Equal(t, []int{2}, plan.Deletes)
+	assert.Equal(t, map[string][]UserCell{"2": {{Header: "Notes", Value: "note 2"}}}, plan.Moved)
+}
+
+func TestPlanUpsert_CarriedCellsLineUpByHeader(t *testing.T) {
+	existing := [][]interface{}{
+		row("Id", "Status", "Summary", "Notes", "Next step"),
+		row("1", "Open", "first", "note 1", "call back"),
+	}
+	wanted := [][]interface{}{header, row("1", "Open", "first"), row("2", "Open", "second"), row("3", "Open", "third")}
+	carried := map[string][]UserCell{
+		"2": {{Header: "Next step", Value: "patch"}, {Header: "Ticket", Value: "BZ-2"}},
+		"3": {{Header: "Ticket", Value: "BZ-3"}},
+	}
+	plan, err := PlanUpsert(existing, wanted, IdHeader, carried)
+	require.NoError(t, err)
+	assert.Equal(t, []RowUpdate{{Row: 0, Values: row("Id", "Status", "Summary", "Notes", "Next step", "Ticket")}}, plan.Updates,
+		"headers the sheet lacks are added")
+	assert.Equal(t, [][]interface{}{
+		row("2", "Open", "second", "", "patch", "BZ-2"),
+		row("3", "Open", "third", "", "", "BZ-3"),
+	}, plan.Appends)
+}
+
+func TestPlanUpsert_LayoutGainsColumn(t *testing.T) {
+	existing := [][]interface{}{
+		row("Id", "Status", "Summary", "Notes"),
+		row("1", "Open", "first", "note 1"),
+		row("2", "Open", "second"),
+	}
+	wanted := [][]interface{}{
+		row("Id", "Status", "Summary", "Owner"),
+		row("1", "Open", "first", "pat"),
+		row("2", "Open", "second", "sam"),
+	}
+	plan, err := PlanUpsert(existing, wanted, IdHeader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, plan.InsertColumns, "the team's Notes move right rather than being overwritten")
+	assert.Equal(t, 3, plan.UserColumn)
+	assert.Equal(t, []RowUpdate{
+		{Row: 0, Values: wanted[0]},
+		{Row: 1, Values: wanted[1]},
+		{Row: 2, Values: wanted[2]},
+	}, plan.Updates)
+	assert.Empty(t, plan.Deletes)
+
+	// Once shifted, the sheet is up to date
+	shifted := [][]interface{}{
+		row("Id", "Status", "Summary", "Owner", "Notes"),
+		row("1", "Open", "first", "pat", "note 1"),
+		row("2", "Open", "second", "sam"),
+	}
+	plan, err = PlanUpsert(shifted, wanted, IdHeader, nil)
+	require.NoError(t, err)
+	assert.True(t, plan.Empty())
+}
+
+func TestPlanUpsert_LayoutReordersColumns(t *testing.T) {
+	existing := [][]interface{}{row("Id", "Status", "Summary", "Notes"), row("1", "Open", "first", "note 1")}
+	wanted := [][]interface{}{row("Id", "Summary", "Status"), row("1", "first", "Open")}
+	plan, err := PlanUpsert(existing, wanted, IdHeader, nil)
+	require.NoError(t, err)
+	assert.Zero(t, plan.InsertColumns)
+	assert.Equal(t, []RowUpdate{{Row: 0, Values: wanted[0]}, {Row: 1, Values: wanted[1]}}, plan.Updates)
+}
+
+func TestPlanUpsert_DuplicateRowsAreDeleted(t *testing.T) {
+	existing := [][]interface{}{header, row("1", "Open", "first"), row("2", "Open", "second"), row("1", "Open", "first")}
+	wanted := [][]interface{}{header, row("1", "Open", "first"), row("2", "Open", "second")}
+	plan, err := PlanUpsert(existing, wanted, IdHeader, nil)
+	require.NoError(t, err)
+	assert.Empty(t, plan.Updates)
+	assert.Empty(t, plan.Appends)
+	assert.Equal(t, []int{3}, plan.Deletes)
+	assert.Empty(t, plan.Moved)
+}
+
+func TestPlanUpsert_DeletesHighestFirst(t *testing.T) {
+	existing := [][]interface{}{header, row("1"), row("2"), row("3"), row("4")}
+	plan, err := PlanUpsert(existing, [][]interface{}{header, row("3")}, IdHeader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []int{4, 2, 1}, plan.Deletes)
+}
+
+func TestPlanUpsert_ChangedHeader(t *testing.T) {
+	existing := [][]interface{}{row("Id", "State", "Summary", "Notes"), row("1", "Open", "first")}
+	plan, err := PlanUpsert(existing, [][]interface{}{header, row("1", "Open", "first")}, IdHeader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []RowUpdate{{Row: 0, Values: header}, {Row: 1, Values: row("1", "Open", "first")}}, plan.Updates)
+	assert.Equal(t, 2, plan.InsertColumns, "a header we no longer write may be the team's, it is kept")
+	assert.Equal(t, 1, plan.UserColumn)
+}
+
+func TestPlanUpsert_RequiresIdColumn(t *testing.T) {
+	_, err := PlanUpsert(nil, [][]interface{}{row("Status")}, IdHeader, nil)
+	assert.Error(t, err)
+	_, err = PlanUpsert(nil, nil, IdHeader, nil)
+	assert.Error(t, err)
+}
+
+func TestPlanUpsert_IdHeader(t *testing.T) {
+	relabelled := row("Case Id", "Status", "Summary")
+	existing := [][]interface{}{relabelled, row("1", "Open", "first"), row("2", "Open", "second")}
+	plan, err := PlanUpsert(existing, [][]interface{}{relabelled, row("2", "Closed", "second")}, "Case Id", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []RowUpdate{{Row: 2, Values: row("2", "Closed", "second")}}, plan.Updates)
+	assert.Equal(t, []int{1}, plan.Deletes)
+	_, err = PlanUpsert(existing, [][]interface{}{relabelled}, IdHeader, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,343 @@
+package spreadsheet
+
+import (
+	"fmt"
+	"google.golang.org/api/sheets/v4"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Names of the open case tabs written by Update
+const (
+	// LatestOpenCasesSheet always holds the open cases of the last update, for links which should not go stale
+	LatestOpenCasesSheet = "Open Cases (latest)"
+	// DailySheetPrefix starts the name of the tab of each day, followed by the date, e.g. 'OpenCases - 2022-03-10'
+	DailySheetPrefix = "OpenCases - "
+	// WeeklySheetPrefix starts the name of a weekly archive tab, followed by the ISO week, e.g. 'OpenCases - Week 2022-W10'
+	WeeklySheetPrefix = "OpenCases - Week "
+)
+
+// What RotationPolicy.Archive does with daily tabs older than those kept
+const (
+	// ArchiveWeekly merges the daily tabs of each week into the weekly archive tab of that week,
+	// each case as the last day of the week showed it
+	ArchiveWeekly = "weekly"
+	// ArchiveSpreadsheet copies the tabs to another spreadsheet before removing them
+	ArchiveSpreadsheet = "spreadsheet"
+	// ArchiveDelete removes the tabs
+	ArchiveDelete = "delete"
+)
+
+// RotationPolicy limits the daily tabs Update leaves behind, a zero value keeps every tab
+type RotationPolicy struct {
+	// DailyTabs is the number of daily tabs kept, older ones are archived
+	DailyTabs int    `mapstructure:"daily_tabs"`
+	Archive   string `mapstructure:"archive"`
+	// WeeklyTabs is the number of weekly archive tabs kept, 0 keeps them all
+	WeeklyTabs int `mapstructure:"weekly_tabs"`
+	// ArchiveSpreadsheet is the id of the spreadsheet tabs are copied to with ArchiveSpreadsheet
+	ArchiveSpreadsheet string `mapstructure:"archive_spreadsheet"`
+}
+
+// Validate checks the archive is known and has a spreadsheet to copy to when needed
+func (p RotationPolicy) Validate() error {
+	switch p.Archive {
+	case ArchiveWeekly, ArchiveDelete:
+	case ArchiveSpreadsheet:
+		if p.ArchiveSpreadsheet == "" {
+			return fmt.Errorf("archive '%s' needs the id of an archive spreadsheet", ArchiveSpreadsheet)
+		}
+	case "":
+		if p.DailyTabs > 0 {
+			return fmt.Errorf("daily tabs are limited without an archive, expected %s, %s or %s",
+				ArchiveWeekly, ArchiveSpreadsheet, ArchiveDelete)
+		}
+	default:
+		return fmt.Errorf("unknown archive '%s', expected %s, %s or %s", p.Archive, ArchiveWeekly, ArchiveSpreadsheet, ArchiveDelete)
+	}
+	return nil
+}
+
+// RotationPlan lists the tabs to change, by name
+type RotationPlan struct {
+	Delete []string
+	// Rename maps a daily tab kept as a weekly archive tab to its new name
+	Rename map[string]string
+	// Merge maps a daily tab kept as a weekly archive tab to the tabs of the same week, newest first,
+	// whose cases it takes over before they are removed
+	Merge map[string][]string
+	// Archive are copied to the archive spreadsheet, then removed
+	Archive []string
+}
+
+// Empty returns true when no tab needs to change
+func (p RotationPlan) Empty() bool {
+	return len(p.Delete) == 0 && len(p.Rename) == 0 && len(p.Archive) == 0
+}
+
+// dailyDate returns the date of a daily tab
+func dailyDate(title string) (time.Time, bool) {
+	if !strings.HasPrefix(title, DailySheetPrefix) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse("2006-01-02", strings.TrimPrefix(title, DailySheetPrefix))
+	return t, err == nil
+}
+
+// weekTitle returns the name of the weekly archive tab of a date
+func weekTitle(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%s%d-W%02d", WeeklySheetPrefix, year, week)
+}
+
+// PlanRotation applies the policy to the tabs of a spreadsheet by name. The newest DailyTabs daily tabs are kept,
+// older ones archived as the policy says. Tabs other than daily and weekly tabs are never touched.
+func PlanRotation(titles []string, policy RotationPolicy) RotationPlan {
+	plan := RotationPlan{Rename: map[string]string{}, Merge: map[string][]string{}}
+	daily := make([]string, 0)
+	weekly := map[string]bool{}
+	for _, title := range titles {
+		if _, ok := dailyDate(title); ok {
+			daily = append(daily, title)
+		} else if strings.HasPrefix(title, WeeklySheetPrefix) {
+			weekly[title] = true
+		}
+	}
+	// Dates sort as their names do, newest first
+	sort.Sort(sort.Reverse(sort.StringSlice(daily)))
+	old := make([]string, 0)
+	if policy.DailyTabs > 0 && len(daily) > policy.DailyTabs {
+		old = daily[policy.DailyTabs:]
+	}
+
+	switch policy.Archive {
+	case ArchiveWeekly:
+		// The newest old tab of a week replaces its weekly tab, taking over the cases of the earlier days
+		// and of the weekly tab, which holds days of the same week archived before
+		latest := map[string]string{}
+		earlier := map[string][]string{}
+		for _, title := range old {
+			t, _ := dailyDate(title)
+			week := weekTitle(t)
+			if _, ok := latest[week]; ok {
+				plan.Delete = append(plan.Delete, title)
+				earlier[week] = append(earlier[week], title)
+				continue
+			}
+			latest[week] = title
+		}
+		weeks := make([]string, 0, len(weekly)+len(latest))
+		for week := range weekly {
+			weeks = append(weeks, week)
+		}
+		for week := range latest {
+			if !weekly[week] {
+				weeks = append(weeks, week)
+			}
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(weeks)))
+		for i, week := range weeks {
+			kept := policy.WeeklyTabs <= 0 || i < policy.WeeklyTabs
+			daySource, replaced := latest[week]
+			if weekly[week] && (replaced || !kept) {
+				plan.Delete = append(plan.Delete, week)
+			}
+			if replaced {
+				if kept {
+					plan.Rename[daySource] = week
+					merged := earlier[week]
+					if weekly[week] {
+						merged = append(merged, week)
+					}
+					if len(merged) > 0 {
+						plan.Merge[daySource] = merged
+					}
+				} else {
+					plan.Delete = append(plan.Delete, daySource)
+				}
+			}
+		}
+	case ArchiveSpreadsheet:
+		plan.Archive = append(plan.Archive, old...)
+	case ArchiveDelete:
+		plan.Delete = append(plan.Delete, old...)
+	}
+	sort.Strings(plan.Delete)
+	sort.Strings(plan.Archive)
+	return plan
+}
+
+// rotateTabs applies the policy to the spreadsheet, the tabs to archive are copied and the cases of a week merged
+// before any tab is removed. Cases are told apart by the column under idHeader, or by the whole row without one.
+func rotateTabs(sink Sink, spreadsheetId string, policy RotationPolicy, idHeader string) (RotationPlan, error) {
+	ids, err := sheetIds(sink, spreadsheetId)
+	if err != nil {
+		return RotationPlan{}, err
+	}
+	titles := make([]string, 0, len(ids))
+	for title := range ids {
+		titles = append(titles, title)
+	}
+	plan := PlanRotation(titles, policy)
+	if plan.Empty() {
+		return plan, nil
+	}
+
+	if len(plan.Archive) > 0 {
+		archived, err := sheetIds(sink, policy.ArchiveSpreadsheet)
+		if err != nil {
+			return plan, err
+		}
+		for _, title := range plan.Archive {
+			if _, ok := archived[title]; ok {
+				// Copied by an earlier run which failed to remove it
+				continue
+			}
+			copied, err := sink.CopyTo(spreadsheetId, ids[title], policy.ArchiveSpreadsheet)
+			if err != nil {
+				return plan, fmt.Errorf("unable to archive %s: %w", title, err)
+			}
+			err = renameSheets(sink, policy.ArchiveSpreadsheet, map[int64]string{copied.SheetId: title})
+			if err != nil {
+				return plan, err
+			}
+		}
+	}
+
+	merged := make([]string, 0, len(plan.Merge))
+	for title := range plan.Merge {
+		merged = append(merged, title)
+	}
+	sort.Strings(merged)
+	for _, title := range merged {
+		err = mergeWeek(sink, spreadsheetId, title, plan.Merge[title], idHeader)
+		if err != nil {
+			return plan, err
+		}
+	}
+
+	requests := make([]*sheets.Request, 0)
+	for _, title := range append(append([]string{}, plan.Delete...), plan.Archive...) {
+		requests = append(requests, &sheets.Request{DeleteSheet: &sheets.DeleteSheetRequest{SheetId: ids[title]}})
+	}
+	// Replaced weekly tabs are deleted above, before a daily tab takes their name
+	renamed := map[int64]string{}
+	for from, to := range plan.Rename {
+		renamed[ids[from]] = to
+	}
+	err = sink.Apply(spreadsheetId, append(requests, renameRequests(renamed)...))
+	if err != nil {
+		log.Printf("Error, failed to rotate tabs of spreadsheet: %s, received error: %s\n", spreadsheetId, err)
+		return plan, err
+	}
+	log.Printf("Rotated spreadsheet tabs: %d removed, %d kept as weekly tabs, %d archived\n",
+		len(plan.Delete), len(plan.Rename), len(plan.Archive))
+	return plan, nil
+}
+
+// mergeWeek appends the cases of the earlier tabs of a week missing from the newest, under the same headers
+func mergeWeek(sink Sink, spreadsheetId, newest string, earlier []string, idHeader string) error {
+	rows, err := readSheet(sink, spreadsheetId, newest)
+	if err != nil {
+		return err
+	}
+	tabs := make([][][]interface{}, 0, len(earlier))
+	for _, title := range earlier {
+		older, err := readSheet(sink, spreadsheetId, title)
+		if err != nil {
+			return err
+		}
+		tabs = append(tabs, older)
+	}
+	added := weekRows(rows, tabs, idHeader)
+	if len(added) == 0 {
+		return nil
+	}
+	err = sink.Write(spreadsheetId, []*sheets.ValueRange{{Range: a1(newest, fmt.Sprintf("A%d", len(rows)+1)), Values: added}})
+	if err != nil {
+		log.Printf("Error, failed to merge the week into %s of spreadsheet: %s, received error: %s\n", newest, spreadsheetId, err)
+		return err
+	}
+	return nil
+}
+
+// weekRows returns the rows of the earlier tabs, newest first, for cases not already on the newest tab, each
+// case once as the newest tab showing it has it. Cells are lined up under the headers of the newest tab, those
+// under a header it lacks are dropped. The header is included when the newest tab is empty.
+func weekRows(newest [][]interface{}, earlier [][][]interface{}, idHeader string) [][]interface{} {
+	added := make([][]interface{}, 0)
+	for i := 0; len(newest) == 0 && i < len(earlier); i++ {
+		if len(earlier[i]) > 0 {
+			newest = earlier[i][:1]
+			added = append(added, earlier[i][0])
+		}
+	}
+	if len(newest) == 0 {
+		return added
+	}
+	header := newest[0]
+	position := map[string]int{}
+	for i := len(header) - 1; i >= 0; i-- {
+		position[cellString(header, i)] = i
+	}
+	idColumn, ok := position[idHeader]
+	if !ok || idHeader == "" {
+		idColumn = -1
+	}
+	seen := map[string]bool{}
+	for t, rows := range append([][][]interface{}{newest}, earlier...) {
+		if len(rows) == 0 {
+			continue
+		}
+		for _, row := range rows[1:] {
+			cells := make([]interface{}, len(header))
+			for i := range cells {
+				cells[i] = ""
+			}
+			for i, value := range row {
+				if column, ok := position[cellString(rows[0], i)]; ok {
+					cells[column] = value
+				}
+			}
+			for len(cells) > 0 && cellString(cells, len(cells)-1) == "" {
+				cells = cells[:len(cells)-1]
+			}
+			key := fmt.Sprintf("row\x00%q", cells)
+			if idColumn >= 0 && cellString(cells, idColumn) != "" {
+				key = "id\x00" + cellString(cells, idColumn)
+			}
+			if len(cells) == 0 || seen[key] {
+				continue
+			}
+			seen[key] = true
+			if t > 0 {
+				added = append(added, cells)
+			}
+		}
+	}
+	return added
+}
+
+func renameRequests(titles map[int64]string) []*sheets.Request {
+	requests := make([]*sheets.Request, 0, len(titles))
+	for id, title := range titles {
+		requests = append(requests, &sheets.Request{
+			UpdateSheetProperties: &sheets.UpdateSheetPropertiesRequest{
+				Properties: &sheets.SheetProperties{SheetId: id, Title: title},
+				Fields:     "title",
+			},
+		})
+	}
+	return requests
+}
+
+func renameSheets(sink Sink, spreadsheetId string, titles map[int64]string) error {
+	err := sink.Apply(spreadsheetId, renameRequests(titles))
+	if err != nil {
+		log.Printf("Error, failed to rename tabs of spreadsheet: %s, received error: %s\n", spreadsheetId, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package spreadsheet
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Tuesday 2022-03-01 to Thursday 2022-03-10, ISO weeks 9 and 10
+var dailyTitles = []string{
+	"OpenCases - 2022-03-01", "OpenCases - 2022-03-02", "OpenCases - 2022-03-03", "OpenCases - 2022-03-04",
+	"OpenCases - 2022-03-07", "OpenCases - 2022-03-08", "OpenCases - 2022-03-09", "OpenCases - 2022-03-10",
+}
+
+func titles(extra ...string) []string {
+	return append(append([]string{ClosedCasesSheet, LatestOpenCasesSheet, "Team notes"}, extra...), dailyTitles...)
+}
+
+func TestPlanRotation_KeepsEverythingByDefault(t *testing.T) {
+	assert.True(t, PlanRotation(titles(), RotationPolicy{}).Empty())
+	assert.True(t, PlanRotation(titles(), RotationPolicy{DailyTabs: 8, Archive: ArchiveDelete}).Empty())
+}
+
+func TestPlanRotation_Delete(t *testing.T) {
+	plan := PlanRotation(titles(), RotationPolicy{DailyTabs: 5, Archive: ArchiveDelete})
+	assert.Equal(t, []string{"OpenCases - 2022-03-01", "OpenCases - 2022-03-02", "OpenCases - 2022-03-03"}, plan.Delete)
+	assert.Empty(t, plan.Rename)
+	assert.Empty(t, plan.Archive)
+}
+
+func TestPlanRotation_Spreadsheet(t *testing.T) {
+	plan := PlanRotation(titles(), RotationPolicy{DailyTabs: 6, Archive: ArchiveSpreadsheet, ArchiveSpreadsheet: "archive"})
+	assert.Equal(t, []string{"OpenCases - 2022-03-01", "OpenCases - 2022-03-02"}, plan.Archive)
+	assert.Empty(t, plan.Delete)
+}
+
+func TestPlanRotation_Weekly(t *testing.T) {
+	// The last old day of each week becomes its weekly tab
+	plan := PlanRotation(titles(), RotationPolicy{DailyTabs: 2, Archive: ArchiveWeekly})
+	assert.Equal(t, map[string]string{
+		"OpenCases - 2022-03-04": "OpenCases - Week 2022-W09",
+		"OpenCases - 2022-03-08": "OpenCases - Week 2022-W10",
+	}, plan.Rename)
+	assert.Equal(t, []string{"OpenCases - 2022-03-01", "OpenCases - 2022-03-02", "OpenCases - 2022-03-03", "OpenCases - 2022-03-07"},
+		plan.Delete)
+	assert.Equal(t, map[string][]string{
+		"OpenCases - 2022-03-04": {"OpenCases - 2022-03-03", "OpenCases - 2022-03-02", "OpenCases - 2022-03-01"},
+		"OpenCases - 2022-03-08": {"OpenCases - 2022-03-07"},
+	}, plan.Merge, "the earlier days of a week are merged into it")
+
+	// A later day of a week replaces its weekly tab, older weekly tabs beyond the limit are removed
+	existing := []string{ClosedCasesSheet, "OpenCases - Week 2022-W08", "OpenCases - Week 2022-W09", "OpenCases - Week 2022-W10",
+		"OpenCases - 2022-03-09", "OpenCases - 2022-03-10", "OpenCases - 2022-03-11"}
+	plan = PlanRotation(existing, RotationPolicy{DailyTabs: 2, Archive: ArchiveWeekly, WeeklyTabs: 2})
+	assert.Equal(t, map[string]string{"OpenCases - 2022-03-09": "OpenCases - Week 2022-W10"}, plan.Rename)
+	assert.Equal(t, []string{"OpenCases - Week 2022-W08", "OpenCases - Week 2022-W10"}, plan.Delete)
+	assert.Equal(t, map[string][]string{"OpenCases - 2022-03-09": {"OpenCases - Week 2022-W10"}}, plan.Merge)
+
+	// A week beyond the limit is not kept at all
+	plan = PlanRotation(titles(), RotationPolicy{DailyTabs: 2, Archive: ArchiveWeekly, WeeklyTabs: 1})
+	assert.Equal(t, map[string]string{"OpenCases - 2022-03-08": "OpenCases - Week 2022-W10"}, plan.Rename)
+	assert.Contains(t, plan.Delete, "OpenCases - 2022-03-04")
+	assert.NotContains(t, plan.Delete, "OpenCases - 2022-03-08")
+	assert.Equal(t, map[string][]string{"OpenCases - 2022-03-08": {"OpenCases - 2022-03-07"}}, plan.Merge)
+}
+
+func TestWeekRows(t *testing.T) {
+	newest := [][]interface{}{{"Id", "Severity", "Summary"}, {"1", "2 (High)", "backup fails"}}
+	earlier := [][][]interface{}{
+		{{"Summary", "Id", "Owner"}, {"backup fails", "1", "bob"}, {"restore fails", "2", "bob"}},
+		{{"Id", "Severity", "Summary"}, {"2", "4 (Low)", "restore fails"}, {"3", "3 (Normal)", "docs"}},
+	}
+	assert.Equal(t, [][]interface{}{{"2", "", "restore fails"}, {"3", "3 (Normal)", "docs"}}, weekRows(newest, earlier, IdHeader),
+		"each case once as the newest day showed it, under the headers of the newest tab")
+
+	assert.Equal(t, [][]interface{}{{"Id", "Severity", "Summary"}, {"2", "4 (Low)", "restore fails"}, {"3", "3 (Normal)", "docs"}},
+		weekRows(nil, earlier[1:], IdHeader), "an empty tab takes the header as well")
+
+	noIds := [][][]interface{}{{{"Severity"}, {"1 (Urgent)"}, {"2 (High)"}}}
+	assert.Equal(t, [][]interface{}{{"2 (High)"}}, weekRows([][]interface{}{{"Severity"}, {"1 (Urgent)"}}, noIds, ""),
+		"without an id column rows are compared whole")
+}
+
+func TestRotationPolicy_Validate(t *testing.T) {
+	assert.NoError(t, RotationPolicy{}.Validate())
+	assert.NoError(t, RotationPolicy{DailyTabs: 7, Archive: ArchiveWeekly, WeeklyTabs: 8}.Validate())
+	assert.Error(t, RotationPolicy{DailyTabs: 7}.Validate())
+	assert.Error(t, RotationPolicy{DailyTabs: 7, Archive: "monthly"}.Validate())
+	assert.Error(t, RotationPolicy{DailyTabs: 7, Archive: ArchiveSpreadsheet}.Validate())
+}
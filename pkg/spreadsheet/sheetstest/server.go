@@ -0,0 +1,676 @@
+// Package sheetstest is an in-memory stand-in for the Google Sheets API and the OAuth token endpoint,
+// for tests to check which tabs, ranges and values a spreadsheet.Sink writes
+package sheetstest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"google.golang.org/api/sheets/v4"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Token is the access token handed out by the stand-in, API calls without it are refused
+const Token = "sheetstest-token"
+
+// Write is a range of values written through the API
+type Write struct {
+	SpreadsheetId string
+	Range         string
+	Values        [][]interface{}
+}
+
+// tab is a sheet of a spreadsheet and its cells
+type tab struct {
+	Properties         *sheets.SheetProperties
+	ConditionalFormats []*sheets.ConditionalFormatRule
+	Cells              [][]interface{}
+}
+
+// Server serves the spreadsheets created with it, call Close when done
+type Server struct {
+	*httptest.Server
+	mu           sync.Mutex
+	spreadsheets map[string][]*tab
+	nextSheetId  int64
+	writes       []Write
+	requests     map[string][]*sheets.Request
+	tokens       int
+}
+
+// NewServer starts a stand-in holding an empty spreadsheet for each id
+func NewServer(spreadsheetIds ...string) *Server {
+	s := &Server{
+		spreadsheets: map[string][]*tab{},
+		nextSheetId:  100,
+		requests:     map[string][]*sheets.Request{},
+	}
+	for _, id := range spreadsheetIds {
+		s.spreadsheets[id] = []*tab{}
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Endpoint is the base URL of the Sheets API of the stand-in
+func (s *Server) Endpoint() string {
+	return s.URL + "/"
+}
+
+// TokenURL is where the stand-in hands out access tokens
+func (s *Server) TokenURL() string {
+	return s.URL + "/token"
+}
+
+// AddSheet adds a tab holding values to a spreadsheet and returns its id
+func (s *Server) AddSheet(spreadsheetId, title string, values [][]interface{}) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.newTab(spreadsheetId, title)
+	s.spreadsheets[spreadsheetId] = append(s.spreadsheets[spreadsheetId], t)
+	writeCells(t, 0, 0, values)
+	return t.Properties.SheetId
+}
+
+// Titles returns the names of the tabs of a spreadsheet in order
+func (s *Server) Titles(spreadsheetId string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	titles := make([]string, 0)
+	for _, t := range s.spreadsheets[spreadsheetId] {
+		titles = append(titles, t.Properties.Title)
+	}
+	return titles
+}
+
+// Values returns the cells of a tab up to the last one set, as the API reads them, nil when there is no such tab
+func (s *Server) Values(spreadsheetId, title string) [][]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := findTab(s.spreadsheets[spreadsheetId], title)
+	if t == nil {
+		return nil
+	}
+	return readCells(t, 0, 0, -1, -1)
+}
+
+// Sheet returns the properties and conditional format rules of a tab, nil when there is no such tab
+func (s *Server) Sheet(spreadsheetId, title string) *sheets.Sheet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := findTab(s.spreadsheets[spreadsheetId], title)
+	if t == nil {
+		return nil
+	}
+	return &sheets.Sheet{Properties: t.Properties, ConditionalFormats: t.ConditionalFormats}
+}
+
+// Writes returns the ranges written, in order
+func (s *Server) Writes() []Write {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Write{}, s.writes...)
+}
+
+// Requests returns the batch update requests applied to a spreadsheet, in order
+func (s *Server) Requests(spreadsheetId string) []*sheets.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*sheets.Request{}, s.requests[spreadsheetId]...)
+}
+
+// Tokens returns the number of access tokens handed out
+func (s *Server) Tokens() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokens
+}
+
+var privateKey struct {
+	once sync.Once
+	pem  string
+}
+
+// PrivateKey returns a PEM encoded RSA key for a service account to sign its token requests with.
+// The stand-in does not check signatures.
+func PrivateKey() string {
+	privateKey.once.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			panic(err)
+		}
+		privateKey.pem = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	})
+	return privateKey.pem
+}
+
+// apiError is returned the way the Sheets API reports errors
+func apiError(w http.ResponseWriter, code int, format string, args ...interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"code": code, "message": fmt.Sprintf(format, args...)},
+	})
+}
+
+func reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.URL.Path == "/token" {
+		s.tokens++
+		reply(w, map[string]interface{}{"access_token": Token, "token_type": "Bearer", "expires_in": 3600})
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+Token {
+		apiError(w, http.StatusUnauthorized, "request is missing a valid access token")
+		return
+	}
+
+	// Ranges may hold escaped slashes, so split the path before unescaping it
+	const prefix = "/v4/spreadsheets/"
+	path := r.URL.EscapedPath()
+	if !strings.HasPrefix(path, prefix) {
+		apiError(w, http.StatusNotFound, "unknown path %s", r.URL.Path)
+		return
+	}
+	segments := strings.Split(strings.TrimPrefix(path, prefix), "/")
+	for i, segment := range segments {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, "bad path %s", path)
+			return
+		}
+		segments[i] = unescaped
+	}
+	id := strings.TrimSuffix(segments[0], ":batchUpdate")
+	if _, ok := s.spreadsheets[id]; !ok {
+		apiError(w, http.StatusNotFound, "Requested entity was not found.")
+		return
+	}
+
+	switch {
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		s.getSpreadsheet(w, id)
+	case len(segments) == 1 && r.Method == http.MethodPost && strings.HasSuffix(segments[0], ":batchUpdate"):
+		s.batchUpdate(w, r, id)
+	case len(segments) == 2 && r.Method == http.MethodPost && segments[1] == "values:batchUpdate":
+		s.writeValues(w, r, id)
+	case len(segments) == 3 && r.Method == http.MethodGet && segments[1] == "values":
+		s.readValues(w, id, segments[2])
+	case len(segments) == 3 && r.Method == http.MethodPost && segments[1] == "values" && strings.HasSuffix(segments[2], ":clear"):
+		s.clearValues(w, id, strings.TrimSuffix(segments[2], ":clear"))
+	case len(segments) == 3 && r.Method == http.MethodPost && segments[1] == "sheets" && strings.HasSuffix(segments[2], ":copyTo"):
+		s.copyTo(w, r, id, strings.TrimSuffix(segments[2], ":copyTo"))
+	default:
+		apiError(w, http.StatusNotFound, "unknown request %s %s", r.Method, r.URL.Path)
+	}
+}
+
+func (s *Server) getSpreadsheet(w http.ResponseWriter, id string) {
+	resp := sheets.Spreadsheet{SpreadsheetId: id}
+	for _, t := range s.spreadsheets[id] {
+		resp.Sheets = append(resp.Sheets, &sheets.Sheet{Properties: t.Properties, ConditionalFormats: t.ConditionalFormats})
+	}
+	reply(w, resp)
+}
+
+func (s *Server) readValues(w http.ResponseWriter, id, sheetRange string) {
+	r, err := parseRange(sheetRange)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	t := findTab(s.spreadsheets[id], r.Title)
+	if t == nil {
+		apiError(w, http.StatusBadRequest, "Unable to parse range: %s", sheetRange)
+		return
+	}
+	reply(w, sheets.ValueRange{Range: sheetRange, MajorDimension: "ROWS", Values: readCells(t, r.Row, r.Column, r.EndRow, r.EndColumn)})
+}
+
+func (s *Server) clearValues(w http.ResponseWriter, id, sheetRange string) {
+	r, err := parseRange(sheetRange)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	t := findTab(s.spreadsheets[id], r.Title)
+	if t == nil {
+		apiError(w, http.StatusBadRequest, "Unable to parse range: %s", sheetRange)
+		return
+	}
+	for row := r.Row; row < len(t.Cells) && (r.EndRow < 0 || row <= r.EndRow); row++ {
+		for column := r.Column; column < len(t.Cells[row]) && (r.EndColumn < 0 || column <= r.EndColumn); column++ {
+			t.Cells[row][column] = nil
+		}
+	}
+	reply(w, sheets.ClearValuesResponse{SpreadsheetId: id, ClearedRange: sheetRange})
+}
+
+func (s *Server) writeValues(w http.ResponseWriter, req *http.Request, id string) {
+	body := sheets.BatchUpdateValuesRequest{}
+	err := json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	// Check every range before writing any, the API writes all or nothing
+	ranges := make([]cellRange, 0, len(body.Data))
+	for _, data := range body.Data {
+		r, err := parseRange(data.Range)
+		if err == nil && findTab(s.spreadsheets[id], r.Title) == nil {
+			err = fmt.Errorf("Unable to parse range: %s", data.Range)
+		}
+		if err != nil {
+			apiError(w, http.StatusBadRequest, "%s", err)
+			return
+		}
+		ranges = append(ranges, r)
+	}
+	for i, data := range body.Data {
+		values := data.Values
+		if body.ValueInputOption == "USER_ENTERED" {
+			values = enteredValues(values)
+		}
+		writeCells(findTab(s.spreadsheets[id], ranges[i].Title), ranges[i].Row, ranges[i].Column, values)
+		s.writes = append(s.writes, Write{SpreadsheetId: id, Range: data.Range, Values: data.Values})
+	}
+	reply(w, sheets.BatchUpdateValuesResponse{SpreadsheetId: id, TotalUpdatedSheets: int64(len(body.Data))})
+}
+
+func (s *Server) copyTo(w http.ResponseWriter, req *http.Request, id, sheetId string) {
+	body := sheets.CopySheetToAnotherSpreadsheetRequest{}
+	err := json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	if _, ok := s.spreadsheets[body.DestinationSpreadsheetId]; !ok {
+		apiError(w, http.StatusNotFound, "Requested entity was not found.")
+		return
+	}
+	n, _ := strconv.ParseInt(sheetId, 10, 64)
+	source := findTabById(s.spreadsheets[id], n)
+	if source == nil {
+		apiError(w, http.StatusBadRequest, "No grid with id: %s", sheetId)
+		return
+	}
+	// The API names the copy after the tab, made unique the same way
+	title := "Copy of " + source.Properties.Title
+	for i := 2; findTab(s.spreadsheets[body.DestinationSpreadsheetId], title) != nil; i++ {
+		title = fmt.Sprintf("Copy of %s %d", source.Properties.Title, i)
+	}
+	copied := s.newTab(body.DestinationSpreadsheetId, title)
+	copied.ConditionalFormats = source.ConditionalFormats
+	writeCells(copied, 0, 0, readCells(source, 0, 0, -1, -1))
+	s.spreadsheets[body.DestinationSpreadsheetId] = append(s.spreadsheets[body.DestinationSpreadsheetId], copied)
+	reply(w, copied.Properties)
+}
+
+func (s *Server) batchUpdate(w http.ResponseWriter, req *http.Request, id string) {
+	body := sheets.BatchUpdateSpreadsheetRequest{}
+	err := json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	// Requests are applied to a copy, kept only when every one of them succeeds
+	tabs := make([]*tab, 0, len(s.spreadsheets[id]))
+	for _, t := range s.spreadsheets[id] {
+		properties := *t.Properties
+		if t.Properties.GridProperties != nil {
+			grid := *t.Properties.GridProperties
+			properties.GridProperties = &grid
+		}
+		cells := make([][]interface{}, len(t.Cells))
+		for i, row := range t.Cells {
+			cells[i] = append([]interface{}{}, row...)
+		}
+		tabs = append(tabs, &tab{
+			Properties:         &properties,
+			ConditionalFormats: append([]*sheets.ConditionalFormatRule{}, t.ConditionalFormats...),
+			Cells:              cells,
+		})
+	}
+	nextSheetId := s.nextSheetId
+	resp := sheets.BatchUpdateSpreadsheetResponse{SpreadsheetId: id}
+	for i, request := range body.Requests {
+		r, err := s.apply(id, &tabs, request)
+		if err != nil {
+			s.nextSheetId = nextSheetId
+			apiError(w, http.StatusBadRequest, "Invalid requests[%d]: %s", i, err)
+			return
+		}
+		resp.Replies = append(resp.Replies, r)
+	}
+	for i, t := range tabs {
+		t.Properties.Index = int64(i)
+	}
+	s.spreadsheets[id] = tabs
+	s.requests[id] = append(s.requests[id], body.Requests...)
+	reply(w, resp)
+}
+
+// apply runs a single request of a batch update against tabs
+func (s *Server) apply(id string, tabs *[]*tab, request *sheets.Request) (*sheets.Response, error) {
+	switch {
+	case request.AddSheet != nil:
+		title := request.AddSheet.Properties.Title
+		if findTab(*tabs, title) != nil {
+			return nil, fmt.Errorf("A sheet with the name \"%s\" already exists. Please enter another name.", title)
+		}
+		t := s.newTab(id, title)
+		t.Properties.Index = int64(len(*tabs))
+		*tabs = append(*tabs, t)
+		return &sheets.Response{AddSheet: &sheets.AddSheetResponse{Properties: t.Properties}}, nil
+	case request.DeleteSheet != nil:
+		for i, t := range *tabs {
+			if t.Properties.SheetId == request.DeleteSheet.SheetId {
+				*tabs = append((*tabs)[:i], (*tabs)[i+1:]...)
+				return &sheets.Response{}, nil
+			}
+		}
+		return nil, fmt.Errorf("No grid with id: %d", request.DeleteSheet.SheetId)
+	case request.UpdateSheetProperties != nil:
+		update := request.UpdateSheetProperties
+		t := findTabById(*tabs, update.Properties.SheetId)
+		if t == nil {
+			return nil, fmt.Errorf("No grid with id: %d", update.Properties.SheetId)
+		}
+		for _, field := range strings.Split(update.Fields, ",") {
+			switch field {
+			case "title":
+				if other := findTab(*tabs, update.Properties.Title); other != nil && other != t {
+					return nil, fmt.Errorf("A sheet with the name \"%s\" already exists. Please enter another name.", update.Properties.Title)
+				}
+				t.Properties.Title = update.Properties.Title
+			case "gridProperties.frozenRowCount":
+				t.Properties.GridProperties.FrozenRowCount = update.Properties.GridProperties.FrozenRowCount
+			default:
+				return nil, fmt.Errorf("sheetstest does not update the field %s", field)
+			}
+		}
+		return &sheets.Response{}, nil
+	case request.DeleteDimension != nil:
+		dimension := request.DeleteDimension.Range
+		t := findTabById(*tabs, dimension.SheetId)
+		if t == nil {
+			return nil, fmt.Errorf("No grid with id: %d", dimension.SheetId)
+		}
+		if dimension.Dimension != "ROWS" {
+			return nil, fmt.Errorf("sheetstest only deletes ROWS")
+		}
+		start, end := int(dimension.StartIndex), int(dimension.EndIndex)
+		if start < len(t.Cells) {
+			if end > len(t.Cells) {
+				end = len(t.Cells)
+			}
+			t.Cells = append(t.Cells[:start], t.Cells[end:]...)
+		}
+		return &sheets.Response{}, nil
+	case request.InsertDimension != nil:
+		dimension := request.InsertDimension.Range
+		t := findTabById(*tabs, dimension.SheetId)
+		if t == nil {
+			return nil, fmt.Errorf("No grid with id: %d", dimension.SheetId)
+		}
+		if dimension.Dimension != "COLUMNS" {
+			return nil, fmt.Errorf("sheetstest only inserts COLUMNS")
+		}
+		start, n := int(dimension.StartIndex), int(dimension.EndIndex-dimension.StartIndex)
+		for i, row := range t.Cells {
+			if start < len(row) {
+				shifted := append(append([]interface{}{}, row[:start]...), make([]interface{}, n)...)
+				t.Cells[i] = append(shifted, row[start:]...)
+			}
+		}
+		t.Properties.GridProperties.ColumnCount += int64(n)
+		return &sheets.Response{}, nil
+	case request.AddConditionalFormatRule != nil:
+		add := request.AddConditionalFormatRule
+		t := findTabById(*tabs, add.Rule.Ranges[0].SheetId)
+		if t == nil {
+			return nil, fmt.Errorf("No grid with id: %d", add.Rule.Ranges[0].SheetId)
+		}
+		index := int(add.Index)
+		if index > len(t.ConditionalFormats) {
+			index = len(t.ConditionalFormats)
+		}
+		rules := append([]*sheets.ConditionalFormatRule{}, t.ConditionalFormats[:index]...)
+		t.ConditionalFormats = append(append(rules, add.Rule), t.ConditionalFormats[index:]...)
+		return &sheets.Response{}, nil
+	case request.RepeatCell != nil, request.SetBasicFilter != nil, request.AutoResizeDimensions != nil:
+		// Only formatting, which Requests lets tests check
+		return &sheets.Response{}, nil
+	default:
+		return nil, fmt.Errorf("sheetstest does not support this request")
+	}
+}
+
+// newTab returns an empty tab with a new id, the caller adds it to the spreadsheet
+func (s *Server) newTab(spreadsheetId, title string) *tab {
+	s.nextSheetId++
+	return &tab{Properties: &sheets.SheetProperties{
+		SheetId:        s.nextSheetId,
+		Title:          title,
+		Index:          int64(len(s.spreadsheets[spreadsheetId])),
+		SheetType:      "GRID",
+		GridProperties: &sheets.GridProperties{RowCount: 1000, ColumnCount: 26},
+	}}
+}
+
+func findTab(tabs []*tab, title string) *tab {
+	for _, t := range tabs {
+		if t.Properties.Title == title {
+			return t
+		}
+	}
+	return nil
+}
+
+func findTabById(tabs []*tab, sheetId int64) *tab {
+	for _, t := range tabs {
+		if t.Properties.SheetId == sheetId {
+			return t
+		}
+	}
+	return nil
+}
+
+// numberPattern matches the text Sheets enters as a number
+var numberPattern = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+
+// enteredValues parses values as Sheets does with USER_ENTERED: formulas have their names upper cased,
+// numbers and booleans are parsed and ISO dates are shown in the default format of an en_US spreadsheet
+func enteredValues(values [][]interface{}) [][]interface{} {
+	entered := make([][]interface{}, len(values))
+	for i, row := range values {
+		entered[i] = make([]interface{}, len(row))
+		for j, value := range row {
+			entered[i][j] = enteredValue(value)
+		}
+	}
+	return entered
+}
+
+func enteredValue(value interface{}) interface{} {
+	s, ok := value.(string)
+	if !ok {
+		return value
+	}
+	switch {
+	case strings.HasPrefix(s, "="):
+		var b strings.Builder
+		quoted := false
+		for _, r := range s {
+			if r == '"' {
+				quoted = !quoted
+			}
+			if !quoted {
+				r = unicode.ToUpper(r)
+			}
+			b.WriteRune(r)
+		}
+		return b.String()
+	case strings.EqualFold(s, "true") || strings.EqualFold(s, "false"):
+		return strings.EqualFold(s, "true")
+	case numberPattern.MatchString(s):
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	if t, err := time.Parse("2006-01-02 15:04:05", s); err == nil {
+		return fmt.Sprintf("%s %d:%s", t.Format("1/2/2006"), t.Hour(), t.Format("04:05"))
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t.Format("1/2/2006")
+	}
+	return s
+}
+
+// writeCells sets the cells from row and column on, growing the tab as needed
+func writeCells(t *tab, row, column int, values [][]interface{}) {
+	for i, rowValues := range values {
+		for len(t.Cells) <= row+i {
+			t.Cells = append(t.Cells, []interface{}{})
+		}
+		for j, value := range rowValues {
+			for len(t.Cells[row+i]) <= column+j {
+				t.Cells[row+i] = append(t.Cells[row+i], nil)
+			}
+			t.Cells[row+i][column+j] = value
+		}
+	}
+}
+
+// readCells returns the cells of a range, an end of -1 being unbounded. Like the API, trailing empty rows
+// and cells are left out and empty cells before the last one set read as "".
+func readCells(t *tab, row, column, endRow, endColumn int) [][]interface{} {
+	values := make([][]interface{}, 0)
+	for r := row; r < len(t.Cells) && (endRow < 0 || r <= endRow); r++ {
+		rowValues := make([]interface{}, 0)
+		for c := column; c < len(t.Cells[r]) && (endColumn < 0 || c <= endColumn); c++ {
+			rowValues = append(rowValues, t.Cells[r][c])
+		}
+		for len(rowValues) > 0 && (rowValues[len(rowValues)-1] == nil || rowValues[len(rowValues)-1] == "") {
+			rowValues = rowValues[:len(rowValues)-1]
+		}
+		for i, v := range rowValues {
+			if v == nil {
+				rowValues[i] = ""
+			}
+		}
+		values = append(values, rowValues)
+	}
+	for len(values) > 0 && len(values[len(values)-1]) == 0 {
+		values = values[:len(values)-1]
+	}
+	return values
+}
+
+// cellRange is a range in A1 notation, 0 based, with -1 for an unbounded end
+type cellRange struct {
+	Title     string
+	Row       int
+	Column    int
+	EndRow    int
+	EndColumn int
+}
+
+// parseRange reads ranges such as 'Open Cases'!A1:ZZ, Summary!A1:Z9999, 'Open Cases'!A5 or Summary
+func parseRange(a1 string) (cellRange, error) {
+	r := cellRange{EndRow: -1, EndColumn: -1}
+	cells := ""
+	if strings.HasPrefix(a1, "'") {
+		end := 1
+		for ; end < len(a1); end++ {
+			if a1[end] == '\'' {
+				if end+1 < len(a1) && a1[end+1] == '\'' {
+					end++
+					continue
+				}
+				break
+			}
+		}
+		if end >= len(a1) {
+			return r, fmt.Errorf("Unable to parse range: %s", a1)
+		}
+		r.Title = strings.ReplaceAll(a1[1:end], "''", "'")
+		cells = strings.TrimPrefix(a1[end+1:], "!")
+	} else if i := strings.LastIndex(a1, "!"); i >= 0 {
+		r.Title, cells = a1[:i], a1[i+1:]
+	} else {
+		r.Title = a1
+	}
+	if cells == "" {
+		return r, nil
+	}
+	parts := strings.SplitN(cells, ":", 2)
+	var err error
+	r.Row, r.Column, err = parseCell(parts[0])
+	if err != nil {
+		return r, fmt.Errorf("Unable to parse range: %s", a1)
+	}
+	if len(parts) == 1 {
+		if r.Row < 0 || r.Column < 0 {
+			return r, fmt.Errorf("Unable to parse range: %s", a1)
+		}
+		r.EndRow, r.EndColumn = r.Row, r.Column
+		return r, nil
+	}
+	r.EndRow, r.EndColumn, err = parseCell(parts[1])
+	if err != nil {
+		return r, fmt.Errorf("Unable to parse range: %s", a1)
+	}
+	// Whole rows or columns, e.g. A:C or 2:5
+	if r.Row < 0 {
+		r.Row = 0
+	}
+	if r.Column < 0 {
+		r.Column = 0
+	}
+	return r, nil
+}
+
+// parseCell reads a cell such as B7, a column such as ZZ or a row such as 7, the part left out is -1
+func parseCell(cell string) (int, int, error) {
+	letters := strings.TrimRight(cell, "0123456789")
+	row, column := -1, -1
+	if digits := cell[len(letters):]; digits != "" {
+		n, err := strconv.Atoi(digits)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("bad row in %s", cell)
+		}
+		row = n - 1
+	}
+	if letters != "" {
+		column = 0
+		for _, l := range strings.ToUpper(letters) {
+			if l < 'A' || l > 'Z' {
+				return 0, 0, fmt.Errorf("bad column in %s", cell)
+			}
+			column = column*26 + int(l-'A') + 1
+		}
+		column--
+	}
+	if row < 0 && column < 0 {
+		return 0, 0, fmt.Errorf("empty cell")
+	}
+	return row, column, nil
+}
@@ -0,0 +1,26 @@
+package sheetstest
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	for a1, want := range map[string]cellRange{
+		"'Open Cases'!A1:ZZ":        {Title: "Open Cases", Row: 0, Column: 0, EndRow: -1, EndColumn: 701},
+		"Closed Cases!A1:Z9999":     {Title: "Closed Cases", Row: 0, Column: 0, EndRow: 9998, EndColumn: 25},
+		"'Bob''s cases'!C5":         {Title: "Bob's cases", Row: 4, Column: 2, EndRow: 4, EndColumn: 2},
+		"'a!b'!B:C":                 {Title: "a!b", Row: 0, Column: 1, EndRow: -1, EndColumn: 2},
+		"Summary":                   {Title: "Summary", EndRow: -1, EndColumn: -1},
+		"OpenCases - 2022-01-03!A2": {Title: "OpenCases - 2022-01-03", Row: 1, Column: 0, EndRow: 1, EndColumn: 0},
+	} {
+		r, err := parseRange(a1)
+		require.NoError(t, err, a1)
+		assert.Equal(t, want, r, a1)
+	}
+	for _, a1 := range []string{"'unterminated!A1", "Summary!A0", "Summary!1A", "Summary!B"} {
+		_, err := parseRange(a1)
+		assert.Error(t, err, a1)
+	}
+}
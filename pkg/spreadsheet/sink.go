@@ -0,0 +1,166 @@
+package spreadsheet
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/jwt"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+	"io/ioutil"
+	"net/http"
+)
+
+// Sink is the spreadsheet service tabs are written to, the Google Sheets API or a stand-in for it.
+// Every call names the spreadsheet it acts on, so tabs can be archived to another one.
+type Sink interface {
+	// Sheets returns the tabs of a spreadsheet with their properties and conditional format rules
+	Sheets(spreadsheetId string) ([]*sheets.Sheet, error)
+	// Read returns the values of a range, formulas rather than their results and dates as shown
+	Read(spreadsheetId, sheetRange string) ([][]interface{}, error)
+	// Clear empties a range
+	Clear(spreadsheetId, sheetRange string) error
+	// Write sets the values of each range as if they were typed in
+	Write(spreadsheetId string, data []*sheets.ValueRange) error
+	// Apply runs the requests in a single batch, none of them are applied when one fails
+	Apply(spreadsheetId string, requests []*sheets.Request) error
+	// CopyTo copies a tab to another spreadsheet, returning the properties of the copy
+	CopyTo(spreadsheetId string, sheetId int64, destinationId string) (*sheets.SheetProperties, error)
+}
+
+// DefaultTokenURL is where Google service accounts get their access tokens
+const DefaultTokenURL = "https://oauth2.googleapis.com/token"
+
+// Connection says where the Sheets API and the OAuth token endpoint are, Google's when left empty
+type Connection struct {
+	Endpoint string
+	TokenURL string
+}
+
+// Credentials say how to authenticate with Google: with the service account JSON key in File, else with the
+// key fields copied out of it, else with Application Default Credentials, e.g. GOOGLE_APPLICATION_CREDENTIALS
+// or the identity of the workload
+type Credentials struct {
+	File         string
+	Email        string
+	PrivateKey   string
+	PrivateKeyId string
+}
+
+// sheetsScope is the access asked for, reading and writing spreadsheets
+const sheetsScope = "https://www.googleapis.com/auth/spreadsheets"
+
+// client returns an HTTP client adding access tokens to requests. A token URL set in conn replaces the one
+// of a service account key, Application Default Credentials other than a key file always use their own.
+func (c Credentials) client(conn Connection) (*http.Client, error) {
+	var conf *jwt.Config
+	switch {
+	case c.File != "":
+		data, err := ioutil.ReadFile(c.File)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read credentials file: %w", err)
+		}
+		conf, err = google.JWTConfigFromJSON(data, sheetsScope)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse credentials file %s: %w", c.File, err)
+		}
+	case c.Email != "" || c.PrivateKey != "" || c.PrivateKeyId != "":
+		if c.Email == "" || c.PrivateKey == "" || c.PrivateKeyId == "" {
+			return nil, fmt.Errorf("a service account needs its email, private key and private key id")
+		}
+		// See: https://stackoverflow.com/questions/58874474/unable-to-access-google-spreadsheets-with-a-service-account-credentials-using-go
+		// Create a JWT configurations object for the Google service account
+		conf = &jwt.Config{
+			Email:        c.Email,
+			PrivateKey:   []byte(c.PrivateKey),
+			PrivateKeyID: c.PrivateKeyId,
+			TokenURL:     DefaultTokenURL,
+			Scopes:       []string{sheetsScope},
+		}
+	default:
+		creds, err := google.FindDefaultCredentials(context.Background(), sheetsScope)
+		if err != nil {
+			return nil, fmt.Errorf("no credentials configured, and no application default credentials: %w", err)
+		}
+		if conn.TokenURL != "" && len(creds.JSON) > 0 {
+			conf, err = google.JWTConfigFromJSON(creds.JSON, sheetsScope)
+		}
+		if conf == nil || err != nil {
+			return oauth2.NewClient(context.Background(), creds.TokenSource), nil
+		}
+	}
+	if conn.TokenURL != "" {
+		conf.TokenURL = conn.TokenURL
+	}
+	return conf.Client(oauth2.NoContext), nil
+}
+
+// NewSink returns a Sink using the Sheets API, authenticated with the credentials
+func NewSink(creds Credentials, conn Connection) (Sink, error) {
+	client, err := creds.client(conn)
+	if err != nil {
+		return nil, err
+	}
+	opts := []option.ClientOption{option.WithHTTPClient(client)}
+	if conn.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(conn.Endpoint))
+	}
+
+	// Create a service object for Google sheets
+	srv, err := sheets.NewService(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return serviceSink{srv: srv}, nil
+}
+
+// serviceSink is a Sink calling the Sheets API
+type serviceSink struct {
+	srv *sheets.Service
+}
+
+func (s serviceSink) Sheets(spreadsheetId string) ([]*sheets.Sheet, error) {
+	resp, err := s.srv.Spreadsheets.Get(spreadsheetId).Fields("sheets(properties,conditionalFormats)").
+		Context(context.Background()).Do()
+	if err != nil {
+		return nil, err
+	}
+	return resp.Sheets, nil
+}
+
+func (s serviceSink) Read(spreadsheetId, sheetRange string) ([][]interface{}, error) {
+	resp, err := s.srv.Spreadsheets.Values.Get(spreadsheetId, sheetRange).
+		ValueRenderOption("FORMULA").DateTimeRenderOption("FORMATTED_STRING").Context(context.Background()).Do()
+	if err != nil {
+		return nil, err
+	}
+	return resp.Values, nil
+}
+
+func (s serviceSink) Clear(spreadsheetId, sheetRange string) error {
+	_, err := s.srv.Spreadsheets.Values.Clear(spreadsheetId, sheetRange, &sheets.ClearValuesRequest{}).
+		Context(context.Background()).Do()
+	return err
+}
+
+func (s serviceSink) Write(spreadsheetId string, data []*sheets.ValueRange) error {
+	rb := &sheets.BatchUpdateValuesRequest{
+		ValueInputOption: "USER_ENTERED",
+		Data:             data,
+	}
+	_, err := s.srv.Spreadsheets.Values.BatchUpdate(spreadsheetId, rb).Context(context.Background()).Do()
+	return err
+}
+
+func (s serviceSink) Apply(spreadsheetId string, requests []*sheets.Request) error {
+	rbb := &sheets.BatchUpdateSpreadsheetRequest{Requests: requests}
+	_, err := s.srv.Spreadsheets.BatchUpdate(spreadsheetId, rbb).Context(context.Background()).Do()
+	return err
+}
+
+func (s serviceSink) CopyTo(spreadsheetId string, sheetId int64, destinationId string) (*sheets.SheetProperties, error) {
+	return s.srv.Spreadsheets.Sheets.CopyTo(spreadsheetId, sheetId,
+		&sheets.CopySheetToAnotherSpreadsheetRequest{DestinationSpreadsheetId: destinationId}).
+		Context(context.Background()).Do()
+}
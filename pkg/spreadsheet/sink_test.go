@@ -0,0 +1,63 @@
+package spreadsheet
+
+import (
+	"encoding/json"
+	"github.com/jwmatthews/case_watcher/pkg/spreadsheet/sheetstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// writeKeyFile writes a service account JSON key as Google hands them out, getting its tokens from tokenURL
+func writeKeyFile(t *testing.T, tokenURL string) string {
+	data, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "case-watcher",
+		"private_key_id": "key-id",
+		"private_key":    sheetstest.PrivateKey(),
+		"client_email":   "watcher@case-watcher.iam.gserviceaccount.com",
+		"token_uri":      tokenURL,
+	})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "key.json")
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+	return path
+}
+
+func TestNewSink_CredentialsFile(t *testing.T) {
+	server := sheetstest.NewServer("sheet-id")
+	defer server.Close()
+	server.AddSheet("sheet-id", OpenCasesSheet, nil)
+	file := writeKeyFile(t, server.TokenURL())
+
+	// The key fields are only a fallback, the file wins
+	sink, err := NewSink(Credentials{File: file, Email: "other@example.com"}, Connection{Endpoint: server.Endpoint()})
+	require.NoError(t, err)
+	tabs, err := sink.Sheets("sheet-id")
+	require.NoError(t, err)
+	require.Len(t, tabs, 1)
+	assert.Equal(t, OpenCasesSheet, tabs[0].Properties.Title)
+	assert.Equal(t, 1, server.Tokens(), "the token comes from the token_uri of the key")
+
+	_, err = NewSink(Credentials{File: filepath.Join(t.TempDir(), "missing.json")}, Connection{})
+	assert.Error(t, err)
+}
+
+func TestNewSink_ApplicationDefault(t *testing.T) {
+	server := sheetstest.NewServer("sheet-id")
+	defer server.Close()
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", writeKeyFile(t, server.TokenURL()))
+
+	sink, err := NewSink(Credentials{}, Connection{Endpoint: server.Endpoint()})
+	require.NoError(t, err)
+	_, err = sink.Sheets("sheet-id")
+	require.NoError(t, err)
+	assert.Equal(t, 1, server.Tokens())
+}
+
+func TestNewSink_IncompleteKey(t *testing.T) {
+	_, err := NewSink(Credentials{Email: "watcher@example.com", PrivateKey: sheetstest.PrivateKey()}, Connection{})
+	assert.Error(t, err, "the key id is missing")
+}
@@ -2,16 +2,13 @@ package spreadsheet
 
 import (
 	"fmt"
-	"github.com/jwmatthews/case_watcher/pkg/api"
-	"golang.org/x/net/context"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/jwt"
 	"google.golang.org/api/sheets/v4"
 	"log"
+	"strings"
 	"time"
 )
 
-func CreateIfSheetDoesNotExist(srv *sheets.Service, spreadsheetId, sheetName string) {
+func CreateIfSheetDoesNotExist(sink Sink, spreadsheetId, sheetName string) {
 	req := sheets.Request{
 		AddSheet: &sheets.AddSheetRequest{
 			Properties: &sheets.SheetProperties{
@@ -20,79 +17,271 @@ func CreateIfSheetDoesNotExist(srv *sheets.Service, spreadsheetId, sheetName str
 		},
 	}
 
-	rbb := &sheets.BatchUpdateSpreadsheetRequest{
-		Requests: []*sheets.Request{&req},
+	err := sink.Apply(spreadsheetId, []*sheets.Request{&req})
+	if err != nil {
+		log.Printf("Ignoring error from creation of sheet '%s', error message: %s", sheetName, err)
 	}
+}
+
+// Sheet names used by Upsert
+const (
+	OpenCasesSheet   = "Open Cases"
+	ClosedCasesSheet = "Closed Cases"
+)
+
+// Result describes what an update of the spreadsheet did
+type Result struct {
+	// LatestSheetId is the id of the tab always holding the latest open cases, to link to
+	LatestSheetId int64
+	Rotation      RotationPlan
+}
+
+// Update writes open cases to a new 'OpenCases - <date>' tab and to the 'Open Cases (latest)' tab, and closed cases
+// to the 'Closed Cases' tab, replacing anything previously written there. Older daily tabs are then rotated.
+func Update(sink Sink, spreadsheetId string, report Report, layout Layout, policy RotationPolicy) (Result, error) {
+	result := Result{}
+	currentDate := time.Now().Format("2006-01-02")
+	openCaseSheetName := DailySheetPrefix + currentDate
+	CreateIfSheetDoesNotExist(sink, spreadsheetId, openCaseSheetName)
+	openCaseSheetRange := a1(openCaseSheetName, "A1:ZZ")
+
+	closedCaseSheetName := ClosedCasesSheet
+	CreateIfSheetDoesNotExist(sink, spreadsheetId, closedCaseSheetName)
+	closedCaseSheetRange := a1(closedCaseSheetName, "A1:ZZ")
+
+	CreateIfSheetDoesNotExist(sink, spreadsheetId, LatestOpenCasesSheet)
+	latestSheetRange := a1(LatestOpenCasesSheet, "A1:ZZ")
 
-	_, err := srv.Spreadsheets.BatchUpdate(spreadsheetId, rbb).Context(context.Background()).Do()
+	openCaseValues := layout.Values(report.OpenCases, report.Accounts)
+	closedCaseValues := layout.Values(report.ClosedCases, report.Accounts)
+	err := UpdateSheet(sink, spreadsheetId, openCaseSheetRange, openCaseValues)
 	if err != nil {
-		log.Printf("Ignoring error from creation of sheet '%s', error message: %s", sheetName, err)
+		log.Printf("Error:  unable to write %s", openCaseSheetRange)
+		return result, err
+	}
+	err = UpdateSheet(sink, spreadsheetId, latestSheetRange, openCaseValues)
+	if err != nil {
+		log.Printf("Error:  unable to write %s", latestSheetRange)
+		return result, err
+	}
+
+	err = UpdateSheet(sink, spreadsheetId, closedCaseSheetRange, closedCaseValues)
+	if err != nil {
+		log.Printf("Error:  unable to write %s", closedCaseSheetRange)
+		return result, err
+	}
+	err = formatSheets(sink, spreadsheetId, layout, openCaseSheetName, LatestOpenCasesSheet, closedCaseSheetName)
+	if err != nil {
+		return result, err
 	}
+	err = writeTabs(sink, spreadsheetId, report, layout)
+	if err != nil {
+		return result, err
+	}
+	// Without an id column the weekly archive tells cases apart by their whole row
+	idHeader, _ := layout.IdHeader()
+	result.Rotation, err = rotateTabs(sink, spreadsheetId, policy, idHeader)
+	if err != nil {
+		return result, err
+	}
+	ids, err := sheetIds(sink, spreadsheetId)
+	result.LatestSheetId = ids[LatestOpenCasesSheet]
+	return result, err
 }
 
-func Update(spreadsheetId, email, privateKey, privateKeyId string, caseReport *api.CaseReport) error {
-
-	// See: https://stackoverflow.com/questions/58874474/unable-to-access-google-spreadsheets-with-a-service-account-credentials-using-go
-	// Create a JWT configurations object for the Google service account
-	conf := &jwt.Config{
-		Email:        email,
-		PrivateKey:   []byte(privateKey),
-		PrivateKeyID: privateKeyId,
-		TokenURL:     "https://oauth2.googleapis.com/token",
-		Scopes: []string{
-			"https://www.googleapis.com/auth/spreadsheets",
-		},
+func UpdateSheet(sink Sink, spreadsheetId, sheetRange string, values [][]interface{}) error {
+	err := sink.Clear(spreadsheetId, sheetRange)
+	if err != nil {
+		log.Printf("Error, failed to clear spreadsheet: %s %s, received error: %s\n", spreadsheetId, sheetRange, err)
+		return err
+	}
+	err = sink.Write(spreadsheetId, []*sheets.ValueRange{{Range: sheetRange, Values: values}})
+	if err != nil {
+		log.Printf("Error, failed to write to spreadsheet: %s %s, received error: %s\n", spreadsheetId, sheetRange, err)
+		return err
+	}
+	return nil
+}
+
+// Upsert brings the 'Open Cases' and 'Closed Cases' tabs in line with the report without rewriting them.
+// Rows are matched by case Id: changed rows are updated in place, new cases appended and a case which closed,
+// or reopened, is moved to the other tab along with the notes the team keeps in columns to the right of ours,
+// under the same headers.
+// The 'Open Cases' tab is the one to link to.
+func Upsert(sink Sink, spreadsheetId string, report Report, layout Layout) (Result, error) {
+	result := Result{}
+	idHeader, err := layout.IdHeader()
+	if err != nil {
+		return result, err
+	}
+	CreateIfSheetDoesNotExist(sink, spreadsheetId, OpenCasesSheet)
+	CreateIfSheetDoesNotExist(sink, spreadsheetId, ClosedCasesSheet)
+	sheetIds, err := sheetIds(sink, spreadsheetId)
+	if err != nil {
+		return result, err
+	}
+	openExisting, err := readSheet(sink, spreadsheetId, OpenCasesSheet)
+	if err != nil {
+		return result, err
+	}
+	closedExisting, err := readSheet(sink, spreadsheetId, ClosedCasesSheet)
+	if err != nil {
+		return result, err
 	}
 
-	client := conf.Client(oauth2.NoContext)
+	openWanted := layout.Values(report.OpenCases, report.Accounts)
+	closedWanted := layout.Values(report.ClosedCases, report.Accounts)
+	// The rows a case leaves behind on one tab do not depend on what is carried to it from the other
+	openPlan, err := PlanUpsert(openExisting, openWanted, idHeader, nil)
+	if err != nil {
+		return result, err
+	}
+	closedPlan, err := PlanUpsert(closedExisting, closedWanted, idHeader, openPlan.Moved)
+	if err != nil {
+		return result, err
+	}
+	openPlan, err = PlanUpsert(openExisting, openWanted, idHeader, closedPlan.Moved)
+	if err != nil {
+		return result, err
+	}
 
-	// Create a service object for Google sheets
-	srv, err := sheets.New(client)
+	// Make room for columns the layout gained before writing them
+	err = shiftUserColumns(sink, spreadsheetId, ClosedCasesSheet, sheetIds[ClosedCasesSheet], closedPlan)
+	if err == nil {
+		err = shiftUserColumns(sink, spreadsheetId, OpenCasesSheet, sheetIds[OpenCasesSheet], openPlan)
+	}
 	if err != nil {
-		log.Fatalf("Unable to retrieve Sheets client: %v", err)
+		return result, err
+	}
+	// Write every row before deleting any, so an interrupted run duplicates a moved case rather than losing it
+	err = writePlan(sink, spreadsheetId, ClosedCasesSheet, len(closedExisting), closedPlan)
+	if err == nil {
+		err = writePlan(sink, spreadsheetId, OpenCasesSheet, len(openExisting), openPlan)
+	}
+	if err == nil {
+		err = deleteRows(sink, spreadsheetId, sheetIds[ClosedCasesSheet], closedPlan.Deletes)
 	}
+	if err == nil {
+		err = deleteRows(sink, spreadsheetId, sheetIds[OpenCasesSheet], openPlan.Deletes)
+	}
+	if err == nil {
+		err = formatSheets(sink, spreadsheetId, layout, OpenCasesSheet, ClosedCasesSheet)
+	}
+	if err == nil {
+		err = writeTabs(sink, spreadsheetId, report, layout)
+	}
+	if err != nil {
+		return result, err
+	}
+	log.Printf("Spreadsheet upserted: open tab %d updated, %d added, %d removed; closed tab %d updated, %d added, %d removed\n",
+		len(openPlan.Updates), len(openPlan.Appends), len(openPlan.Deletes),
+		len(closedPlan.Updates), len(closedPlan.Appends), len(closedPlan.Deletes))
+	result.LatestSheetId = sheetIds[OpenCasesSheet]
+	return result, nil
+}
 
-	currentDate := time.Now().Format("2006-01-02")
-	openCaseSheetName := fmt.Sprintf("OpenCases - %s", currentDate)
-	CreateIfSheetDoesNotExist(srv, spreadsheetId, openCaseSheetName)
-	openCaseSheetRange := fmt.Sprintf("%s!A1:Z9999", openCaseSheetName)
+// a1 returns a cell reference in A1 notation, quoting the sheet name
+func a1(sheetName, cell string) string {
+	return fmt.Sprintf("'%s'!%s", strings.ReplaceAll(sheetName, "'", "''"), cell)
+}
 
-	closedCaseSheetName := "Closed Cases"
-	CreateIfSheetDoesNotExist(srv, spreadsheetId, closedCaseSheetName)
-	closedCaseSheetRange := fmt.Sprintf("%s!A1:Z9999", closedCaseSheetName)
+// sheetIds returns the id of each tab by its name
+func sheetIds(sink Sink, spreadsheetId string) (map[string]int64, error) {
+	tabs, err := sink.Sheets(spreadsheetId)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read spreadsheet %s: %w", spreadsheetId, err)
+	}
+	ids := map[string]int64{}
+	for _, sheet := range tabs {
+		ids[sheet.Properties.Title] = sheet.Properties.SheetId
+	}
+	return ids, nil
+}
 
-	openCaseValues, closedCaseValues := caseReport.ToCellValues()
-	err = UpdateSheet(srv, spreadsheetId, openCaseSheetRange, openCaseValues)
+// readSheet returns every row of a tab, formulas rather than their results and dates as shown,
+// so they compare with what we write
+func readSheet(sink Sink, spreadsheetId, sheetName string) ([][]interface{}, error) {
+	values, err := sink.Read(spreadsheetId, a1(sheetName, "A1:ZZ"))
 	if err != nil {
-		log.Printf("Error:  unable to write %s", openCaseSheetRange)
-		return err
+		return nil, fmt.Errorf("unable to read %s: %w", sheetName, err)
 	}
+	return values, nil
+}
 
-	err = UpdateSheet(srv, spreadsheetId, closedCaseSheetRange, closedCaseValues)
+// writePlan writes the updated and appended rows of a plan, rows is the number of rows the tab had when planned
+func writePlan(sink Sink, spreadsheetId, sheetName string, rows int, plan SheetPlan) error {
+	data := make([]*sheets.ValueRange, 0, len(plan.Updates)+len(plan.Appends))
+	for _, u := range plan.Updates {
+		data = append(data, &sheets.ValueRange{
+			Range:  a1(sheetName, fmt.Sprintf("A%d", u.Row+1)),
+			Values: [][]interface{}{u.Values},
+		})
+	}
+	if rows == 0 && len(plan.Appends) > 0 {
+		// The header update fills row 1 of an empty tab
+		rows = 1
+	}
+	for i, row := range plan.Appends {
+		data = append(data, &sheets.ValueRange{
+			Range:  a1(sheetName, fmt.Sprintf("A%d", rows+i+1)),
+			Values: [][]interface{}{row},
+		})
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	err := sink.Write(spreadsheetId, data)
 	if err != nil {
-		log.Printf("Error:  unable to write %s", closedCaseSheetRange)
+		log.Printf("Error, failed to write to spreadsheet: %s %s, received error: %s\n", spreadsheetId, sheetName, err)
 		return err
 	}
 	return nil
 }
 
-func UpdateSheet(srv *sheets.Service, spreadsheetId, sheetRange string, values [][]interface{}) error {
-	rb := &sheets.BatchUpdateValuesRequest{
-		ValueInputOption: "USER_ENTERED",
+// shiftUserColumns inserts the empty columns a plan makes room for, moving the user columns right
+func shiftUserColumns(sink Sink, spreadsheetId, sheetName string, sheetId int64, plan SheetPlan) error {
+	if plan.InsertColumns == 0 {
+		return nil
 	}
-	rb.Data = append(rb.Data, &sheets.ValueRange{
-		Range:  sheetRange,
-		Values: values,
-	})
-
-	_, err := srv.Spreadsheets.Values.Clear(spreadsheetId, sheetRange, &sheets.ClearValuesRequest{}).Context(context.Background()).Do()
+	log.Printf("The columns of %s changed, moving the columns from %d on %d to the right\n", sheetName, plan.UserColumn+1, plan.InsertColumns)
+	err := sink.Apply(spreadsheetId, []*sheets.Request{{
+		InsertDimension: &sheets.InsertDimensionRequest{
+			Range: &sheets.DimensionRange{
+				SheetId:    sheetId,
+				Dimension:  "COLUMNS",
+				StartIndex: int64(plan.UserColumn),
+				EndIndex:   int64(plan.UserColumn + plan.InsertColumns),
+			},
+		},
+	}})
 	if err != nil {
-		log.Printf("Error, failed to clear spreadsheet: %s %s, received error: %s\n", spreadsheetId, sheetRange, err)
+		log.Printf("Error, failed to insert columns in spreadsheet: %s %s, received error: %s\n", spreadsheetId, sheetName, err)
 		return err
 	}
-	_, err = srv.Spreadsheets.Values.BatchUpdate(spreadsheetId, rb).Context(context.Background()).Do()
+	return nil
+}
+
+// deleteRows removes rows from a tab, they must be given highest first
+func deleteRows(sink Sink, spreadsheetId string, sheetId int64, rows []int) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	requests := make([]*sheets.Request, 0, len(rows))
+	for _, row := range rows {
+		requests = append(requests, &sheets.Request{
+			DeleteDimension: &sheets.DeleteDimensionRequest{
+				Range: &sheets.DimensionRange{
+					SheetId:    sheetId,
+					Dimension:  "ROWS",
+					StartIndex: int64(row),
+					EndIndex:   int64(row + 1),
+				},
+			},
+		})
+	}
+	err := sink.Apply(spreadsheetId, requests)
 	if err != nil {
-		log.Printf("Error, failed to write to spreadsheet: %s %s, received error: %s\n", spreadsheetId, sheetRange, err)
+		log.Printf("Error, failed to delete rows from spreadsheet: %s, received error: %s\n", spreadsheetId, err)
 		return err
 	}
 	return nil
@@ -0,0 +1,153 @@
+package spreadsheet
+
+import (
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/jwmatthews/case_watcher/pkg/spreadsheet/sheetstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// newTestSink starts a stand-in holding the spreadsheets and returns a Sink authenticated with it
+func newTestSink(t *testing.T, spreadsheetIds ...string) (*sheetstest.Server, Sink) {
+	server := sheetstest.NewServer(spreadsheetIds...)
+	t.Cleanup(server.Close)
+	creds := Credentials{Email: "watcher@example.iam.gserviceaccount.com", PrivateKey: sheetstest.PrivateKey(), PrivateKeyId: "key-id"}
+	sink, err := NewSink(creds, Connection{Endpoint: server.Endpoint(), TokenURL: server.TokenURL()})
+	require.NoError(t, err)
+	return server, sink
+}
+
+var sinkLayout = Layout{Columns: []Column{{Field: "id"}, {Field: "severity"}, {Field: "summary"}}, Formatting: true}
+
+func TestUpdate(t *testing.T) {
+	server, sink := newTestSink(t, "sheet-id", "archive-id")
+	server.AddSheet("sheet-id", DailySheetPrefix+"2022-01-03", [][]interface{}{{"Id"}, {"0"}})
+	report := Report{
+		OpenCases:   []cache.Case{{Id: "1", Severity: "1 (Urgent)", Summary: "backup fails"}},
+		ClosedCases: []cache.Case{{Id: "2", Severity: "3 (Normal)", Summary: "restore fails"}},
+		Summary:     [][]interface{}{{"Open", 1}, {"Closed", 1}},
+	}
+	policy := RotationPolicy{DailyTabs: 1, Archive: ArchiveSpreadsheet, ArchiveSpreadsheet: "archive-id"}
+
+	result, err := Update(sink, "sheet-id", report, sinkLayout, policy)
+	require.NoError(t, err)
+	today := DailySheetPrefix + time.Now().Format("2006-01-02")
+	open := [][]interface{}{{"Id", "Severity", "Summary"}, {"1", "1 (Urgent)", "backup fails"}}
+	closed := [][]interface{}{{"Id", "Severity", "Summary"}, {"2", "3 (Normal)", "restore fails"}}
+	assert.Equal(t, []sheetstest.Write{
+		{SpreadsheetId: "sheet-id", Range: "'" + today + "'!A1:ZZ", Values: open},
+		{SpreadsheetId: "sheet-id", Range: "'Open Cases (latest)'!A1:ZZ", Values: open},
+		{SpreadsheetId: "sheet-id", Range: "'Closed Cases'!A1:ZZ", Values: closed},
+		{SpreadsheetId: "sheet-id", Range: "'Summary'!A1:ZZ", Values: [][]interface{}{{"Open", 1.0}, {"Closed", 1.0}}},
+	}, server.Writes())
+	assert.Equal(t, []string{today, ClosedCasesSheet, LatestOpenCasesSheet, SummarySheet}, server.Titles("sheet-id"))
+	assert.Equal(t, server.Sheet("sheet-id", LatestOpenCasesSheet).Properties.SheetId, result.LatestSheetId)
+	assert.Equal(t, []string{DailySheetPrefix + "2022-01-03"}, result.Rotation.Archive)
+	assert.Equal(t, [][]interface{}{{"Id"}, {"0"}}, server.Values("archive-id", DailySheetPrefix+"2022-01-03"))
+
+	latest := server.Sheet("sheet-id", LatestOpenCasesSheet)
+	assert.Equal(t, int64(1), latest.Properties.GridProperties.FrozenRowCount)
+	require.Len(t, latest.ConditionalFormats, 2, "the severity colors")
+	assert.Equal(t, int64(0), server.Sheet("sheet-id", SummarySheet).Properties.GridProperties.FrozenRowCount,
+		"the summary lists no cases")
+	assert.Equal(t, 1, server.Tokens())
+
+	// Updating again the same day rewrites the same tabs, without formatting them twice
+	_, err = Update(sink, "sheet-id", Report{OpenCases: report.OpenCases}, sinkLayout, policy)
+	require.NoError(t, err)
+	assert.Equal(t, [][]interface{}{{"Id", "Severity", "Summary"}}, server.Values("sheet-id", ClosedCasesSheet))
+	assert.Len(t, server.Sheet("sheet-id", LatestOpenCasesSheet).ConditionalFormats, 2)
+	assert.Len(t, server.Titles("sheet-id"), 4)
+}
+
+func TestUpdate_WeeklyArchiveKeepsEveryCase(t *testing.T) {
+	server, sink := newTestSink(t, "sheet-id")
+	header := []interface{}{"Id", "Severity", "Summary"}
+	server.AddSheet("sheet-id", WeeklySheetPrefix+"2022-W10", [][]interface{}{header, {"case-a", "3 (Normal)", "backup fails"}})
+	server.AddSheet("sheet-id", DailySheetPrefix+"2022-03-09", [][]interface{}{header, {"case-a", "2 (High)", "backup fails"}, {"case-b", "4 (Low)", "docs"}})
+	server.AddSheet("sheet-id", DailySheetPrefix+"2022-03-10", [][]interface{}{header, {"case-c", "3 (Normal)", "slow restore"}})
+	policy := RotationPolicy{DailyTabs: 1, Archive: ArchiveWeekly}
+
+	result, err := Update(sink, "sheet-id", Report{}, sinkLayout, policy)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{DailySheetPrefix + "2022-03-10": WeeklySheetPrefix + "2022-W10"}, result.Rotation.Rename)
+	assert.Equal(t, [][]interface{}{
+		header,
+		{"case-c", "3 (Normal)", "slow restore"},
+		{"case-a", "2 (High)", "backup fails"},
+		{"case-b", "4 (Low)", "docs"},
+	}, server.Values("sheet-id", WeeklySheetPrefix+"2022-W10"), "the cases of every day of the week, as last seen")
+	assert.NotContains(t, server.Titles("sheet-id"), DailySheetPrefix+"2022-03-09")
+}
+
+func TestUpsert(t *testing.T) {
+	server, sink := newTestSink(t, "sheet-id")
+	server.AddSheet("sheet-id", OpenCasesSheet, [][]interface{}{
+		{"Id", "Severity", "Summary", "Notes"},
+		{"1", "2 (High)", "backup fails", "waiting on a fix"},
+		{"3", "3 (Normal)", "slow restore"},
+	})
+	report := Report{
+		OpenCases:   []cache.Case{{Id: "3", Severity: "2 (High)", Summary: "slow restore"}, {Id: "4", Severity: "4 (Low)", Summary: "docs"}},
+		ClosedCases: []cache.Case{{Id: "1", Severity: "2 (High)", Summary: "backup fails"}},
+	}
+
+	result, err := Upsert(sink, "sheet-id", report, sinkLayout)
+	require.NoError(t, err)
+	assert.Equal(t, server.Sheet("sheet-id", OpenCasesSheet).Properties.SheetId, result.LatestSheetId)
+	assert.Equal(t, [][]interface{}{
+		{"Id", "Severity", "Summary", "Notes"},
+		{float64(3), "2 (High)", "slow restore"},
+		{float64(4), "4 (Low)", "docs"},
+	}, server.Values("sheet-id", OpenCasesSheet))
+	assert.Equal(t, [][]interface{}{
+		{"Id", "Severity", "Summary", "Notes"},
+		{float64(1), "2 (High)", "backup fails", "waiting on a fix"},
+	}, server.Values("sheet-id", ClosedCasesSheet), "the case moves along with the team's notes and their header")
+
+	// Nothing changed, nothing is written
+	writes := len(server.Writes())
+	_, err = Upsert(sink, "sheet-id", report, sinkLayout)
+	require.NoError(t, err)
+	assert.Len(t, server.Writes(), writes)
+
+	// Sheets parses the ids written as numbers, they still match the cases
+	// A column added to the layout goes before the team's
+	wider := Layout{Columns: append(append([]Column{}, sinkLayout.Columns...), Column{Field: "type"})}
+	report.OpenCases[0].Type = "Bug"
+	_, err = Upsert(sink, "sheet-id", report, wider)
+	require.NoError(t, err)
+	assert.Equal(t, [][]interface{}{
+		{"Id", "Severity", "Summary", "Type", "Notes"},
+		{float64(3), "2 (High)", "slow restore", "Bug"},
+		{float64(4), "4 (Low)", "docs"},
+	}, server.Values("sheet-id", OpenCasesSheet))
+	assert.Equal(t, [][]interface{}{
+		{"Id", "Severity", "Summary", "Type", "Notes"},
+		{float64(1), "2 (High)", "backup fails", "", "waiting on a fix"},
+	}, server.Values("sheet-id", ClosedCasesSheet), "the team's notes stay under their header")
+}
+
+func TestUpsert_ValuesParsedBySheets(t *testing.T) {
+	server, sink := newTestSink(t, "sheet-id")
+	created := time.Date(2022, 1, 3, 9, 30, 0, 0, time.UTC)
+	layout := Layout{
+		Columns:    []Column{{Field: "id"}, {Field: "uri"}, {Field: "created"}, {Field: "escalated"}},
+		DateFormat: "2006-01-02 15:04:05",
+	}
+	report := Report{OpenCases: []cache.Case{{Id: "5001", Uri: "https://example.com/5001", CreatedDate: created, CustomerEscalation: true}}}
+
+	_, err := Upsert(sink, "sheet-id", report, layout)
+	require.NoError(t, err)
+	assert.Equal(t, [][]interface{}{
+		{"Id", "Uri", "CreatedDate", "CustomerEscalation"},
+		{float64(5001), `=HYPERLINK("https://example.com/5001")`, "1/3/2022 9:30:00", true},
+	}, server.Values("sheet-id", OpenCasesSheet))
+
+	writes := len(server.Writes())
+	_, err = Upsert(sink, "sheet-id", report, layout)
+	require.NoError(t, err)
+	assert.Len(t, server.Writes(), writes, "values read back as Sheets shows them are unchanged")
+}
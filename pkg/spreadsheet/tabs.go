@@ -0,0 +1,241 @@
+package spreadsheet
+
+import (
+	"fmt"
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Names of the tabs written in addition to the open and closed cases
+const (
+	ActiveCasesSheet = "Active Cases"
+	SummarySheet     = "Summary"
+	// ProductSheetPrefix starts the name of the tab of each product, e.g. 'Product - OpenShift'
+	ProductSheetPrefix = "Product - "
+)
+
+// TabOptions selects the tabs written in addition to the open and closed cases
+type TabOptions struct {
+	// ActiveDays adds a tab of the cases modified in this many days, 0 leaves it out
+	ActiveDays int `mapstructure:"active_days"`
+	// Products adds a tab of the open cases of each product
+	Products bool `mapstructure:"products"`
+	// Summary adds a tab counting cases by status, severity, product and triage
+	Summary bool `mapstructure:"summary"`
+}
+
+// Tab is a tab rewritten as a whole on each update
+type Tab struct {
+	Name   string
+	Values [][]interface{}
+	// Cases is true for tabs listing cases with the Layout, which are formatted as the open and closed tabs are
+	Cases bool
+}
+
+// BuildReport reads the cases matching the filter from the cache, along with the tabs selected by opts
+func BuildReport(s cache.Store, f cache.CaseFilter, now time.Time, opts TabOptions) (Report, error) {
+	cases, err := s.ListCases(f)
+	if err != nil {
+		return Report{}, err
+	}
+	report := Report{OpenCases: []cache.Case{}, ClosedCases: []cache.Case{}}
+	for _, c := range cases {
+		if c.Status == "Closed" {
+			report.ClosedCases = append(report.ClosedCases, c)
+		} else {
+			report.OpenCases = append(report.OpenCases, c)
+		}
+	}
+	if opts.ActiveDays > 0 {
+		report.ActiveDays = opts.ActiveDays
+		report.ActiveCases = []cache.Case{}
+		since := now.AddDate(0, 0, -opts.ActiveDays)
+		for _, c := range cases {
+			if !c.LastModifiedDate.Before(since) {
+				report.ActiveCases = append(report.ActiveCases, c)
+			}
+		}
+	}
+	if opts.Products {
+		report.ProductCases = map[string][]cache.Case{}
+		for _, c := range report.OpenCases {
+			for _, name := range productNames(c) {
+				report.ProductCases[name] = append(report.ProductCases[name], c)
+			}
+		}
+	}
+	if opts.Summary {
+		report.Summary = summaryValues(report.OpenCases, report.ClosedCases, cases, now, opts.ActiveDays)
+	}
+	return report, nil
+}
+
+// productNames returns the distinct product names of a case
+func productNames(c cache.Case) []string {
+	names := make([]string, 0, len(c.Products))
+	seen := map[string]bool{}
+	for _, p := range c.Products {
+		if !seen[p.Name] {
+			seen[p.Name] = true
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
+
+// summaryValues counts cases by status, severity, product and triage
+func summaryValues(open, closed, all []cache.Case, now time.Time, activeDays int) [][]interface{} {
+	values := [][]interface{}{
+		{"Updated", now.UTC().Format("2006-01-02 15:04 MST")},
+		{},
+		{"Cases", "Count"},
+		{"Open", len(open)},
+		{"Closed", len(closed)},
+	}
+	if activeDays > 0 {
+		since := now.AddDate(0, 0, -activeDays)
+		active, opened := 0, 0
+		for _, c := range all {
+			if !c.LastModifiedDate.Before(since) {
+				active++
+			}
+			if !c.CreatedDate.Before(since) {
+				opened++
+			}
+		}
+		values = append(values,
+			[]interface{}{fmt.Sprintf("Modified in past %d days", activeDays), active},
+			[]interface{}{fmt.Sprintf("Opened in past %d days", activeDays), opened})
+	}
+
+	values = append(values, []interface{}{}, []interface{}{"Open by severity", "Count"})
+	values = append(values, countRows(open, func(c cache.Case) []string { return []string{c.Severity} })...)
+
+	values = append(values, []interface{}{}, []interface{}{"Open by triage", "Count"})
+	values = append(values, countRows(open, func(c cache.Case) []string {
+		if c.Relevance == cache.RelevanceUnknown {
+			return []string{cache.TriageUntriaged}
+		}
+		return []string{c.Relevance}
+	})...)
+
+	values = append(values, []interface{}{}, []interface{}{"By product", "Open", "Closed"})
+	openByProduct := counts(open, productNames)
+	closedByProduct := counts(closed, productNames)
+	names := make([]string, 0, len(openByProduct)+len(closedByProduct))
+	for name := range openByProduct {
+		names = append(names, name)
+	}
+	for name := range closedByProduct {
+		if _, ok := openByProduct[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values = append(values, []interface{}{name, openByProduct[name], closedByProduct[name]})
+	}
+	return values
+}
+
+func counts(cases []cache.Case, keys func(c cache.Case) []string) map[string]int {
+	result := map[string]int{}
+	for _, c := range cases {
+		for _, key := range keys(c) {
+			result[key]++
+		}
+	}
+	return result
+}
+
+// countRows counts cases by key, one row per key in order, an empty key is shown as '(none)'
+func countRows(cases []cache.Case, keys func(c cache.Case) []string) [][]interface{} {
+	byKey := counts(cases, keys)
+	sorted := make([]string, 0, len(byKey))
+	for key := range byKey {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	rows := make([][]interface{}, 0, len(sorted))
+	for _, key := range sorted {
+		label := key
+		if label == "" {
+			label = "(none)"
+		}
+		rows = append(rows, []interface{}{label, byKey[key]})
+	}
+	return rows
+}
+
+// Tabs returns the tabs of the report written in addition to the open and closed cases
+func (r Report) Tabs(layout Layout) []Tab {
+	tabs := make([]Tab, 0)
+	if r.ActiveCases != nil {
+		tabs = append(tabs, Tab{Name: ActiveCasesSheet, Values: layout.Values(r.ActiveCases, r.Accounts), Cases: true})
+	}
+	names := make([]string, 0, len(r.ProductCases))
+	for name := range r.ProductCases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tabs = append(tabs, Tab{Name: ProductSheetPrefix + name, Values: layout.Values(r.ProductCases[name], r.Accounts), Cases: true})
+	}
+	if r.Summary != nil {
+		tabs = append(tabs, Tab{Name: SummarySheet, Values: r.Summary})
+	}
+	return tabs
+}
+
+// Workbook returns every tab of the report, the open and closed cases first, for writing to a file
+func (r Report) Workbook(layout Layout) []Tab {
+	tabs := []Tab{
+		{Name: OpenCasesSheet, Values: layout.Values(r.OpenCases, r.Accounts), Cases: true},
+		{Name: ClosedCasesSheet, Values: layout.Values(r.ClosedCases, r.Accounts), Cases: true},
+	}
+	return append(tabs, r.Tabs(layout)...)
+}
+
+// writeTabs rewrites the tabs of the report, creating those missing. The tabs of products no longer
+// having open cases are cleared rather than deleted, in case the team links to them.
+func writeTabs(sink Sink, spreadsheetId string, r Report, layout Layout) error {
+	tabs := r.Tabs(layout)
+	if len(tabs) == 0 {
+		return nil
+	}
+	ids, err := sheetIds(sink, spreadsheetId)
+	if err != nil {
+		return err
+	}
+	written := map[string]bool{}
+	formatted := make([]string, 0, len(tabs))
+	for _, tab := range tabs {
+		if _, ok := ids[tab.Name]; !ok {
+			CreateIfSheetDoesNotExist(sink, spreadsheetId, tab.Name)
+		}
+		err = UpdateSheet(sink, spreadsheetId, a1(tab.Name, "A1:ZZ"), tab.Values)
+		if err != nil {
+			return err
+		}
+		written[tab.Name] = true
+		if tab.Cases {
+			formatted = append(formatted, tab.Name)
+		}
+	}
+	if r.ProductCases != nil {
+		for name := range ids {
+			if !strings.HasPrefix(name, ProductSheetPrefix) || written[name] {
+				continue
+			}
+			err = sink.Clear(spreadsheetId, a1(name, "A1:ZZ"))
+			if err != nil {
+				log.Printf("Error, failed to clear spreadsheet: %s %s, received error: %s\n", spreadsheetId, name, err)
+				return err
+			}
+		}
+	}
+	return formatSheets(sink, spreadsheetId, layout, formatted...)
+}
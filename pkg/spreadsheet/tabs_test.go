@@ -0,0 +1,83 @@
+package spreadsheet
+
+import (
+	"github.com/jwmatthews/case_watcher/pkg/api"
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestBuildReport(t *testing.T) {
+	now := time.Date(2022, 3, 10, 12, 0, 0, 0, time.UTC)
+	c, err := cache.Init(cache.MemoryDSN)
+	require.NoError(t, err)
+	defer c.Close()
+	_, err = c.StoreCases([]api.Case{
+		{Id: "1", Status: "Open", Severity: "1 (Urgent)", Products: []string{"OpenShift", "OADP"},
+			CreatedDate: now.AddDate(0, 0, -2), LastModifiedDate: now.AddDate(0, 0, -1)},
+		{Id: "2", Status: "Waiting on Red Hat", Severity: "3 (Normal)", Products: []string{"OpenShift"},
+			CreatedDate: now.AddDate(0, -2, 0), LastModifiedDate: now.AddDate(0, 0, -20)},
+		{Id: "3", Status: "Closed", Severity: "3 (Normal)", Products: []string{"MTC"},
+			CreatedDate: now.AddDate(0, -1, 0), LastModifiedDate: now.AddDate(0, 0, -3)},
+	})
+	require.NoError(t, err)
+	require.NoError(t, c.SetTriage("1", cache.RelevanceRelevant, ""))
+
+	report, err := BuildReport(&c, cache.CaseFilter{SortBy: "id"}, now, TabOptions{ActiveDays: 7, Products: true, Summary: true})
+	require.NoError(t, err)
+	ids := func(cases []cache.Case) []string {
+		result := make([]string, 0, len(cases))
+		for _, c := range cases {
+			result = append(result, c.Id)
+		}
+		return result
+	}
+	assert.Equal(t, []string{"1", "2"}, ids(report.OpenCases))
+	assert.Equal(t, []string{"3"}, ids(report.ClosedCases))
+	assert.Equal(t, []string{"1", "3"}, ids(report.ActiveCases))
+	assert.Equal(t, []string{"1"}, ids(report.ProductCases["OADP"]))
+	assert.Equal(t, []string{"1", "2"}, ids(report.ProductCases["OpenShift"]))
+	assert.NotContains(t, report.ProductCases, "MTC", "product tabs list open cases only")
+	assert.Equal(t, [][]interface{}{
+		{"Updated", "2022-03-10 12:00 UTC"},
+		{},
+		{"Cases", "Count"},
+		{"Open", 2},
+		{"Closed", 1},
+		{"Modified in past 7 days", 2},
+		{"Opened in past 7 days", 1},
+		{},
+		{"Open by severity", "Count"},
+		{"1 (Urgent)", 1},
+		{"3 (Normal)", 1},
+		{},
+		{"Open by triage", "Count"},
+		{cache.RelevanceRelevant, 1},
+		{cache.TriageUntriaged, 1},
+		{},
+		{"By product", "Open", "Closed"},
+		{"MTC", 0, 1},
+		{"OADP", 1, 0},
+		{"OpenShift", 2, 0},
+	}, report.Summary)
+
+	layout := Layout{Columns: []Column{{Field: "id"}, {Field: "status"}}}
+	tabs := report.Tabs(layout)
+	names := make([]string, 0, len(tabs))
+	for _, tab := range tabs {
+		names = append(names, tab.Name)
+	}
+	assert.Equal(t, []string{ActiveCasesSheet, "Product - OADP", "Product - OpenShift", SummarySheet}, names)
+	assert.Equal(t, [][]interface{}{{"Id", "Status"}, {"1", "Open"}, {"3", "Closed"}}, tabs[0].Values)
+	assert.True(t, tabs[0].Cases)
+	assert.False(t, tabs[3].Cases)
+
+	// Only the open and closed tabs without options, limited by the filter
+	report, err = BuildReport(&c, cache.CaseFilter{Triage: cache.TriageUntriaged, SortBy: "id"}, now, TabOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2"}, ids(report.OpenCases))
+	assert.Equal(t, []string{"3"}, ids(report.ClosedCases))
+	assert.Empty(t, report.Tabs(layout))
+}
@@ -0,0 +1,109 @@
+package trends
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"strings"
+)
+
+// Line is a named series of values drawn by Chart, one value per label
+type Line struct {
+	Name   string
+	Values []float64
+}
+
+// palette colors the lines of a chart in turn
+var palette = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f"}
+
+// Size and margins of a chart, the legend is drawn in the right margin
+const (
+	chartWidth  = 640
+	chartHeight = 240
+	marginLeft  = 48
+	marginRight = 160
+	marginTop   = 28
+	marginBot   = 40
+	// maxLabels is how many labels at most are written along the x axis
+	maxLabels = 8
+)
+
+// Chart returns an SVG line chart of the lines over the labels, self-contained to be inlined in an HTML email
+func Chart(title string, labels []string, lines []Line) string {
+	plotWidth := float64(chartWidth - marginLeft - marginRight)
+	plotHeight := float64(chartHeight - marginTop - marginBot)
+	top := 1.0
+	for _, l := range lines {
+		for _, v := range l.Values {
+			top = math.Max(top, v)
+		}
+	}
+	top = math.Ceil(top)
+	x := func(i int) float64 {
+		if len(labels) < 2 {
+			return marginLeft + plotWidth/2
+		}
+		return marginLeft + plotWidth*float64(i)/float64(len(labels)-1)
+	}
+	y := func(v float64) float64 {
+		return marginTop + plotHeight*(1-v/top)
+	}
+
+	b := strings.Builder{}
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="11">`,
+		chartWidth, chartHeight, chartWidth, chartHeight)
+	fmt.Fprintf(&b, `<text x="%d" y="16" font-size="13" font-weight="bold">%s</text>`, marginLeft, template.HTMLEscapeString(title))
+	// Axes, with the top and bottom of the scale
+	fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#999"/>`, marginLeft, y(0), marginLeft, y(top))
+	fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#999"/>`, marginLeft, y(0), marginLeft+plotWidth, y(0))
+	fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#eee"/>`, marginLeft, y(top), marginLeft+plotWidth, y(top))
+	fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end">0</text>`, marginLeft-4, y(0)+4)
+	fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end">%g</text>`, marginLeft-4, y(top)+4, top)
+	step := (len(labels) + maxLabels - 1) / maxLabels
+	for i, label := range labels {
+		if i%step != 0 && i != len(labels)-1 {
+			continue
+		}
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`, x(i), chartHeight-marginBot+16,
+			template.HTMLEscapeString(label))
+	}
+	for n, l := range lines {
+		color := palette[n%len(palette)]
+		points := make([]string, 0, len(l.Values))
+		for i, v := range l.Values {
+			points = append(points, fmt.Sprintf("%.1f,%.1f", x(i), y(v)))
+		}
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="2" points="%s"/>`, color, strings.Join(points, " "))
+		legendY := marginTop + 16*n
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="10" height="10" fill="%s"/>`, chartWidth-marginRight+12, legendY, color)
+		fmt.Fprintf(&b, `<text x="%d" y="%d">%s</text>`, chartWidth-marginRight+26, legendY+9, template.HTMLEscapeString(l.Name))
+	}
+	b.WriteString("</svg>")
+	return b.String()
+}
+
+// Backlogs returns a Line of the backlog of each series, e.g. to chart the backlog by severity
+func Backlogs(series []Series) []Line {
+	lines := make([]Line, 0, len(series))
+	for _, s := range series {
+		values := make([]float64, 0, len(s.Periods))
+		for _, p := range s.Periods {
+			values = append(values, float64(p.Backlog))
+		}
+		lines = append(lines, Line{Name: s.Name, Values: values})
+	}
+	return lines
+}
+
+// Flow returns the Lines of the cases opened and closed and the backlog of a series
+func Flow(s Series) []Line {
+	opened := Line{Name: "Opened"}
+	closed := Line{Name: "Closed"}
+	backlog := Line{Name: "Backlog"}
+	for _, p := range s.Periods {
+		opened.Values = append(opened.Values, float64(p.Opened))
+		closed.Values = append(closed.Values, float64(p.Closed))
+		backlog.Values = append(backlog.Values, float64(p.Backlog))
+	}
+	return []Line{opened, closed, backlog}
+}
@@ -0,0 +1,216 @@
+// Package trends counts the cases opened and closed, the open backlog and the time to close over weekly or monthly
+// periods, in total and by severity and product, from the cached cases and the history of their status
+package trends
+
+import (
+	"fmt"
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"sort"
+	"time"
+)
+
+// Intervals a period can span
+const (
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+)
+
+// ClosedStatus is the status of a closed case
+const ClosedStatus = "Closed"
+
+// Options choose the periods trends are computed over
+type Options struct {
+	// Interval is IntervalWeek or IntervalMonth
+	Interval string `mapstructure:"interval"`
+	// Periods is how many intervals, the current one last, no trends are computed when 0
+	Periods int `mapstructure:"periods"`
+}
+
+// Validate checks the interval is known and the number of periods is not negative
+func (o Options) Validate() error {
+	if o.Interval != IntervalWeek && o.Interval != IntervalMonth {
+		return fmt.Errorf("unknown interval '%s', expected %s or %s", o.Interval, IntervalWeek, IntervalMonth)
+	}
+	if o.Periods < 0 {
+		return fmt.Errorf("periods must not be negative, got %d", o.Periods)
+	}
+	return nil
+}
+
+// Period holds the counts of one interval, from Start up to End
+type Period struct {
+	Start time.Time
+	End   time.Time
+	// Opened and Closed count the cases created and closed in the period
+	Opened int
+	Closed int
+	// Backlog counts the cases open at the End of the period, or now for the current period
+	Backlog int
+	// MedianTimeToClose is the median time from creation to closing of the cases closed in the period, 0 when none were
+	MedianTimeToClose time.Duration
+}
+
+// Series are the periods of all cases, or of a severity or product
+type Series struct {
+	Name    string
+	Periods []Period
+}
+
+// Trends are the periods of all cases along with those of each severity and product
+type Trends struct {
+	Interval   string
+	Total      Series
+	BySeverity []Series
+	ByProduct  []Series
+}
+
+// Periods returns the empty periods of the interval ending with the one holding now, oldest first.
+// Weeks start on Monday, both weeks and months at midnight in the location of now.
+func Periods(o Options, now time.Time) ([]Period, error) {
+	err := o.Validate()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	next := func(t time.Time, n int) time.Time { return t.AddDate(0, 0, 7*n) }
+	if o.Interval == IntervalWeek {
+		start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+	} else {
+		start = start.AddDate(0, 0, 1-start.Day())
+		next = func(t time.Time, n int) time.Time { return t.AddDate(0, n, 0) }
+	}
+	periods := make([]Period, 0, o.Periods)
+	for i := o.Periods - 1; i >= 0; i-- {
+		periods = append(periods, Period{Start: next(start, -i), End: next(start, -i+1)})
+	}
+	return periods, nil
+}
+
+// ClosedDates returns when each closed case was closed, by case id: the last time its status history shows it
+// closing, or its last modification when it was closed before it was first cached
+func ClosedDates(cases []cache.Case, statusHistory []cache.CaseHistory) map[string]time.Time {
+	closing := map[string]time.Time{}
+	for _, h := range statusHistory {
+		if h.NewValue == ClosedStatus && h.ChangedAt.After(closing[h.CaseId]) {
+			closing[h.CaseId] = h.ChangedAt
+		}
+	}
+	closed := map[string]time.Time{}
+	for _, c := range cases {
+		if c.Status != ClosedStatus {
+			continue
+		}
+		if t, ok := closing[c.Id]; ok {
+			closed[c.Id] = t
+		} else {
+			closed[c.Id] = c.LastModifiedDate
+		}
+	}
+	return closed
+}
+
+// Compute returns the trends of the cases over the periods ending now, closed giving when closed cases were closed
+func Compute(cases []cache.Case, closed map[string]time.Time, o Options, now time.Time) (Trends, error) {
+	periods, err := Periods(o, now)
+	if err != nil {
+		return Trends{}, err
+	}
+	bySeverity := map[string][]cache.Case{}
+	byProduct := map[string][]cache.Case{}
+	for _, c := range cases {
+		bySeverity[c.Severity] = append(bySeverity[c.Severity], c)
+		seen := map[string]bool{}
+		for _, p := range c.Products {
+			// A case lists a product once per version
+			if !seen[p.Name] {
+				seen[p.Name] = true
+				byProduct[p.Name] = append(byProduct[p.Name], c)
+			}
+		}
+	}
+	return Trends{
+		Interval:   o.Interval,
+		Total:      series("Total", cases, closed, periods, now),
+		BySeverity: group(bySeverity, closed, periods, now),
+		ByProduct:  group(byProduct, closed, periods, now),
+	}, nil
+}
+
+// group returns the series of each group of cases, by name
+func group(groups map[string][]cache.Case, closed map[string]time.Time, periods []Period, now time.Time) []Series {
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := make([]Series, 0, len(names))
+	for _, name := range names {
+		result = append(result, series(name, groups[name], closed, periods, now))
+	}
+	return result
+}
+
+// series counts the cases in each of the periods
+func series(name string, cases []cache.Case, closed map[string]time.Time, periods []Period, now time.Time) Series {
+	s := Series{Name: name, Periods: make([]Period, 0, len(periods))}
+	for _, p := range periods {
+		end := p.End
+		if end.After(now) {
+			end = now
+		}
+		durations := make([]time.Duration, 0)
+		for _, c := range cases {
+			if !c.CreatedDate.Before(p.Start) && c.CreatedDate.Before(end) {
+				p.Opened++
+			}
+			closedAt, isClosed := closed[c.Id]
+			if isClosed && !closedAt.Before(p.Start) && closedAt.Before(end) {
+				p.Closed++
+				durations = append(durations, closedAt.Sub(c.CreatedDate))
+			}
+			if c.CreatedDate.Before(end) && (!isClosed || !closedAt.Before(end)) {
+				p.Backlog++
+			}
+		}
+		p.MedianTimeToClose = median(durations)
+		s.Periods = append(s.Periods, p)
+	}
+	return s
+}
+
+func median(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	middle := len(durations) / 2
+	if len(durations)%2 == 0 {
+		return (durations[middle-1] + durations[middle]) / 2
+	}
+	return durations[middle]
+}
+
+// Days returns a duration in days rounded to a tenth, empty for 0, e.g. a median time to close when nothing closed
+func Days(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.1f", d.Hours()/24)
+}
+
+// Label names a period by its start, e.g. "2022-01-03" for a week and "2022-01" for a month
+func (t Trends) Label(p Period) string {
+	if t.Interval == IntervalMonth {
+		return p.Start.Format("2006-01")
+	}
+	return p.Start.Format("2006-01-02")
+}
+
+// Labels returns the label of each period of the trends
+func (t Trends) Labels() []string {
+	labels := make([]string, 0, len(t.Total.Periods))
+	for _, p := range t.Total.Periods {
+		labels = append(labels, t.Label(p))
+	}
+	return labels
+}
@@ -0,0 +1,103 @@
+package trends
+
+import (
+	"github.com/jwmatthews/case_watcher/pkg/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Wednesday
+var now = time.Date(2022, 3, 16, 12, 0, 0, 0, time.UTC)
+
+func TestPeriods(t *testing.T) {
+	weeks, err := Periods(Options{Interval: IntervalWeek, Periods: 3}, now)
+	require.NoError(t, err)
+	require.Len(t, weeks, 3)
+	assert.Equal(t, time.Date(2022, 2, 28, 0, 0, 0, 0, time.UTC), weeks[0].Start, "weeks start on Monday")
+	assert.Equal(t, time.Date(2022, 3, 21, 0, 0, 0, 0, time.UTC), weeks[2].End)
+	assert.Equal(t, weeks[0].End, weeks[1].Start)
+
+	months, err := Periods(Options{Interval: IntervalMonth, Periods: 2}, now)
+	require.NoError(t, err)
+	assert.Equal(t, []time.Time{time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)},
+		[]time.Time{months[0].Start, months[1].Start})
+
+	_, err = Periods(Options{Interval: "day", Periods: 2}, now)
+	assert.Error(t, err)
+}
+
+func TestClosedDates(t *testing.T) {
+	cases := []cache.Case{
+		{Id: "reopened", Status: ClosedStatus, LastModifiedDate: now},
+		{Id: "first-seen-closed", Status: ClosedStatus, LastModifiedDate: now.AddDate(0, 0, -1)},
+		{Id: "open", Status: "Waiting on Red Hat"},
+	}
+	history := []cache.CaseHistory{
+		{CaseId: "reopened", NewValue: ClosedStatus, ChangedAt: now.AddDate(0, 0, -9)},
+		{CaseId: "reopened", NewValue: "Waiting on Red Hat", ChangedAt: now.AddDate(0, 0, -8)},
+		{CaseId: "reopened", NewValue: ClosedStatus, ChangedAt: now.AddDate(0, 0, -2)},
+		{CaseId: "open", NewValue: ClosedStatus, ChangedAt: now.AddDate(0, 0, -5)},
+	}
+	assert.Equal(t, map[string]time.Time{
+		"reopened":          now.AddDate(0, 0, -2),
+		"first-seen-closed": now.AddDate(0, 0, -1),
+	}, ClosedDates(cases, history))
+}
+
+func TestCompute(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2022, 3, d, 9, 0, 0, 0, time.UTC) }
+	ocp := cache.Product{Name: "OpenShift"}
+	cases := []cache.Case{
+		{Id: "1", Severity: "2 (High)", CreatedDate: day(1), Products: []cache.Product{ocp, {Name: "OpenShift", Version: "4.9"}}},
+		{Id: "2", Severity: "3 (Normal)", CreatedDate: day(2), Status: ClosedStatus},
+		{Id: "3", Severity: "2 (High)", CreatedDate: day(9), Status: ClosedStatus, Products: []cache.Product{ocp}},
+		{Id: "4", Severity: "3 (Normal)", CreatedDate: day(15)},
+		{Id: "old", Severity: "3 (Normal)", CreatedDate: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	closed := map[string]time.Time{"2": day(4), "3": day(15)}
+
+	trends, err := Compute(cases, closed, Options{Interval: IntervalWeek, Periods: 3}, now)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2022-02-28", "2022-03-07", "2022-03-14"}, trends.Labels())
+	total := trends.Total.Periods
+	assert.Equal(t, []int{2, 1, 1}, []int{total[0].Opened, total[1].Opened, total[2].Opened})
+	assert.Equal(t, []int{1, 0, 1}, []int{total[0].Closed, total[1].Closed, total[2].Closed})
+	assert.Equal(t, []int{2, 3, 3}, []int{total[0].Backlog, total[1].Backlog, total[2].Backlog})
+	assert.Equal(t, 2*24*time.Hour, total[0].MedianTimeToClose)
+	assert.Equal(t, time.Duration(0), total[1].MedianTimeToClose, "nothing closed")
+
+	require.Len(t, trends.BySeverity, 2)
+	assert.Equal(t, "2 (High)", trends.BySeverity[0].Name)
+	assert.Equal(t, 2, trends.BySeverity[0].Periods[1].Backlog)
+	require.Len(t, trends.ByProduct, 1)
+	assert.Equal(t, "OpenShift", trends.ByProduct[0].Name)
+	assert.Equal(t, 1, trends.ByProduct[0].Periods[0].Opened, "counted once for each product, not version")
+}
+
+func TestMedian(t *testing.T) {
+	assert.Equal(t, 2*time.Hour, median([]time.Duration{3 * time.Hour, time.Hour, 2 * time.Hour}))
+	assert.Equal(t, 90*time.Minute, median([]time.Duration{2 * time.Hour, time.Hour}))
+}
+
+func TestDays(t *testing.T) {
+	assert.Equal(t, "", Days(0))
+	assert.Equal(t, "1.5", Days(36*time.Hour))
+}
+
+func TestChart(t *testing.T) {
+	trends := Trends{Interval: IntervalMonth, Total: Series{Name: "Total", Periods: []Period{
+		{Start: time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC), Opened: 4, Closed: 1, Backlog: 3},
+		{Start: time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC), Opened: 2, Closed: 5, Backlog: 0},
+	}}}
+	svg := Chart("Cases <by month>", trends.Labels(), Flow(trends.Total))
+	assert.True(t, strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg"`))
+	assert.True(t, strings.HasSuffix(svg, "</svg>"))
+	assert.Contains(t, svg, "Cases &lt;by month&gt;")
+	assert.Contains(t, svg, ">2022-03</text>")
+	assert.Contains(t, svg, `text-anchor="end">5</text>`, "the scale tops at the largest value")
+	assert.Equal(t, 3, strings.Count(svg, "<polyline"))
+	assert.Contains(t, svg, `points="48.0,165.6 480.0,28.0"`, "closed goes from 1 to the top")
+}
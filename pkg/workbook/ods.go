@@ -0,0 +1,104 @@
+package workbook
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"github.com/jwmatthews/case_watcher/pkg/spreadsheet"
+	"io"
+)
+
+const odsManifest = `<?xml version="1.0" encoding="UTF-8"?>
+<manifest:manifest xmlns:manifest="urn:oasis:names:tc:opendocument:xmlns:manifest:1.0" manifest:version="1.2">
+<manifest:file-entry manifest:full-path="/" manifest:version="1.2" manifest:media-type="application/vnd.oasis.opendocument.spreadsheet"/>
+<manifest:file-entry manifest:full-path="content.xml" manifest:media-type="text/xml"/>
+</manifest:manifest>`
+
+const odsContentStart = `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0"
+ xmlns:style="urn:oasis:names:tc:opendocument:xmlns:style:1.0"
+ xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0"
+ xmlns:table="urn:oasis:names:tc:opendocument:xmlns:table:1.0"
+ xmlns:fo="urn:oasis:names:tc:opendocument:xmlns:xsl-fo-compatible:1.0"
+ xmlns:of="urn:oasis:names:tc:opendocument:xmlns:of:1.2" office:version="1.2">
+<office:automatic-styles>
+<style:style style:name="header" style:family="table-cell"><style:text-properties fo:font-weight="bold"/></style:style>
+</office:automatic-styles>
+<office:body><office:spreadsheet>
+`
+
+const odsContentEnd = `</office:spreadsheet></office:body></office:document-content>`
+
+// WriteODS writes the tabs as an OpenDocument spreadsheet. The header row of tabs listing cases is bold.
+func WriteODS(w io.Writer, tabs []spreadsheet.Tab) error {
+	z := zip.NewWriter(w)
+	// The mimetype must come first and be stored uncompressed, so the format can be recognized by its first bytes
+	f, err := z.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err == nil {
+		_, err = io.WriteString(f, ContentTypes[FormatODS])
+	}
+	if err == nil {
+		f, err = z.Create("META-INF/manifest.xml")
+	}
+	if err == nil {
+		_, err = io.WriteString(f, odsManifest)
+	}
+	if err == nil {
+		f, err = z.Create("content.xml")
+	}
+	if err == nil {
+		_, err = io.WriteString(f, odsContent(tabs))
+	}
+	if err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+func odsContent(tabs []spreadsheet.Tab) string {
+	names := sheetNames(tabs, 0)
+	buf := bytes.Buffer{}
+	buf.WriteString(odsContentStart)
+	for i, tab := range tabs {
+		fmt.Fprintf(&buf, `<table:table table:name="%s">`, xmlText(names[i]))
+		for r, row := range tab.Values {
+			buf.WriteString(`<table:table-row>`)
+			style := ""
+			if tab.Cases && r == 0 {
+				style = ` table:style-name="header"`
+			}
+			for _, value := range row {
+				v := toCell(value)
+				switch v.Kind {
+				case cellNumber:
+					fmt.Fprintf(&buf, `<table:table-cell%s office:value-type="float" office:value="%s"><text:p>%s</text:p></table:table-cell>`,
+						style, v.Text, v.Text)
+				case cellBool:
+					label := "FALSE"
+					if v.Text == "true" {
+						label = "TRUE"
+					}
+					fmt.Fprintf(&buf, `<table:table-cell%s office:value-type="boolean" office:boolean-value="%s"><text:p>%s</text:p></table:table-cell>`,
+						style, v.Text, label)
+				case cellFormula:
+					fmt.Fprintf(&buf, `<table:table-cell%s table:formula="%s" office:value-type="string"/>`, style, xmlText("of:="+v.Text))
+				default:
+					if v.Text == "" {
+						fmt.Fprintf(&buf, `<table:table-cell%s/>`, style)
+						continue
+					}
+					fmt.Fprintf(&buf, `<table:table-cell%s office:value-type="string"><text:p>%s</text:p></table:table-cell>`,
+						style, xmlText(v.Text))
+				}
+			}
+			buf.WriteString(`</table:table-row>`)
+		}
+		if len(tab.Values) == 0 {
+			// A table needs at least one row
+			buf.WriteString(`<table:table-row><table:table-cell/></table:table-row>`)
+		}
+		buf.WriteString(`</table:table>`)
+	}
+	buf.WriteString(odsContentEnd)
+	return buf.String()
+}
@@ -0,0 +1,104 @@
+// Package workbook writes the tabs of the spreadsheet to XLSX or ODS files, for teams without Google Sheets
+package workbook
+
+import (
+	"fmt"
+	"github.com/jwmatthews/case_watcher/pkg/spreadsheet"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Formats a workbook can be written in
+const (
+	FormatXLSX = "xlsx"
+	FormatODS  = "ods"
+)
+
+// ContentTypes are the MIME types of the formats
+var ContentTypes = map[string]string{
+	FormatXLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	FormatODS:  "application/vnd.oasis.opendocument.spreadsheet",
+}
+
+// Write writes the tabs as a workbook in the given format
+func Write(w io.Writer, format string, tabs []spreadsheet.Tab) error {
+	switch format {
+	case FormatXLSX:
+		return WriteXLSX(w, tabs)
+	case FormatODS:
+		return WriteODS(w, tabs)
+	default:
+		return fmt.Errorf("unknown workbook format '%s', expected %s or %s", format, FormatXLSX, FormatODS)
+	}
+}
+
+// Kinds of cell
+const (
+	cellString = iota
+	cellNumber
+	cellBool
+	cellFormula
+)
+
+// cell is a value of a tab as a workbook stores it. Text is the number as written for cellNumber,
+// "true" or "false" for cellBool and the formula without its leading '=' for cellFormula.
+type cell struct {
+	Kind int
+	Text string
+}
+
+// toCell converts a value written to Google Sheets. Only a spreadsheet.Formula becomes a formula, strings
+// starting with '=', e.g. the summary of a case, stay text.
+func toCell(v interface{}) cell {
+	switch value := v.(type) {
+	case nil:
+		return cell{Kind: cellString}
+	case spreadsheet.Formula:
+		return cell{Kind: cellFormula, Text: strings.TrimPrefix(string(value), "=")}
+	case bool:
+		return cell{Kind: cellBool, Text: strconv.FormatBool(value)}
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return cell{Kind: cellNumber, Text: fmt.Sprint(value)}
+	case float32:
+		return cell{Kind: cellNumber, Text: strconv.FormatFloat(float64(value), 'f', -1, 32)}
+	case float64:
+		return cell{Kind: cellNumber, Text: strconv.FormatFloat(value, 'f', -1, 64)}
+	case string:
+		return cell{Kind: cellString, Text: value}
+	default:
+		return cell{Kind: cellString, Text: fmt.Sprint(value)}
+	}
+}
+
+// sheetNames returns a distinct name for each tab, without the characters workbooks do not allow in them
+// and, when maxLength is above 0, shortened to at most maxLength characters
+func sheetNames(tabs []spreadsheet.Tab, maxLength int) []string {
+	replacer := strings.NewReplacer("[", "(", "]", ")", ":", "-", "*", "-", "?", "", "/", "-", "\\", "-")
+	used := map[string]bool{}
+	names := make([]string, 0, len(tabs))
+	for i, tab := range tabs {
+		base := []rune(strings.Trim(replacer.Replace(tab.Name), "' "))
+		if len(base) == 0 {
+			base = []rune(fmt.Sprintf("Sheet%d", i+1))
+		}
+		name := string(base)
+		for n := 1; ; n++ {
+			if n > 1 {
+				suffix := fmt.Sprintf(" (%d)", n)
+				name = string(base) + suffix
+				if maxLength > 0 && len(base)+len(suffix) > maxLength {
+					name = string(base[:maxLength-len(suffix)]) + suffix
+				}
+			} else if maxLength > 0 && len(base) > maxLength {
+				name = string(base[:maxLength])
+			}
+			if !used[strings.ToLower(name)] {
+				break
+			}
+		}
+		used[strings.ToLower(name)] = true
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,110 @@
+package workbook
+
+import (
+	"archive/zip"
+	"bytes"
+	"github.com/jwmatthews/case_watcher/pkg/spreadsheet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"testing"
+)
+
+var testTabs = []spreadsheet.Tab{
+	{Name: spreadsheet.OpenCasesSheet, Cases: true, Values: [][]interface{}{
+		{"Uri", "Summary", "Escalated"},
+		{spreadsheet.Formula("=hyperlink(\"https://example.com/1\")"), "backup <fails> & restores", true},
+		{spreadsheet.Formula("=hyperlink(\"https://example.com/2\")"), "=HYPERLINK(\"http://evil\")", false},
+	}},
+	{Name: spreadsheet.SummarySheet, Values: [][]interface{}{{"Open", 3}, {"Closed", 1.5}}},
+}
+
+// readZip returns the content of each file of a zip archive, and the names in the order they were written
+func readZip(t *testing.T, data []byte) (map[string]string, []*zip.File) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	files := map[string]string{}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+		files[f.Name] = string(content)
+	}
+	return files, r.File
+}
+
+func TestWriteXLSX(t *testing.T) {
+	buf := bytes.Buffer{}
+	require.NoError(t, Write(&buf, FormatXLSX, testTabs))
+	files, _ := readZip(t, buf.Bytes())
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml"} {
+		assert.Contains(t, files, name)
+	}
+	assert.Contains(t, files["xl/workbook.xml"], `<sheet name="Open Cases" sheetId="1" r:id="rId1"/>`)
+	assert.Contains(t, files["xl/workbook.xml"], `<sheet name="Summary" sheetId="2" r:id="rId2"/>`)
+
+	open := files["xl/worksheets/sheet1.xml"]
+	assert.Contains(t, open, `state="frozen"`)
+	assert.Contains(t, open, `<c r="A1" s="1" t="inlineStr"><is><t xml:space="preserve">Uri</t></is></c>`)
+	assert.Contains(t, open, `<c r="A2" t="str"><f>hyperlink(&#34;https://example.com/1&#34;)</f></c>`)
+	assert.Contains(t, open, `backup &lt;fails&gt; &amp; restores`)
+	assert.Contains(t, open, `<c r="C2" t="b"><v>1</v></c>`)
+	assert.Contains(t, open, `<c r="B3" t="inlineStr"><is><t xml:space="preserve">=HYPERLINK(&#34;http://evil&#34;)</t></is></c>`,
+		"strings from customers are never formulas")
+	assert.NotContains(t, open, `<f>HYPERLINK`)
+
+	summary := files["xl/worksheets/sheet2.xml"]
+	assert.NotContains(t, summary, `state="frozen"`, "only tabs of cases have a frozen header")
+	assert.Contains(t, summary, `<c r="B1"><v>3</v></c>`)
+	assert.Contains(t, summary, `<c r="B2"><v>1.5</v></c>`)
+}
+
+func TestWriteODS(t *testing.T) {
+	buf := bytes.Buffer{}
+	require.NoError(t, Write(&buf, FormatODS, testTabs))
+	files, order := readZip(t, buf.Bytes())
+	require.NotEmpty(t, order)
+	assert.Equal(t, "mimetype", order[0].Name)
+	assert.Equal(t, zip.Store, order[0].Method)
+	assert.Equal(t, ContentTypes[FormatODS], files["mimetype"])
+	assert.Contains(t, files, "META-INF/manifest.xml")
+
+	content := files["content.xml"]
+	assert.Contains(t, content, `<table:table table:name="Open Cases">`)
+	assert.Contains(t, content, `<table:table-cell table:style-name="header" office:value-type="string"><text:p>Uri</text:p></table:table-cell>`)
+	assert.Contains(t, content, `table:formula="of:=hyperlink(&#34;https://example.com/1&#34;)"`)
+	assert.Contains(t, content, `office:value-type="boolean" office:boolean-value="true"`)
+	assert.Contains(t, content, `office:value-type="float" office:value="1.5"`)
+	assert.Contains(t, content, `<text:p>=HYPERLINK(&#34;http://evil&#34;)</text:p>`, "strings from customers are never formulas")
+	assert.NotContains(t, content, `of:=HYPERLINK`)
+}
+
+func TestWrite_UnknownFormat(t *testing.T) {
+	assert.Error(t, Write(&bytes.Buffer{}, "csv", testTabs))
+}
+
+func TestSheetNames(t *testing.T) {
+	tabs := []spreadsheet.Tab{
+		{Name: "Product - Red Hat OpenShift Container Platform"},
+		{Name: "Product - Red Hat OpenShift Container Platform Plus"},
+		{Name: "a/b [c]: d?"},
+		{Name: "summary"},
+		{Name: "Summary"},
+		{Name: "''"},
+	}
+	names := sheetNames(tabs, 31)
+	assert.Equal(t, []string{
+		"Product - Red Hat OpenShift Con",
+		"Product - Red Hat OpenShift (2)",
+		"a-b (c)- d",
+		"summary",
+		"Summary (2)",
+		"Sheet6",
+	}, names)
+	for _, name := range names {
+		assert.LessOrEqual(t, len([]rune(name)), 31)
+	}
+	assert.Equal(t, "Product - Red Hat OpenShift Container Platform Plus", sheetNames(tabs, 0)[1])
+}